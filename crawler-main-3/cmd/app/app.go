@@ -1,55 +1,224 @@
+// Command app aggregates the fields of the JSON, NDJSON, CSV and YAML files under a directory.
+//
+// Usage:
+//
+//	app [flags] spec...
+//
+// Every spec is a query of comma-separated terms, e.g.
+//
+//	app -root ./tests sum:data
+//	app -root ./orders -include '*.json' count min:price max:ts groupby:region,sum:amount
+//
+// See aggregate.Parse for the syntax of the specs. Without specs the records are counted.
 package main
 
 import (
 	"context"
+	"crawler/internal/aggregate"
 	crawler "crawler/internal/filecrawler"
 	"crawler/internal/fs"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"time"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 )
 
-type TestType struct {
-	Data int64 `json:"data"`
-}
+// errUsage marks the errors of the command line, which are reported with the usage.
+var errUsage = errors.New("usage")
+
+// patterns is a flag that may be repeated.
+type patterns []string
 
-type TestAccumulator struct {
-	Sum int64 `json:"sum"`
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
 }
 
-func accum(current TestType, accum TestAccumulator) TestAccumulator {
-	time.Sleep(time.Second)
-	accum.Sum += current.Data
-	return accum
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
 }
 
-func combiner(first, second TestAccumulator) TestAccumulator {
-	second.Sum += first.Sum
-	return second
+// options are the parsed command line.
+type options struct {
+	root   string
+	format string
+	conf   crawler.Configuration
+	specs  []string
 }
 
-func main() {
-	ctx := context.Background()
-	wd, err := os.Getwd()
+func parseOptions(args []string, output io.Writer) (options, error) {
+	var (
+		opts             options
+		include, exclude patterns
+	)
+
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(output, "usage: app [flags] spec...")
+		_, _ = fmt.Fprintln(output, "specs: count, sum:field, avg:field, min:field, max:field, groupby:field,...")
+		flags.PrintDefaults()
+	}
+
+	workers := runtime.NumCPU()
+
+	flags.StringVar(&opts.root, "root", ".", "directory to crawl")
+	flags.StringVar(&opts.format, "format", "table", "output format: table or json")
+	flags.IntVar(&opts.conf.SearchWorkers, "search-workers", workers, "number of directory search workers")
+	flags.IntVar(&opts.conf.FileWorkers, "file-workers", workers, "number of file decoding workers")
+	flags.IntVar(&opts.conf.AccumulatorWorkers, "accumulator-workers", workers, "number of accumulator workers")
+	flags.Var(&include, "include", "glob pattern of the files to process, may be repeated")
+	flags.Var(&exclude, "exclude", "glob pattern of the files and directories to skip, may be repeated")
+	flags.BoolVar(&opts.conf.SkipHidden, "skip-hidden", false, "skip hidden files and directories")
+	flags.IntVar(&opts.conf.MaxDepth, "max-depth", 0, "maximum depth of the crawl, 0 for no limit")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return options{}, err
+		}
+
+		return options{}, fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	if opts.format != "table" && opts.format != "json" {
+		return options{}, fmt.Errorf("unknown format %q", opts.format)
+	}
+
+	opts.conf.Include, opts.conf.Exclude = include, exclude
 
+	opts.specs = flags.Args()
+	if len(opts.specs) == 0 {
+		opts.specs = []string{string(aggregate.Count)}
+	}
+
+	return opts, nil
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	opts, err := parseOptions(args, stderr)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	root := filepath.Join(wd, "tests")
-	fmt.Println(root)
+	queries, err := aggregate.ParseAll(opts.specs)
+	if err != nil {
+		return err
+	}
 
-	c := crawler.New[TestType, TestAccumulator]()
-	result, err := c.Collect(ctx, fs.NewOsFileSystem(), root, crawler.Configuration{
-		SearchWorkers:      10,
-		FileWorkers:        10,
-		AccumulatorWorkers: 10,
-	}, accum, combiner)
+	c := crawler.New[aggregate.Record, *aggregate.Result]()
 
+	result, err := c.Collect(ctx, fs.NewOsFileSystem(), opts.root, opts.conf, queries.Accumulate, aggregate.Combine)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if opts.format == "json" {
+		return writeJSON(stdout, queries, result)
+	}
+
+	return writeTable(stdout, queries, result)
+}
+
+// writeJSON writes an object per query, holding the spec and the rows keyed by the columns.
+func writeJSON(output io.Writer, queries aggregate.Queries, result *aggregate.Result) error {
+	type queryResult struct {
+		Query string           `json:"query"`
+		Rows  []map[string]any `json:"rows"`
+	}
+
+	results := make([]queryResult, 0, len(queries))
+
+	for i, query := range queries {
+		columns := query.Columns()
+		rows := make([]map[string]any, 0)
+
+		for _, row := range queries.Rows(result, i) {
+			object := make(map[string]any, len(columns))
+			for j, column := range columns {
+				object[column] = row[j]
+			}
+
+			rows = append(rows, object)
+		}
+
+		results = append(results, queryResult{Query: query.Spec, Rows: rows})
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(results)
+}
+
+// writeTable writes a table per query, separated by empty lines.
+func writeTable(output io.Writer, queries aggregate.Queries, result *aggregate.Result) error {
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+
+	for i, query := range queries {
+		if i > 0 {
+			_, _ = fmt.Fprintln(table)
+		}
+
+		_, _ = fmt.Fprintln(table, strings.Join(query.Columns(), "\t"))
+
+		for _, row := range queries.Rows(result, i) {
+			cells := make([]string, 0, len(row))
+			for _, value := range row {
+				cells = append(cells, format(value))
+			}
+
+			_, _ = fmt.Fprintln(table, strings.Join(cells, "\t"))
+		}
 	}
 
-	fmt.Println(result.Sum) // 300
+	return table.Flush()
+}
+
+func format(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "-"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return value
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+
+		return string(data)
+	}
+}
+
+// exitCode returns the exit status of the command for the error of run: 0 on success
+// and for -help, 2 for the errors of the command line, already reported with the usage,
+// and 1 for the other errors, which are reported to stderr.
+func exitCode(err error, stderr io.Writer) int {
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		_, _ = fmt.Fprintln(stderr, "app:", err)
+		return 1
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if code := exitCode(run(ctx, os.Args[1:], os.Stdout, os.Stderr), os.Stderr); code != 0 {
+		os.Exit(code)
+	}
 }
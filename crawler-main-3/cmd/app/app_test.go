@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixture writes the records of the test files, in JSON and NDJSON, and returns their root.
+func fixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"a.json":       `{"data": 1, "region": "eu"}`,
+		"sub/b.ndjson": "{\"data\": 2, \"region\": \"us\"}\n{\"data\": 3, \"region\": \"eu\"}\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	return root
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	root := fixture(t)
+
+	t.Run("table", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		err := run(ctx, []string{"-root", root, "sum:data", "groupby:region,sum:data"}, &stdout, &stderr)
+		require.NoError(t, err)
+		require.Empty(t, stderr.String())
+		require.Equal(t, "sum:data\n6\n\nregion  sum:data\neu      4\nus      2\n", stdout.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		err := run(ctx, []string{"-root", root, "-format", "json", "count", "groupby:region,sum:data"}, &stdout, &stderr)
+		require.NoError(t, err)
+
+		var results []struct {
+			Query string           `json:"query"`
+			Rows  []map[string]any `json:"rows"`
+		}
+
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &results))
+		require.Len(t, results, 2)
+		require.Equal(t, "count", results[0].Query)
+		require.Equal(t, []map[string]any{{"count": 3.}}, results[0].Rows)
+		require.Equal(t, "groupby:region,sum:data", results[1].Query)
+		require.Equal(t, []map[string]any{
+			{"region": "eu", "sum:data": 4.},
+			{"region": "us", "sum:data": 2.},
+		}, results[1].Rows)
+	})
+
+	t.Run("count by default", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		require.NoError(t, run(ctx, []string{"-root", root}, &stdout, &stderr))
+		require.Equal(t, "count\n3\n", stdout.String())
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		require.NoError(t, run(ctx, []string{"-root", root, "-max-depth", "1"}, &stdout, &stderr))
+		require.Equal(t, "count\n1\n", stdout.String())
+	})
+}
+
+func TestParseOptions(t *testing.T) {
+	var output bytes.Buffer
+
+	opts, err := parseOptions([]string{"-include", "*.json", "-include", "*.ndjson", "-file-workers", "3"}, &output)
+	require.NoError(t, err)
+	require.Equal(t, ".", opts.root)
+	require.Equal(t, "table", opts.format)
+	require.Equal(t, []string{"count"}, opts.specs)
+	require.Equal(t, []string{"*.json", "*.ndjson"}, opts.conf.Include)
+	require.Equal(t, 3, opts.conf.FileWorkers)
+
+	for _, args := range [][]string{
+		{"-bogus"},
+		{"-file-workers", "many"},
+		{"-root"},
+	} {
+		output.Reset()
+
+		_, err := parseOptions(args, &output)
+		require.ErrorIs(t, err, errUsage, args)
+		require.Contains(t, output.String(), "usage: app", args)
+	}
+
+	_, err = parseOptions([]string{"-help"}, &output)
+	require.ErrorIs(t, err, flag.ErrHelp)
+
+	_, err = parseOptions([]string{"-format", "xml"}, &output)
+	require.ErrorContains(t, err, `unknown format "xml"`)
+	require.NotErrorIs(t, err, errUsage)
+}
+
+func TestExitCode(t *testing.T) {
+	ctx := context.Background()
+	root := fixture(t)
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{name: "success", args: []string{"-root", root}, code: 0},
+		{name: "help", args: []string{"-help"}, code: 0, stderr: "usage: app"},
+		{name: "unknown flag", args: []string{"-bogus"}, code: 2, stderr: "flag provided but not defined"},
+		{name: "unknown format", args: []string{"-format", "xml"}, code: 1, stderr: `app: unknown format "xml"`},
+		{name: "invalid spec", args: []string{"-root", root, "median:data"}, code: 1, stderr: "app: "},
+		{name: "missing root", args: []string{"-root", filepath.Join(root, "missing")}, code: 1, stderr: "app: "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := exitCode(run(ctx, test.args, &stdout, &stderr), &stderr)
+			require.Equal(t, test.code, code)
+
+			if test.stderr == "" {
+				require.Empty(t, stderr.String())
+			} else {
+				require.Contains(t, stderr.String(), test.stderr)
+			}
+		})
+	}
+}
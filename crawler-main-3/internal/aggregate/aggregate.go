@@ -0,0 +1,396 @@
+// Package aggregate computes aggregations over dynamic records, such as JSON objects decoded
+// into map[string]any, described by specs like "sum:data", "count", "min:price" or
+// "groupby:region,sum:amount". Queries.Accumulate and Combine are the accumulator and
+// the combiner of the crawler.
+package aggregate
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Record is a decoded object.
+type Record = map[string]any
+
+// Func is an aggregation function.
+type Func string
+
+const (
+	Sum   Func = "sum"   // the sum of the numeric values of the field
+	Count Func = "count" // the number of records, or of the records having the field
+	Min   Func = "min"   // the least number or string of the field
+	Max   Func = "max"   // the greatest number or string of the field
+	Avg   Func = "avg"   // the mean of the numeric values of the field
+)
+
+// Aggregate is a function applied to a field. The field is a dot-separated path
+// into nested objects, e.g. "user.age", and may be empty only for Count.
+type Aggregate struct {
+	Func  Func
+	Field string
+}
+
+// String returns the spec of the aggregate, which is also its column name.
+func (a Aggregate) String() string {
+	if a.Field == "" {
+		return string(a.Func)
+	}
+
+	return string(a.Func) + ":" + a.Field
+}
+
+// Query is a parsed spec: the aggregates computed for every group of records
+// with equal values of the GroupBy fields, or for all records if there are none.
+type Query struct {
+	Spec       string
+	GroupBy    []string
+	Aggregates []Aggregate
+}
+
+// Columns returns the names of the group fields followed by the names of the aggregates.
+func (q Query) Columns() []string {
+	columns := slices.Clone(q.GroupBy)
+	for _, aggregate := range q.Aggregates {
+		columns = append(columns, aggregate.String())
+	}
+
+	return columns
+}
+
+// Parse parses a spec: comma-separated terms, each of them "groupby:field",
+// "count", or a function and a field separated by a colon, e.g. "groupby:region,sum:amount".
+// Several groupby terms group by all their fields. A spec needs at least one aggregate.
+func Parse(spec string) (Query, error) {
+	query := Query{Spec: spec}
+
+	for _, term := range strings.Split(spec, ",") {
+		name, field, _ := strings.Cut(strings.TrimSpace(term), ":")
+
+		switch Func(name) {
+		case Count:
+		case Sum, Min, Max, Avg:
+			if field == "" {
+				return Query{}, fmt.Errorf("spec %q: %s requires a field", spec, name)
+			}
+		default:
+			if name != "groupby" {
+				return Query{}, fmt.Errorf("spec %q: unknown function %q", spec, name)
+			}
+
+			if field == "" {
+				return Query{}, fmt.Errorf("spec %q: groupby requires a field", spec)
+			}
+
+			query.GroupBy = append(query.GroupBy, field)
+
+			continue
+		}
+
+		query.Aggregates = append(query.Aggregates, Aggregate{Func: Func(name), Field: field})
+	}
+
+	if len(query.Aggregates) == 0 {
+		return Query{}, fmt.Errorf("spec %q: no aggregates", spec)
+	}
+
+	return query, nil
+}
+
+// Queries are the queries computed in one pass over the records.
+type Queries []Query
+
+// ParseAll parses every spec, see Parse.
+func ParseAll(specs []string) (Queries, error) {
+	queries := make(Queries, 0, len(specs))
+
+	for _, spec := range specs {
+		query, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		queries = append(queries, query)
+	}
+
+	return queries, nil
+}
+
+// Result holds the groups of the queries accumulated so far. A nil Result is empty.
+type Result struct {
+	groups []map[string]*group
+}
+
+// group is the state of the aggregates of a group.
+type group struct {
+	values []any
+	cells  []cell
+}
+
+// cell is the state of an aggregate.
+type cell struct {
+	count int64
+	sum   float64
+	min   any
+	max   any
+}
+
+func newResult(queries Queries) *Result {
+	result := &Result{groups: make([]map[string]*group, len(queries))}
+	for i := range result.groups {
+		result.groups[i] = make(map[string]*group)
+	}
+
+	return result
+}
+
+// Accumulate adds the record to the result of the queries, starting a new result
+// if it is nil, and returns it. It is safe for concurrent use with distinct results.
+func (q Queries) Accumulate(record Record, result *Result) *Result {
+	if result == nil {
+		result = newResult(q)
+	}
+
+	for i, query := range q {
+		result.group(query, i, record).add(query, record)
+	}
+
+	return result
+}
+
+// Combine merges the current result into the accumulated one and returns it.
+// The results must be accumulated for the same queries.
+func Combine(current, accum *Result) *Result {
+	if current == nil {
+		return accum
+	}
+
+	if accum == nil {
+		return current
+	}
+
+	for i, groups := range current.groups {
+		for key, other := range groups {
+			existing, ok := accum.groups[i][key]
+			if !ok {
+				accum.groups[i][key] = other
+				continue
+			}
+
+			for j := range existing.cells {
+				existing.cells[j].merge(other.cells[j])
+			}
+		}
+	}
+
+	return accum
+}
+
+// Row is a group of a query: the values of the group fields followed by the values
+// of the aggregates, in the order of Query.Columns. The aggregates of no values are nil,
+// except for the counts and sums, which are zero.
+type Row []any
+
+// Rows returns the groups of the result of the query with the index, sorted by the values
+// of the group fields. A query without group fields has exactly one row.
+func (q Queries) Rows(result *Result, index int) []Row {
+	query := q[index]
+
+	if result == nil || len(result.groups[index]) == 0 {
+		if len(query.GroupBy) > 0 {
+			return nil
+		}
+
+		return []Row{(&group{cells: make([]cell, len(query.Aggregates))}).row(query)}
+	}
+
+	groups := make([]*group, 0, len(result.groups[index]))
+	for _, g := range result.groups[index] {
+		groups = append(groups, g)
+	}
+
+	slices.SortFunc(groups, func(a, b *group) int {
+		return slices.CompareFunc(a.values, b.values, compare)
+	})
+
+	rows := make([]Row, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, g.row(query))
+	}
+
+	return rows
+}
+
+// group returns the group of the record in the query with the index, creating it if needed.
+func (r *Result) group(query Query, index int, record Record) *group {
+	values := make([]any, len(query.GroupBy))
+	keys := make([]string, len(query.GroupBy))
+
+	for i, field := range query.GroupBy {
+		values[i], _ = lookup(record, field)
+		keys[i] = fmt.Sprintf("%T:%v", values[i], values[i])
+	}
+
+	key := strings.Join(keys, "\x00")
+
+	g, ok := r.groups[index][key]
+	if !ok {
+		g = &group{values: values, cells: make([]cell, len(query.Aggregates))}
+		r.groups[index][key] = g
+	}
+
+	return g
+}
+
+func (g *group) add(query Query, record Record) {
+	for i, aggregate := range query.Aggregates {
+		c := &g.cells[i]
+
+		if aggregate.Field == "" {
+			c.count++
+			continue
+		}
+
+		value, ok := lookup(record, aggregate.Field)
+		if !ok || value == nil {
+			continue
+		}
+
+		switch aggregate.Func {
+		case Count:
+			c.count++
+		case Sum, Avg:
+			if number, ok := value.(float64); ok {
+				c.count++
+				c.sum += number
+			}
+		case Min, Max:
+			if !ordered(value) {
+				continue
+			}
+
+			c.count++
+
+			if c.min == nil || compare(value, c.min) < 0 {
+				c.min = value
+			}
+
+			if c.max == nil || compare(value, c.max) > 0 {
+				c.max = value
+			}
+		}
+	}
+}
+
+func (g *group) row(query Query) Row {
+	row := make(Row, 0, len(g.values)+len(g.cells))
+	row = append(row, g.values...)
+
+	for i, aggregate := range query.Aggregates {
+		c := g.cells[i]
+
+		switch aggregate.Func {
+		case Count:
+			row = append(row, c.count)
+		case Sum:
+			row = append(row, c.sum)
+		case Avg:
+			if c.count == 0 {
+				row = append(row, nil)
+			} else {
+				row = append(row, c.sum/float64(c.count))
+			}
+		case Min:
+			row = append(row, c.min)
+		case Max:
+			row = append(row, c.max)
+		}
+	}
+
+	return row
+}
+
+func (c *cell) merge(other cell) {
+	c.count += other.count
+	c.sum += other.sum
+
+	if other.min != nil && (c.min == nil || compare(other.min, c.min) < 0) {
+		c.min = other.min
+	}
+
+	if other.max != nil && (c.max == nil || compare(other.max, c.max) > 0) {
+		c.max = other.max
+	}
+}
+
+// lookup returns the value of the dot-separated field of the record.
+func lookup(record Record, field string) (any, bool) {
+	var value any = record
+
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// ordered reports whether min and max apply to the value: numbers and strings.
+func ordered(value any) bool {
+	switch value.(type) {
+	case float64, string:
+		return true
+	default:
+		return false
+	}
+}
+
+// compare orders the values: nil, booleans, numbers, strings,
+// and then any other values by their formatting.
+func compare(a, b any) int {
+	if rank(a) != rank(b) {
+		return cmp.Compare(rank(a), rank(b))
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case a:
+			return 1
+		default:
+			return -1
+		}
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func rank(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	default:
+		return 4
+	}
+}
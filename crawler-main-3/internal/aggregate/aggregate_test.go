@@ -0,0 +1,87 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func records(t *testing.T, documents ...string) []Record {
+	t.Helper()
+
+	result := make([]Record, 0, len(documents))
+
+	for _, document := range documents {
+		var record Record
+		require.NoError(t, json.Unmarshal([]byte(document), &record))
+
+		result = append(result, record)
+	}
+
+	return result
+}
+
+func TestParse(t *testing.T) {
+	query, err := Parse("groupby:region, sum:amount,count,max:user.age")
+	require.NoError(t, err)
+	require.Equal(t, []string{"region"}, query.GroupBy)
+	require.Equal(t, []Aggregate{{Sum, "amount"}, {Count, ""}, {Max, "user.age"}}, query.Aggregates)
+	require.Equal(t, []string{"region", "sum:amount", "count", "max:user.age"}, query.Columns())
+
+	for _, spec := range []string{"", "groupby:region", "sum", "median:price", "groupby:,count"} {
+		_, err = Parse(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	queries, err := ParseAll([]string{
+		"sum:data,count,count:data,avg:data",
+		"min:price,max:price,min:ts,max:ts",
+		"groupby:region,sum:amount,count",
+		"groupby:region,groupby:user.name,count",
+	})
+	require.NoError(t, err)
+
+	all := records(t,
+		`{"data": 1, "price": 9.5, "ts": "2024-03-01", "region": "eu", "amount": 10, "user": {"name": "a"}}`,
+		`{"data": 2, "price": 3, "ts": "2024-01-01", "region": "us", "amount": 5, "user": {"name": "a"}}`,
+		`{"data": "x", "price": 12, "ts": "2024-02-01", "region": "eu", "amount": 7, "user": {"name": "b"}}`,
+		`{"price": "n/a", "amount": 1}`,
+	)
+
+	// accumulate in two parts, as the workers of the crawler do
+	first := queries.Accumulate(all[0], nil)
+	first = queries.Accumulate(all[1], first)
+
+	var second *Result
+	for _, record := range all[2:] {
+		second = queries.Accumulate(record, second)
+	}
+
+	result := Combine(second, Combine(first, nil))
+
+	require.Equal(t, []Row{{3.0, int64(4), int64(3), 1.5}}, queries.Rows(result, 0))
+	require.Equal(t, []Row{{3.0, "n/a", "2024-01-01", "2024-03-01"}}, queries.Rows(result, 1))
+	require.Equal(t, []Row{
+		{nil, 1.0, int64(1)},
+		{"eu", 17.0, int64(2)},
+		{"us", 5.0, int64(1)},
+	}, queries.Rows(result, 2))
+	require.Equal(t, []Row{
+		{nil, nil, int64(1)},
+		{"eu", "a", int64(1)},
+		{"eu", "b", int64(1)},
+		{"us", "a", int64(1)},
+	}, queries.Rows(result, 3))
+}
+
+func TestEmpty(t *testing.T) {
+	queries, err := ParseAll([]string{"sum:data,count,min:data", "groupby:region,count"})
+	require.NoError(t, err)
+
+	require.Equal(t, []Row{{0.0, int64(0), nil}}, queries.Rows(nil, 0))
+	require.Empty(t, queries.Rows(nil, 1))
+	require.Nil(t, Combine(nil, nil))
+}
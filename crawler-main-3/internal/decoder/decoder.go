@@ -0,0 +1,462 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decoder reads values of type T from the contents of a single file one by one.
+// A file of one kind may yield many values, e.g. one per NDJSON line or CSV row.
+// Decoder is used by a single goroutine and does not have to be thread-safe.
+type Decoder[T any] interface {
+	// Decode returns the next value of the file. It returns io.EOF when there are no values left.
+	Decode() (T, error)
+}
+
+// Untyped is the decoder created by a Format, which decodes the next value into the value
+// pointed to by v, as with encoding/json. It adapts the decoders to the Registry, so that
+// a single registry serves the crawlers of any value type: the values are read through New,
+// and a Decoder[T] is registered with FormatOf.
+type Untyped interface {
+	// Decode decodes the next value from the file into the value pointed to by v.
+	// It returns io.EOF when there are no values left.
+	Decode(v any) error
+}
+
+// Format creates the decoder reading the file contents from r.
+// Format must be thread-safe, as it is called concurrently by multiple file workers.
+type Format func(r io.Reader) Untyped
+
+// New returns the decoder of the values of type T read from r in the format.
+func New[T any](format Format, r io.Reader) Decoder[T] {
+	return &typedDecoder[T]{decoder: format(r)}
+}
+
+// FormatOf returns the format creating the decoders with open, so that a Decoder[T]
+// can be registered. Its values can only be read as values of type T.
+func FormatOf[T any](open func(r io.Reader) Decoder[T]) Format {
+	return func(r io.Reader) Untyped {
+		return &formatDecoder[T]{decoder: open(r)}
+	}
+}
+
+// Registry maps file extensions, including the leading dot (e.g. ".json"),
+// to the formats used to decode them. Extensions are matched case-insensitively.
+type Registry map[string]Format
+
+// Default returns a new registry with the formats supported out of the box.
+func Default() Registry {
+	return Registry{
+		".json":   JSON,
+		".ndjson": NDJSON,
+		".jsonl":  NDJSON,
+		".csv":    CSV,
+		".yaml":   YAML,
+		".yml":    YAML,
+		".gob":    Gob,
+	}
+}
+
+// Lookup returns the format registered for the extension of the path.
+func (r Registry) Lookup(path string) (Format, bool) {
+	format, ok := r[strings.ToLower(filepath.Ext(path))]
+	return format, ok
+}
+
+// Merge returns a new registry with the formats of r overridden by the ones of other.
+func (r Registry) Merge(other Registry) Registry {
+	merged := make(Registry, len(r)+len(other))
+
+	for ext, format := range r {
+		merged[strings.ToLower(ext)] = format
+	}
+
+	for ext, format := range other {
+		merged[strings.ToLower(ext)] = format
+	}
+
+	return merged
+}
+
+// JSON decodes a file holding exactly one JSON document.
+// It reads no further than the end of the document.
+func JSON(r io.Reader) Untyped {
+	return &singleDecoder{decoder: json.NewDecoder(r)}
+}
+
+// NDJSON decodes a stream of JSON documents, e.g. one per line.
+func NDJSON(r io.Reader) Untyped {
+	return json.NewDecoder(r)
+}
+
+// JSONArray decodes a file holding a JSON array, yielding one value per element. The array
+// is read token by token, so only the element being decoded is held in memory, whatever the
+// size of the file. An element that cannot be decoded into the target, e.g. of a mismatching
+// type, is reported by a *ValueError, and the next call decodes the following element;
+// a syntax error ends the decoding. An empty file holds no values.
+func JSONArray(r io.Reader) Untyped {
+	return &arrayDecoder{decoder: json.NewDecoder(r)}
+}
+
+// ValueError is returned by a Decoder for a value that could not be decoded into the target,
+// after which the following values can still be decoded.
+type ValueError struct {
+	Index int // index of the value in the file
+	Err   error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("value %d: %v", e.Index, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// Gob decodes a stream of gob-encoded values.
+func Gob(r io.Reader) Untyped {
+	return gob.NewDecoder(r)
+}
+
+// YAML decodes a stream of YAML documents separated by "---".
+// Every document is converted to JSON first, so the target types use json tags.
+func YAML(r io.Reader) Untyped {
+	return &yamlDecoder{decoder: yaml.NewDecoder(r)}
+}
+
+// CSV decodes a CSV file with a header row, yielding one value per row.
+// A row is converted to a JSON object keyed by the header, so the target types
+// use json tags. Cells holding JSON numbers, booleans or null are decoded as such,
+// unless the target field is a string; other cells are decoded as strings.
+func CSV(r io.Reader) Untyped {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	return &csvDecoder{reader: reader}
+}
+
+// sniffSize is the maximum number of bytes inspected by Sniff.
+const sniffSize = 512
+
+// Sniff chooses the format by the file contents:
+//   - a document starting with '{' is decoded as a JSON stream, see NDJSON;
+//   - a document starting with '[' is decoded as a JSON array, see JSONArray;
+//   - binary contents (invalid UTF-8 or NUL bytes) are decoded as gob;
+//   - a first line containing a comma and no colon is decoded as CSV;
+//   - anything else is decoded as YAML.
+func Sniff(r io.Reader) Untyped {
+	reader := bufio.NewReaderSize(r, sniffSize)
+	head, _ := reader.Peek(sniffSize)
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		return NDJSON(reader)
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		return JSONArray(reader)
+	case binary(head):
+		return Gob(reader)
+	case bytes.Contains(firstLine, []byte(",")) && !bytes.Contains(firstLine, []byte(":")):
+		return CSV(reader)
+	default:
+		return YAML(reader)
+	}
+}
+
+// magicSize is the number of bytes inspected by Decompress.
+const magicSize = 10
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b, 0x08}
+	bzip2Magic = []byte("BZh")
+
+	// the magic numbers of the first block and of the end of an empty stream
+	bzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// Decompress returns the reader of the decompressed contents of a gzip or bzip2 file, the path
+// without its compression extension, which tells the format of the contents, and whether the
+// file is compressed. A file is recognised by the extension .gz or .bz2, or else by the magic
+// bytes of its contents; the reader of another file yields its contents unchanged.
+// The error reports an invalid or truncated gzip header.
+func Decompress(r io.Reader, path string) (io.Reader, string, bool, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	name := strings.TrimSuffix(path, filepath.Ext(path))
+
+	switch ext {
+	case ".gz":
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, name, true, err
+		}
+
+		return reader, name, true, nil
+	case ".bz2":
+		return bzip2.NewReader(r), name, true, nil
+	}
+
+	reader := bufio.NewReader(r)
+	head, _ := reader.Peek(magicSize)
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, path, true, err
+		}
+
+		return decompressed, path, true, nil
+	case bzip2Header(head):
+		return bzip2.NewReader(reader), path, true, nil
+	default:
+		return reader, path, false, nil
+	}
+}
+
+// bzip2Header reports whether the head of a file is the header of a bzip2 stream:
+// "BZh", the block size from '1' to '9' and the magic number of a block or of the end.
+func bzip2Header(head []byte) bool {
+	if len(head) < magicSize || !bytes.HasPrefix(head, bzip2Magic) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+
+	magic := head[4:magicSize]
+
+	return bytes.Equal(magic, bzip2Block) || bytes.Equal(magic, bzip2End)
+}
+
+// binary reports whether the head of a file is not a UTF-8 text.
+func binary(head []byte) bool {
+	// a multibyte rune may be cut at the end of the peeked bytes
+	for i := 0; i < utf8.UTFMax-1 && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+
+	return !utf8.Valid(head) || bytes.IndexByte(head, 0) >= 0
+}
+
+// typedDecoder reads the values of an untyped decoder as values of type T.
+type typedDecoder[T any] struct {
+	decoder Untyped
+}
+
+func (d *typedDecoder[T]) Decode() (T, error) {
+	var value T
+	err := d.decoder.Decode(&value)
+
+	return value, err
+}
+
+// formatDecoder decodes the values of a Decoder[T] into targets of type *T.
+type formatDecoder[T any] struct {
+	decoder Decoder[T]
+}
+
+func (d *formatDecoder[T]) Decode(v any) error {
+	target, ok := v.(*T)
+	if !ok {
+		return fmt.Errorf("decode %T values into %T", *new(T), v)
+	}
+
+	value, err := d.decoder.Decode()
+	if err != nil {
+		return err
+	}
+
+	*target = value
+
+	return nil
+}
+
+// singleDecoder yields exactly one document of the underlying decoder.
+type singleDecoder struct {
+	decoder Untyped
+	done    bool
+}
+
+func (d *singleDecoder) Decode(v any) error {
+	if d.done {
+		return io.EOF
+	}
+
+	d.done = true
+
+	if err := d.decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// arrayDecoder yields the elements of a JSON array one by one.
+type arrayDecoder struct {
+	decoder *json.Decoder
+	started bool
+	done    bool
+	index   int
+}
+
+func (d *arrayDecoder) Decode(v any) error {
+	if d.done {
+		return io.EOF
+	}
+
+	if !d.started {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("expected a JSON array, found %v", token)
+		}
+
+		d.started = true
+	}
+
+	if !d.decoder.More() {
+		d.done = true
+
+		// the closing bracket, which must end the file
+		if _, err := d.decoder.Token(); err != nil {
+			return err
+		}
+
+		if _, err := d.decoder.Token(); !errors.Is(err, io.EOF) {
+			if err == nil {
+				err = errors.New("data after the JSON array")
+			}
+
+			return err
+		}
+
+		return io.EOF
+	}
+
+	index := d.index
+	d.index++
+
+	if err := d.decoder.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &ValueError{Index: index, Err: err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// yamlDecoder converts YAML documents to JSON before decoding them.
+type yamlDecoder struct {
+	decoder *yaml.Decoder
+}
+
+func (d *yamlDecoder) Decode(v any) error {
+	var document any
+	if err := d.decoder.Decode(&document); err != nil {
+		return err
+	}
+
+	return remarshal(document, v)
+}
+
+// csvDecoder converts CSV rows to JSON objects before decoding them.
+type csvDecoder struct {
+	reader *csv.Reader
+	header []string
+}
+
+func (d *csvDecoder) Decode(v any) error {
+	if d.header == nil {
+		header, err := d.reader.Read()
+		if err != nil {
+			return err
+		}
+
+		d.header = append([]string(nil), header...)
+	}
+
+	row, err := d.reader.Read()
+	if err != nil {
+		return err
+	}
+
+	object := make(map[string]json.RawMessage, len(d.header))
+	cells := make(map[string]string, len(d.header))
+
+	for i, name := range d.header {
+		if i >= len(row) {
+			break
+		}
+
+		object[name] = cell(row[i])
+		cells[name] = row[i]
+	}
+
+	// a literal cell may be decoded into a string field, retry such cells as strings
+	for {
+		err = remarshal(object, v)
+
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Value == "string" {
+			return err
+		}
+
+		value, ok := cells[typeErr.Field]
+		if !ok {
+			return err
+		}
+
+		delete(cells, typeErr.Field)
+		object[typeErr.Field], _ = json.Marshal(value)
+	}
+}
+
+// cell converts a CSV cell to a JSON value.
+func cell(value string) json.RawMessage {
+	trimmed := strings.TrimSpace(value)
+
+	var literal any
+	if err := json.Unmarshal([]byte(trimmed), &literal); err == nil {
+		switch literal.(type) {
+		case float64, bool, nil:
+			return json.RawMessage(trimmed)
+		}
+	}
+
+	quoted, _ := json.Marshal(value)
+
+	return quoted
+}
+
+// remarshal decodes the generic document into v through JSON.
+func remarshal(document any, v any) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("convert to json: %w", err)
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,272 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Ok    bool    `json:"ok"`
+}
+
+func decodeAll(t *testing.T, d Decoder[record]) []record {
+	t.Helper()
+
+	result := make([]record, 0)
+
+	for {
+		r, err := d.Decode()
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+
+		require.NoError(t, err)
+		result = append(result, r)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	records := decodeAll(t, New[record](JSON, strings.NewReader(`{"name": "a", "value": 1} {"name": "b"}`)))
+	require.Equal(t, []record{{Name: "a", Value: 1}}, records)
+
+	var r record
+	require.ErrorIs(t, JSON(strings.NewReader("")).Decode(&r), io.ErrUnexpectedEOF)
+}
+
+func TestNDJSON(t *testing.T) {
+	t.Parallel()
+
+	records := decodeAll(t, New[record](NDJSON, strings.NewReader("{\"name\": \"a\"}\n{\"value\": 2}\n")))
+	require.Equal(t, []record{{Name: "a"}, {Value: 2}}, records)
+}
+
+func TestJSONArray(t *testing.T) {
+	t.Parallel()
+
+	records := decodeAll(t, New[record](JSONArray, strings.NewReader(` [{"name": "a", "value": 1}, {"name": "b"}] `)))
+	require.Equal(t, []record{{Name: "a", Value: 1}, {Name: "b"}}, records)
+
+	require.Empty(t, decodeAll(t, New[record](JSONArray, strings.NewReader("[]"))))
+	require.Empty(t, decodeAll(t, New[record](JSONArray, strings.NewReader(""))))
+
+	// a mismatching element is skipped
+	d := JSONArray(strings.NewReader(`[{"name": "a"}, {"name": 2}, {"name": "c"}]`))
+
+	var r record
+	require.NoError(t, d.Decode(&r))
+
+	var valueErr *ValueError
+	require.ErrorAs(t, d.Decode(&record{}), &valueErr)
+	require.Equal(t, 1, valueErr.Index)
+
+	r = record{}
+	require.NoError(t, d.Decode(&r))
+	require.Equal(t, record{Name: "c"}, r)
+	require.ErrorIs(t, d.Decode(&r), io.EOF)
+
+	for _, input := range []string{`{"name": "a"}`, `[{"name": "a"}`, `[{"name": "a"},]`, `[] []`} {
+		d := JSONArray(strings.NewReader(input))
+
+		var err error
+		for err == nil {
+			err = d.Decode(&record{})
+		}
+
+		require.NotErrorIs(t, err, io.EOF, input)
+		require.False(t, errors.As(err, &valueErr), input)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	t.Parallel()
+
+	input := "name,value,ok\na,1.5,true\n42,-3,false\n"
+
+	records := decodeAll(t, New[record](CSV, strings.NewReader(input)))
+	require.Equal(t, []record{
+		{Name: "a", Value: 1.5, Ok: true},
+		{Name: "42", Value: -3},
+	}, records)
+
+	var r record
+	require.Error(t, CSV(strings.NewReader("value\nabc\n")).Decode(&r))
+}
+
+func TestYAML(t *testing.T) {
+	t.Parallel()
+
+	input := "name: a\nvalue: 1\n---\nname: b\nok: true\n"
+
+	records := decodeAll(t, New[record](YAML, strings.NewReader(input)))
+	require.Equal(t, []record{{Name: "a", Value: 1}, {Name: "b", Ok: true}}, records)
+}
+
+func TestGob(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	encoder := gob.NewEncoder(buf)
+	require.NoError(t, encoder.Encode(record{Name: "a", Value: 1}))
+	require.NoError(t, encoder.Encode(record{Name: "b", Ok: true}))
+
+	records := decodeAll(t, New[record](Gob, buf))
+	require.Equal(t, []record{{Name: "a", Value: 1}, {Name: "b", Ok: true}}, records)
+}
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, gob.NewEncoder(buf).Encode(record{Name: "gob"}))
+
+	testCases := []struct {
+		name     string
+		input    io.Reader
+		expected []record
+	}{
+		{
+			name:     "json",
+			input:    strings.NewReader("  {\"name\": \"a\"}\n{\"name\": \"b\"}"),
+			expected: []record{{Name: "a"}, {Name: "b"}},
+		},
+		{
+			name:     "json array",
+			input:    strings.NewReader("\n[{\"name\": \"a\"}, {\"name\": \"b\"}]"),
+			expected: []record{{Name: "a"}, {Name: "b"}},
+		},
+		{
+			name:     "csv",
+			input:    strings.NewReader("name,value\na,1\n"),
+			expected: []record{{Name: "a", Value: 1}},
+		},
+		{
+			name:     "yaml",
+			input:    strings.NewReader("name: a, b\n"),
+			expected: []record{{Name: "a, b"}},
+		},
+		{
+			name:     "gob",
+			input:    buf,
+			expected: []record{{Name: "gob"}},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, decodeAll(t, New[record](Sniff, tt.input)))
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	t.Parallel()
+
+	const contents = "{\"name\": \"a\", \"value\": 1}\n{\"name\": \"b\"}\n"
+
+	gzipped := new(bytes.Buffer)
+	writer := gzip.NewWriter(gzipped)
+	_, err := writer.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	// bzip2 -c, as the standard library cannot compress it
+	bzipped, err := hex.DecodeString("425a68393141592653592ede28e200001259800010500420103207030a2000212a7a48c6a336a14c" +
+		"00134660f5666d818be9840e88a1cdae35427d1772453850902ede28e2")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		path       string
+		input      []byte
+		expected   string
+		compressed bool
+	}{
+		{name: "gzip extension", path: "dir/a.ndjson.GZ", input: gzipped.Bytes(), expected: "dir/a.ndjson", compressed: true},
+		{name: "bzip2 extension", path: "a.json.bz2", input: bzipped, expected: "a.json", compressed: true},
+		{name: "gzip magic", path: "a.ndjson", input: gzipped.Bytes(), expected: "a.ndjson", compressed: true},
+		{name: "bzip2 magic", path: "a", input: bzipped, expected: "a", compressed: true},
+		{name: "plain", path: "a.ndjson", input: []byte(contents), expected: "a.ndjson"},
+		{name: "bzip2 lookalike", path: "a", input: []byte("BZh9 is not bzip2"), expected: "a"},
+		{name: "empty", path: "a.json", expected: "a.json"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, name, compressed, err := Decompress(bytes.NewReader(tt.input), tt.path)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, name)
+			require.Equal(t, tt.compressed, compressed)
+
+			if tt.compressed {
+				require.Equal(t, []record{{Name: "a", Value: 1}, {Name: "b"}}, decodeAll(t, New[record](NDJSON, reader)))
+				return
+			}
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.Equal(t, string(tt.input), string(data))
+		})
+	}
+
+	_, _, _, err = Decompress(strings.NewReader(`{"name": "not gzipped"}`), "a.json.gz")
+	require.ErrorIs(t, err, gzip.ErrHeader)
+}
+
+func TestFormatOf(t *testing.T) {
+	t.Parallel()
+
+	// every line is the name of a record
+	format := FormatOf(func(r io.Reader) Decoder[record] {
+		return &lineDecoder{lines: bufio.NewScanner(r)}
+	})
+
+	records := decodeAll(t, New[record](format, strings.NewReader("a\nb\n")))
+	require.Equal(t, []record{{Name: "a"}, {Name: "b"}}, records)
+
+	_, err := New[string](format, strings.NewReader("a\n")).Decode()
+	require.ErrorContains(t, err, "decode decoder.record values into *string")
+}
+
+type lineDecoder struct {
+	lines *bufio.Scanner
+}
+
+func (d *lineDecoder) Decode() (record, error) {
+	if !d.lines.Scan() {
+		return record{}, io.EOF
+	}
+
+	return record{Name: d.lines.Text()}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := Default().Merge(Registry{".JSON": NDJSON, ".txt": CSV})
+
+	format, ok := registry.Lookup("dir/file.Json")
+	require.True(t, ok)
+	require.Equal(t, 2, len(decodeAll(t, New[record](format, strings.NewReader(`{} {}`)))))
+
+	_, ok = registry.Lookup("dir/file.txt")
+	require.True(t, ok)
+
+	_, ok = registry.Lookup("dir/file")
+	require.False(t, ok)
+
+	_, ok = Default().Lookup("file.txt")
+	require.False(t, ok)
+}
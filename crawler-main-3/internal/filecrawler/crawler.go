@@ -1,19 +1,119 @@
 package crawler
 
 import (
+	"bytes"
 	"context"
+	"crawler/internal/decoder"
 	"crawler/internal/fs"
+	"crawler/internal/pipeline"
 	"crawler/internal/workerpool"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"os"
+	"path"
+	"time"
 )
 
 // Configuration holds the configuration for the crawler, specifying the number of workers for
 // file searching, processing, and accumulating tasks. The values for SearchWorkers, FileWorkers,
-// and AccumulatorWorkers are critical to efficient performance and must be defined in
-// every configuration.
+// and AccumulatorWorkers are critical to efficient performance and must be positive in
+// every configuration, unless the scaling of their stage is set.
 type Configuration struct {
 	SearchWorkers      int // Number of workers responsible for searching files.
 	FileWorkers        int // Number of workers for processing individual files.
 	AccumulatorWorkers int // Number of workers for accumulating results.
+
+	// FileScaling, if set, adjusts the number of file workers to the workload within
+	// its bounds instead of using FileWorkers, see workerpool.Scaling.
+	FileScaling *workerpool.Scaling
+
+	// SearchScaling and AccumulatorScaling adjust the numbers of search and accumulator
+	// workers like FileScaling, instead of using SearchWorkers and AccumulatorWorkers.
+	// AccumulateByKey assigns every key to one of a fixed number of workers, so it scales
+	// the workers extracting the keys, and accumulates with AccumulatorScaling.Max workers.
+	SearchScaling      *workerpool.Scaling
+	AccumulatorScaling *workerpool.Scaling
+
+	// Decoders overrides or extends the default decoders chosen by file extension
+	// (see decoder.Default), e.g. {".csv": decoder.CSV}, and a decoder.Decoder[T] of the values
+	// of the crawl is registered with decoder.FormatOf. Files compressed with gzip or bzip2
+	// are decompressed while they are decoded (see decoder.Decompress), and decoded by
+	// the extension preceding .gz or .bz2, e.g. ".json" for "data.json.gz".
+	Decoders decoder.Registry
+
+	// DefaultDecoder decodes files whose extension has no registered decoder.
+	// If nil, such files are decoded as a single JSON document (decoder.JSON);
+	// decoder.Sniff chooses the format by the file contents instead.
+	DefaultDecoder decoder.Format
+
+	// Include lists glob patterns (path.Match syntax) of the files to process, all files
+	// are processed if empty. A pattern containing "/" is matched against the slash-separated
+	// path relative to root, other patterns are matched against the file name.
+	Include []string
+
+	// Exclude lists glob patterns of the files and directories to skip, matched like Include.
+	// Excluded directories are never read.
+	Exclude []string
+
+	// MaxDepth limits the depth of the crawl: 1 processes only the files of root,
+	// 2 also processes the files of its subdirectories, and so on. Zero means no limit.
+	MaxDepth int
+
+	// SkipHidden skips files and directories whose names start with a dot.
+	SkipHidden bool
+
+	// FollowSymlinks follows symbolic links to files and directories, which requires
+	// the file system to implement fs.SymlinkFileSystem. Links are filtered by their own
+	// names and paths. Every directory is crawled once, even if several links lead to it,
+	// through the path reached first, which depends on scheduling: the filters and MaxDepth
+	// apply to that path. A link leading to one of its ancestors is reported with ErrSymlinkLoop,
+	// or skipped if SkipSymlinkLoops is set. Links to files are processed as separate files.
+	// Without FollowSymlinks, links are processed as files.
+	FollowSymlinks   bool
+	SkipSymlinkLoops bool
+
+	// MinFileSize and MaxFileSize limit the size of the processed files in bytes.
+	// Zero means no limit.
+	MinFileSize int64
+	MaxFileSize int64
+
+	// MaxOpenFiles limits the number of files and directories open at the same time.
+	// MaxFilesPerSecond limits the rate files and directories are opened at, and
+	// MaxBytesPerSecond limits the rate files are read at. Zero means no limit.
+	// The limits are shared by the search and file workers.
+	MaxOpenFiles      int
+	MaxFilesPerSecond int
+	MaxBytesPerSecond int64
+
+	// ErrorPolicy defines how failing files and directories are handled, FailFast by default.
+	ErrorPolicy ErrorPolicy
+
+	// MaxErrors is the number of errors tolerated under the ErrorThreshold policy.
+	MaxErrors int
+
+	// StreamValues passes every value to the accumulators as soon as it is decoded, instead of
+	// decoding all values of a file first, so that at most one value of every file is held
+	// in memory. Together with decoder.JSONArray, a file holding a huge JSON array is decoded
+	// element by element. Every value then counts as an accumulated file for StreamEvery.
+	// The values decoded before a file fails stay accumulated.
+	// Incremental crawls read whole files anyway and ignore it.
+	StreamValues bool
+
+	// StreamEvery and StreamInterval control how often CollectStream yields partial results:
+	// after every StreamEvery accumulated files and at most every StreamInterval.
+	// If neither is set, a partial result is yielded after every file.
+	StreamEvery    int
+	StreamInterval time.Duration
+
+	// OnProgress, if set, receives progress events every ProgressInterval
+	// (DefaultProgressInterval if not set) and the final event before Collect returns.
+	// It is called from a single goroutine and never blocks the workers,
+	// but a slow callback delays the periodic events and the return of Collect.
+	OnProgress       func(Progress)
+	ProgressInterval time.Duration
 }
 
 // Combiner is a function type that defines how to combine two values of type R into a single
@@ -30,7 +130,7 @@ type Combiner[R any] func(current R, accum R) R
 // Crawler represents a concurrent crawler implementing a map-reduce model with multiple workers
 // to manage file processing, transformation, and accumulation tasks. The crawler is designed to
 // handle large sets of files efficiently, assuming that all files can fit into memory
-// simultaneously.
+// simultaneously. Keyed results larger than memory are collected by CollectSpilled.
 type Crawler[T, R any] interface {
 	// Collect performs the full crawling operation, coordinating with the file system
 	// and worker pool to process files and accumulate results. The result type R is assumed
@@ -46,8 +146,9 @@ type Crawler[T, R any] interface {
 	//    it should return that modified value rather than creating a new one,
 	//    or alternatively, it can create and return a new combined result.
 	// 5. Context cancellation is respected across workers.
-	// 6. Type T is derived by json-deserializing the file contents, and any issues in deserialization
-	//    must be handled within the worker.
+	// 6. Type T is derived by deserializing the file contents with the decoder chosen by
+	//    the Configuration (a single JSON document by default), and any issues in deserialization
+	//    must be handled within the worker. A file may yield many values of type T.
 	// 7. The combiner function will wait for all workers to complete, ensuring no goroutine leaks
 	//    occur during the process.
 	Collect(
@@ -58,6 +159,110 @@ type Crawler[T, R any] interface {
 		accumulator workerpool.Accumulator[T, R],
 		combiner Combiner[R],
 	) (R, error)
+
+	// CollectWithReport performs the same crawling operation as Collect and also returns
+	// the report listing every path that failed, the stage it failed in and the error,
+	// including panics recovered from the fs.FileSystem and the accumulator.
+	// The report is returned even if the crawl fails.
+	CollectWithReport(
+		ctx context.Context,
+		fileSystem fs.FileSystem,
+		root string,
+		conf Configuration,
+		accumulator workerpool.Accumulator[T, R],
+		combiner Combiner[R],
+	) (R, Report, error)
+
+	// CollectStream performs the same crawling operation as Collect, yielding partial
+	// results while the crawl is running, as configured by StreamEvery and StreamInterval,
+	// followed by the final result. Each partial result includes all the previous ones.
+	// The partial results are the intermediate outputs of the accumulator workers combined
+	// as they arrive, so nothing is combined twice.
+	// If the crawl fails, the last pair holds the zero R and the error.
+	// Stopping the iteration cancels the crawl and waits for all workers.
+	// If the combiner modifies its arguments, a yielded result is only valid until the next
+	// iteration.
+	CollectStream(
+		ctx context.Context,
+		fileSystem fs.FileSystem,
+		root string,
+		conf Configuration,
+		accumulator workerpool.Accumulator[T, R],
+		combiner Combiner[R],
+	) iter.Seq2[R, error]
+
+	// CollectIncremental performs the same crawling operation as Collect, reusing the results
+	// of the files unchanged since the previous run. The manifest of the run records the size,
+	// modification time, content hash and encoded accumulated result of every processed file.
+	// A file whose size and modification time match the manifest is not read at all, and a file
+	// whose contents hash matches is not decoded; its stored result is combined with the new ones.
+	// Every file is accumulated separately, starting from the zero R. The stored results are
+	// discarded if Incremental.Version or the extensions of the decoders change.
+	// The manifest is saved even if the crawl fails or is cancelled, keeping the entries of the
+	// files not reached yet, so that the next run resumes the crawl.
+	CollectIncremental(
+		ctx context.Context,
+		fileSystem fs.FileSystem,
+		root string,
+		conf Configuration,
+		incremental Incremental[R],
+		accumulator workerpool.Accumulator[T, R],
+		combiner Combiner[R],
+	) (R, error)
+
+	// Watch performs the same crawling operation as Collect, yields the result, and then keeps
+	// it up to date as the files are added, modified or removed, yielding it again after every
+	// batch of changes. The result of every file is kept, so only the changed files are read.
+	// The changes are notified by the operating system where possible (inotify on Linux for
+	// the OS file system), and polled through the file system otherwise. The notified paths
+	// are crawled again, and the whole tree only if notifications were lost, with
+	// FollowSymlinks, or after a failure; the polling lists the whole tree. If a crawl fails,
+	// its error is yielded and the watch goes on. Stopping the iteration or cancelling the context
+	// ends the watch. Like in CollectStream, a yielded result may be modified by the combiner
+	// after the next iteration.
+	Watch(
+		ctx context.Context,
+		fileSystem fs.FileSystem,
+		root string,
+		conf Configuration,
+		watch WatchConfiguration[R],
+		accumulator workerpool.Accumulator[T, R],
+		combiner Combiner[R],
+	) iter.Seq2[R, error]
+
+	// Coordinate performs the same crawling operation as Collect, distributed among the worker
+	// processes connected to the listener (see Work), e.g. over a unix socket or loopback TCP.
+	// The tree is split into tasks (see Distribution), which are handed out to the workers
+	// asking for them. The result of every task is sent back encoded by the codec, decoded
+	// and combined by the coordinator. The tasks of a worker whose connection is lost, or
+	// whose heartbeats stop (see Distribution.LeaseTimeout), are handed out again. The crawl
+	// fails with the first failing task. The workers apply the filtering options of conf,
+	// the rest of the configuration is their own. Coordinate closes the listener and waits
+	// for the workers to disconnect, at most for the lease timeout, before returning.
+	Coordinate(
+		ctx context.Context,
+		listener net.Listener,
+		fileSystem fs.FileSystem,
+		root string,
+		conf Configuration,
+		dist Distribution[R],
+		combiner Combiner[R],
+	) (R, error)
+
+	// Work connects to the coordinator at the address and crawls the tasks it hands out,
+	// sending their results encoded by the codec and the heartbeats of the task being crawled,
+	// until the coordinator has no more tasks.
+	// It returns an error if the connection fails or a task fails.
+	Work(
+		ctx context.Context,
+		network string,
+		address string,
+		fileSystem fs.FileSystem,
+		conf Configuration,
+		codec Codec[R],
+		accumulator workerpool.Accumulator[T, R],
+		combiner Combiner[R],
+	) error
 }
 
 type crawlerImpl[T, R any] struct{}
@@ -74,5 +279,721 @@ func (c *crawlerImpl[T, R]) Collect(
 	accumulator workerpool.Accumulator[T, R],
 	combiner Combiner[R],
 ) (R, error) {
-	panic("not implemented")
+	result, _, err := c.CollectWithReport(ctx, fileSystem, root, conf, accumulator, combiner)
+	return result, err
+}
+
+func (c *crawlerImpl[T, R]) CollectWithReport(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) (R, Report, error) {
+	var result R
+
+	run, err := c.start(ctx, fileSystem, root, conf, accumulator, 0, nil)
+	if err != nil {
+		return result, Report{}, err
+	}
+
+	result, metrics, err := pipeline.Reduce(run.ctx, run.pipeline, combiner)
+
+	report, err := run.finish(metrics, err)
+	if err != nil {
+		var zero R
+		return zero, report, err
+	}
+
+	return result, report, nil
+}
+
+func (c *crawlerImpl[T, R]) CollectStream(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) iter.Seq2[R, error] {
+	return func(yield func(R, error) bool) {
+		var result R
+
+		// without a file count every accumulated file is sent to be picked up by the interval
+		every := conf.StreamEvery
+		if every <= 0 {
+			every = 1
+		}
+
+		run, err := c.start(ctx, fileSystem, root, conf, accumulator, every, nil)
+		if err != nil {
+			yield(result, err)
+			return
+		}
+
+		accumulated, stop := pipeline.Run(run.ctx, run.pipeline)
+
+		var ticks <-chan time.Time
+
+		if conf.StreamInterval > 0 {
+			ticker := time.NewTicker(conf.StreamInterval)
+			defer ticker.Stop()
+
+			ticks = ticker.C
+		}
+
+		// changed is set when the result has been combined with partials not yielded yet
+		changed := false
+
+		for accumulated != nil {
+			select {
+			case partial, ok := <-accumulated:
+				if !ok {
+					accumulated = nil
+					continue
+				}
+
+				result = combiner(partial, result)
+				changed = true
+
+				if ticks != nil && conf.StreamEvery <= 0 {
+					continue
+				}
+			case <-ticks:
+				if !changed {
+					continue
+				}
+			}
+
+			changed = false
+
+			if !yield(result, nil) {
+				_, _ = run.finish(stop())
+				return
+			}
+		}
+
+		if _, err = run.finish(stop()); err != nil {
+			var zero R
+			yield(zero, err)
+
+			return
+		}
+
+		yield(result, nil)
+	}
+}
+
+// crawl is a prepared crawl: the search, decode and accumulate stages of its pipeline
+// run in the context of the crawl, which is cancelled by the error policy.
+type crawl[T, R any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pipeline *pipeline.Pipeline[R]
+
+	errs     *errorCollector
+	stats    *crawlStats
+	progress *progressTracker
+}
+
+// start validates the configuration and prepares the crawl pipeline, which must be run
+// in the context of the crawl, and then finish must be called.
+// See workerpool.Pool.AccumulateEvery for every.
+// If the manifest is set, every must be 1, so that each file is accumulated separately.
+func (c *crawlerImpl[T, R]) start(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	accumulator workerpool.Accumulator[T, R],
+	every int,
+	m *manifest[R],
+) (*crawl[T, R], error) {
+	return c.startAt(ctx, fileSystem, directory{path: root, rel: ".", canonical: root}, conf, accumulator, every, m)
+}
+
+// startAt prepares the crawl like start, searching from the given directory of the tree,
+// whose relative path and depth are kept for filtering.
+func (c *crawlerImpl[T, R]) startAt(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	seed directory,
+	conf Configuration,
+	accumulator workerpool.Accumulator[T, R],
+	every int,
+	m *manifest[R],
+) (*crawl[T, R], error) {
+	run, decoded, err := c.prepare(ctx, fileSystem, seed, conf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.AccumulatorScaling != nil {
+		run.pipeline = pipeline.AccumulateScaled(
+			decoded,
+			"accumulate",
+			*conf.AccumulatorScaling,
+			every,
+			c.accumulate(accumulator, m, run.errs),
+		)
+
+		return run, nil
+	}
+
+	run.pipeline = pipeline.Accumulate(
+		decoded,
+		"accumulate",
+		conf.AccumulatorWorkers,
+		every,
+		c.accumulate(accumulator, m, run.errs),
+	)
+
+	return run, nil
+}
+
+// prepare validates the configuration and prepares the crawl like startAt, returning the
+// search and decode stages, which must be completed into the pipeline of the crawl.
+func (c *crawlerImpl[T, R]) prepare(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	seed directory,
+	conf Configuration,
+	m *manifest[R],
+) (*crawl[T, R], *pipeline.Pipeline[decodedFile[T, R]], error) {
+	filter, err := newFilter(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a pool without workers would never run a stage, so nothing would be found or decoded
+	if (conf.SearchWorkers <= 0 && conf.SearchScaling == nil) ||
+		(conf.FileWorkers <= 0 && conf.FileScaling == nil) ||
+		(conf.AccumulatorWorkers <= 0 && conf.AccumulatorScaling == nil) {
+		return nil, nil, fmt.Errorf("invalid worker counts: %d search, %d file, %d accumulator",
+			conf.SearchWorkers, conf.FileWorkers, conf.AccumulatorWorkers)
+	}
+
+	if conf.ErrorPolicy < FailFast || conf.ErrorPolicy > ErrorThreshold {
+		return nil, nil, fmt.Errorf("unknown error policy %d", conf.ErrorPolicy)
+	}
+
+	if conf.MaxErrors < 0 {
+		return nil, nil, fmt.Errorf("negative max errors %d", conf.MaxErrors)
+	}
+
+	for _, stage := range []struct {
+		name    string
+		scaling *workerpool.Scaling
+	}{
+		{"search", conf.SearchScaling},
+		{"file", conf.FileScaling},
+		{"accumulator", conf.AccumulatorScaling},
+	} {
+		if scaling := stage.scaling; scaling != nil && (scaling.Min <= 0 || scaling.Max < scaling.Min) {
+			return nil, nil, fmt.Errorf("invalid %s worker bounds %d..%d", stage.name, scaling.Min, scaling.Max)
+		}
+	}
+
+	links, err := newSymlinks(conf, fileSystem)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits, err := newLimits(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crawlCtx, cancel := context.WithCancel(ctx)
+	stats := new(crawlStats)
+	run := &crawl[T, R]{
+		ctx:      crawlCtx,
+		cancel:   cancel,
+		stats:    stats,
+		progress: newProgressTracker(conf, stats),
+	}
+
+	run.progress.run()
+	run.errs = newErrorCollector(conf, cancel, run.progress)
+
+	// the stages wait for the limits and the retries with the context of the pipeline,
+	// which is cancelled once the output is abandoned
+	bind := func(ctx context.Context) fs.FileSystem {
+		if bound, ok := fileSystem.(fs.ContextFileSystem); ok {
+			return bound.WithContext(ctx, fs.Hooks{
+				OnRetry: func(fs.Op, string, error) {
+					run.stats.retried()
+				},
+			})
+		}
+
+		return fileSystem
+	}
+
+	search := pipeline.From("search", func(ctx context.Context, emit func(discoveredFile) bool) error {
+		searcher := c.search(ctx, bind(ctx), filter, links, limits, m != nil, emit, run.errs, run.progress)
+
+		if conf.SearchScaling != nil {
+			workerpool.New[directory, directory]().ListScaled(ctx, *conf.SearchScaling, seed, searcher)
+		} else {
+			workerpool.New[directory, directory]().List(ctx, conf.SearchWorkers, seed, searcher)
+		}
+
+		return nil
+	})
+
+	decode := func(ctx context.Context) pipeline.Emitter[discoveredFile, decodedFile[T, R]] {
+		return c.decode(ctx, bind(ctx), conf, m, limits, run.errs, run.stats, run.progress)
+	}
+
+	if conf.FileScaling != nil {
+		return run, pipeline.ThenEmitScaled(search, "decode", *conf.FileScaling, decode), nil
+	}
+
+	return run, pipeline.ThenEmit(search, "decode", conf.FileWorkers, decode), nil
+}
+
+// finish ends the crawl once its pipeline has stopped with the metrics and the error,
+// delivers the final progress event and returns the report and the error of the crawl.
+func (r *crawl[T, R]) finish(metrics []pipeline.StageMetrics, err error) (Report, error) {
+	defer r.cancel()
+
+	r.progress.finish()
+
+	counts := r.stats.snapshot()
+
+	report := r.errs.report()
+	report.Retries = counts.retries
+	report.BytesCompressed = counts.compressed
+	report.BytesDecompressed = counts.decompressed
+	report.Stages = metrics
+
+	if collected := r.errs.err(); collected != nil {
+		return report, collected
+	}
+
+	return report, err
+}
+
+// directory is a directory found by the search stage.
+type directory struct {
+	path  string // path in the file system
+	rel   string // slash-separated path relative to the root
+	depth int    // depth relative to the root, the root itself has depth 0
+
+	// set only when following symbolic links
+	canonical string     // path with all the links resolved
+	parent    *directory // the directory listing this one
+	id        identity   // set once the directory is entered
+
+	// set only for the updates of a watch
+	shallow bool        // the subdirectories are not searched
+	seeds   []directory // searched instead of this directory, which is not listed
+}
+
+// discoveredFile is a file found by the search stage.
+type discoveredFile struct {
+	path string
+	info os.FileInfo // set only for incremental crawls
+}
+
+// decodedFile holds all values decoded from the file.
+type decodedFile[T, R any] struct {
+	path   string
+	values []T
+	failed bool
+
+	// the manifest entry of the file for incremental crawls,
+	// and its result if it is cached instead of decoded
+	entry  manifestEntry
+	cached bool
+	result R
+}
+
+// search returns the searcher listing the directory: accepted files are passed to emit,
+// and accepted subdirectories are returned for further search.
+// If withInfo is set, the file info of the accepted files is sent along.
+func (c *crawlerImpl[T, R]) search(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	filter *filter,
+	links *symlinks,
+	limits *limits,
+	withInfo bool,
+	emit func(discoveredFile) bool,
+	errs *errorCollector,
+	progress *progressTracker,
+) workerpool.Searcher[directory] {
+	return func(dir directory) (subdirs []directory) {
+		if dir.seeds != nil {
+			return dir.seeds
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				errs.addPanic(dir.path, StageList, r)
+				subdirs = nil
+			}
+		}()
+
+		ok, err := links.enter(&dir)
+		if err != nil {
+			errs.add(dir.path, StageList, err)
+			return nil
+		}
+
+		if !ok {
+			return nil
+		}
+
+		release, err := limits.open(ctx)
+		if err != nil {
+			return nil
+		}
+
+		entries, err := readDir(fileSystem, dir.path, release)
+		if err != nil {
+			errs.add(dir.path, StageList, err)
+			return nil
+		}
+
+		progress.directoryListed()
+
+		for _, entry := range entries {
+			name := entry.Name()
+			child := directory{
+				path:  fileSystem.Join(dir.path, name),
+				rel:   path.Join(dir.rel, name),
+				depth: dir.depth + 1,
+			}
+
+			if links != nil {
+				child.parent = &dir
+				child.canonical = fileSystem.Join(dir.canonical, name)
+			}
+
+			if links.isLink(entry) {
+				if entry, child.canonical, err = links.resolve(child.path, child.canonical); err != nil {
+					errs.add(child.path, StageList, err)
+					continue
+				}
+			}
+
+			if entry.IsDir() {
+				if !dir.shallow && filter.descend(child.rel, name, child.depth) {
+					subdirs = append(subdirs, child)
+				}
+
+				continue
+			}
+
+			ok, err := filter.accept(child.rel, name, child.depth, entry)
+			if err != nil {
+				errs.add(child.path, StageList, err)
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+
+			file := discoveredFile{path: child.path}
+
+			if withInfo {
+				if file.info, err = entry.Info(); err != nil {
+					errs.add(child.path, StageList, err)
+					continue
+				}
+			}
+
+			if !emit(file) {
+				return nil
+			}
+
+			progress.fileDiscovered()
+		}
+
+		return subdirs
+	}
+}
+
+// readDir reads the directory and releases its open file limit, even if ReadDir panics.
+func readDir(fileSystem fs.FileSystem, path string, release func()) ([]os.DirEntry, error) {
+	defer release()
+
+	return fileSystem.ReadDir(path)
+}
+
+// decode returns the emitter decoding all values of the file, emitted as one decoded file.
+// A failing file yields no values. For incremental crawls, the file is read entirely
+// to compute its hash, and the result stored in the manifest is used instead of
+// decoding the file if the file is unchanged. Compressed files are decompressed as they are
+// decoded, and hashed compressed. With StreamValues, every value is emitted as a decoded
+// file of its own as soon as it is decoded, unless the crawl is incremental.
+func (c *crawlerImpl[T, R]) decode(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	conf Configuration,
+	m *manifest[R],
+	limits *limits,
+	errs *errorCollector,
+	stats *crawlStats,
+	progress *progressTracker,
+) pipeline.Emitter[discoveredFile, decodedFile[T, R]] {
+	formats := decoder.Default().Merge(conf.Decoders)
+
+	fallback := conf.DefaultDecoder
+	if fallback == nil {
+		fallback = decoder.JSON
+	}
+
+	// process decodes the file, passing its values to each until it returns false
+	var process func(discovered discoveredFile, each func(T) bool) decodedFile[T, R]
+
+	emitter := func(discovered discoveredFile, emit func(decodedFile[T, R]) bool) {
+		if conf.StreamValues && m == nil {
+			process(discovered, func(value T) bool {
+				return emit(decodedFile[T, R]{path: discovered.path, values: []T{value}})
+			})
+
+			return
+		}
+
+		var values []T
+
+		file := process(discovered, func(value T) bool {
+			values = append(values, value)
+			return true
+		})
+
+		if !file.failed && !file.cached {
+			file.values = values
+		}
+
+		emit(file)
+	}
+
+	process = func(discovered discoveredFile, each func(T) bool) (file decodedFile[T, R]) {
+		path := discovered.path
+		file.path = path
+		stage := StageOpen
+
+		defer func() {
+			if r := recover(); r != nil {
+				errs.addPanic(path, stage, r)
+				file.values, file.failed = nil, true
+			}
+		}()
+
+		if m != nil {
+			if entry, ok := m.unchanged(path, discovered.info); ok && file.restore(m, entry) {
+				progress.fileCached()
+				return file
+			}
+		}
+
+		release, err := limits.open(ctx)
+		if err != nil {
+			file.failed = true
+			return file
+		}
+
+		defer release()
+
+		f, err := fileSystem.Open(path)
+		if err != nil {
+			errs.add(path, StageOpen, err)
+			file.failed = true
+
+			return file
+		}
+
+		defer func() {
+			_ = f.Close()
+		}()
+
+		stage = StageDecode
+		reader := &readTracker{reader: limits.reader(ctx, f), progress: progress, stats: stats}
+
+		var input io.Reader = reader
+
+		if m != nil {
+			stage = StageRead
+
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				// reading is interrupted by the cancellation when the read rate is limited
+				if ctx.Err() == nil {
+					errs.add(path, StageRead, err)
+				}
+
+				file.failed = true
+
+				return file
+			}
+
+			file.entry = manifestEntry{
+				Size:    discovered.info.Size(),
+				ModTime: discovered.info.ModTime(),
+				Hash:    contentHash(data),
+			}
+
+			if entry, ok := m.cached(path, file.entry.Hash); ok {
+				entry.Size, entry.ModTime = file.entry.Size, file.entry.ModTime
+
+				if file.restore(m, entry) {
+					progress.fileCached()
+					return file
+				}
+			}
+
+			stage = StageDecode
+			input = bytes.NewReader(data)
+		}
+
+		input, name, compressed, err := decoder.Decompress(input, path)
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+			case reader.err != nil:
+				errs.add(path, StageRead, err)
+			default:
+				errs.add(path, StageDecode, err)
+			}
+
+			file.failed = true
+
+			return file
+		}
+
+		if compressed {
+			reader.markCompressed()
+			input = &decompressedReader{reader: input, stats: stats}
+		}
+
+		format, ok := formats.Lookup(name)
+		if !ok {
+			format = fallback
+		}
+
+		decoded := decodeValues(ctx, decoder.New[T](format, input), reader, path, errs, each)
+
+		if !decoded {
+			file.failed = true
+			return file
+		}
+
+		progress.fileDecoded()
+
+		return file
+	}
+
+	return emitter
+}
+
+// decodeValues passes all values of the file to each until it returns false, which means
+// the crawl is cancelled, and reports whether the file was decoded.
+// A *decoder.ValueError is reported and the value is skipped, unless the error policy cancels
+// the crawl. The other errors fail the file.
+func decodeValues[T any](
+	ctx context.Context,
+	d decoder.Decoder[T],
+	reader *readTracker,
+	path string,
+	errs *errorCollector,
+	each func(T) bool,
+) bool {
+	for {
+		value, err := d.Decode()
+		if err == nil {
+			if !each(value) {
+				return false
+			}
+
+			continue
+		}
+
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+
+		var valueErr *decoder.ValueError
+
+		switch {
+		case ctx.Err() != nil:
+		case reader.err != nil:
+			errs.add(path, StageRead, err)
+		case errors.As(err, &valueErr):
+			errs.add(path, StageDecode, err)
+
+			if ctx.Err() == nil {
+				continue
+			}
+		default:
+			errs.add(path, StageDecode, err)
+		}
+
+		return false
+	}
+}
+
+// restore sets the cached result of the file from the manifest entry.
+// An entry that cannot be decoded is ignored, so the file is processed again.
+func (f *decodedFile[T, R]) restore(m *manifest[R], entry manifestEntry) bool {
+	result, err := m.codec.Decode(entry.Result)
+	if err != nil {
+		return false
+	}
+
+	f.entry, f.cached, f.result = entry, true, result
+
+	return true
+}
+
+// accumulate returns the accumulator of all values of the decoded file. A panic of
+// the accumulator is reported, and the rest of the values of the decoded file are skipped.
+// For incremental crawls, the result of the file is stored in the manifest.
+func (c *crawlerImpl[T, R]) accumulate(
+	accumulator workerpool.Accumulator[T, R],
+	m *manifest[R],
+	errs *errorCollector,
+) workerpool.Accumulator[decodedFile[T, R], R] {
+	return func(current decodedFile[T, R], accum R) (result R) {
+		result = accum
+
+		defer func() {
+			if r := recover(); r != nil {
+				errs.addPanic(current.path, StageAccumulate, r)
+			}
+		}()
+
+		// incremental crawls accumulate every file separately, so accum is the zero R
+		if current.cached {
+			m.store(current.path, current.entry)
+			return current.result
+		}
+
+		for _, value := range current.values {
+			result = accumulator(value, result)
+		}
+
+		if m == nil || current.failed {
+			return result
+		}
+
+		// the result is encoded before it is passed to the combiner, which may modify it
+		data, err := m.codec.Encode(result)
+		if err != nil {
+			errs.add(current.path, StageManifest, err)
+			return result
+		}
+
+		entry := current.entry
+		entry.Result = data
+		m.store(current.path, entry)
+
+		return result
+	}
 }
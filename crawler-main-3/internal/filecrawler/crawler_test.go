@@ -1,18 +1,32 @@
 package crawler
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crawler/internal/decoder"
 	"crawler/internal/fs"
+	"crawler/internal/workerpool"
 	"crawler/pkg/mocks"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"iter"
 	"math/rand/v2"
+	"net"
+	"net/rpc"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 	"unsafe"
@@ -97,13 +111,2058 @@ func TestWithOsFileSystem(t *testing.T) {
 
 	c := New[TestType, TestAccumulator]()
 	result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, Configuration{
-		10,
-		10,
-		10,
+		SearchWorkers:      10,
+		FileWorkers:        10,
+		AccumulatorWorkers: 10,
+	}, accum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 100, result.Sum)
+}
+
+func TestDecoders(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	files := map[string]string{
+		"single.json":        `{"data": 1}`,
+		"lines.ndjson":       "{\"data\": 2}\n{\"data\": 3}\n",
+		"rows.csv":           "data,name\n4,a\n5,b\n",
+		"inner/docs.yaml":    "data: 6\n---\ndata: 7\n",
+		"inner/unknown":      "{\"data\": 8}\n{\"data\": 9}",
+		"inner/override.txt": "data\n10\n",
+	}
+
+	writeTree(t, rootDir, files)
+
+	c := New[TestType, TestAccumulator]()
+	result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+		Decoders:           decoder.Registry{".txt": decoder.CSV},
+		DefaultDecoder:     decoder.Sniff,
+	}, sum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 55, result.Sum)
+
+	_, err = c.Collect(ctx, fs.NewOsFileSystem(), rootDir, Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+	}, sum, combiner)
+
+	require.Error(t, err)
+}
+
+func TestInvalidWorkers(t *testing.T) {
+	ctx := context.Background()
+	memory := fs.NewMemoryFileSystem()
+	require.NoError(t, memory.WriteFile("a.json", []byte(`{"data": 1}`)))
+
+	c := New[TestType, TestAccumulator]()
+
+	for _, conf := range []Configuration{
+		{FileWorkers: 1, AccumulatorWorkers: 1},
+		{SearchWorkers: 1, AccumulatorWorkers: 1},
+		{SearchWorkers: 1, FileWorkers: 1, AccumulatorWorkers: -1},
+	} {
+		_, err := c.Collect(ctx, memory, ".", conf, accum, combiner)
+		require.ErrorContains(t, err, "invalid worker counts")
+	}
+
+	// the file workers are scaled instead
+	result, err := c.Collect(ctx, memory, ".", Configuration{
+		SearchWorkers:      1,
+		AccumulatorWorkers: 1,
+		FileScaling:        &workerpool.Scaling{Min: 1, Max: 2},
 	}, accum, combiner)
 
 	require.NoError(t, err)
-	require.EqualValues(t, 100, result.Sum)
+	require.EqualValues(t, 1, result.Sum)
+}
+
+func TestFilters(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	files := map[string]string{
+		"a.json":                `{"data": 1}`,
+		"b.json":                `{"data": 2, "padding": "________________"}`,
+		"skip.txt":              `{"data": 4}`,
+		".hidden.json":          `{"data": 8}`,
+		".hidden/c.json":        `{"data": 16}`,
+		"inner/d.json":          `{"data": 32}`,
+		"inner/deep/e.json":     `{"data": 64}`,
+		"excluded/f.json":       `{"data": 128}`,
+		"inner/excluded/g.json": `{"data": 256}`,
+	}
+
+	writeTree(t, rootDir, files)
+
+	testCases := []struct {
+		name     string
+		conf     Configuration
+		expected int64
+	}{
+		{
+			name:     "all",
+			conf:     Configuration{},
+			expected: 511,
+		},
+		{
+			name:     "include",
+			conf:     Configuration{Include: []string{"*.json"}},
+			expected: 507,
+		},
+		{
+			name:     "exclude",
+			conf:     Configuration{Include: []string{"*.json"}, Exclude: []string{"excluded", "inner/deep"}},
+			expected: 59,
+		},
+		{
+			name:     "depth",
+			conf:     Configuration{MaxDepth: 2},
+			expected: 191,
+		},
+		{
+			name:     "hidden",
+			conf:     Configuration{SkipHidden: true, MaxDepth: 1},
+			expected: 7,
+		},
+		{
+			name:     "size",
+			conf:     Configuration{MinFileSize: 11, MaxFileSize: 11, MaxDepth: 1},
+			expected: 13,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.SearchWorkers = 2
+			tt.conf.FileWorkers = 2
+			tt.conf.AccumulatorWorkers = 2
+
+			c := New[TestType, TestAccumulator]()
+			result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, tt.conf, sum, combiner)
+
+			require.NoError(t, err)
+			require.EqualValues(t, tt.expected, result.Sum)
+		})
+	}
+
+	c := New[TestType, TestAccumulator]()
+	_, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, Configuration{
+		SearchWorkers:      1,
+		FileWorkers:        1,
+		AccumulatorWorkers: 1,
+		Exclude:            []string{"["},
+	}, sum, combiner)
+
+	require.Error(t, err)
+}
+
+func TestFiltersSkipSubtrees(t *testing.T) {
+	ctx := context.Background()
+	controller := gomock.NewController(t)
+
+	dirEntry := func(name string, isDir bool) os.DirEntry {
+		entry := mocks.NewMockDirEntry(controller)
+		entry.EXPECT().Name().Return(name).AnyTimes()
+		entry.EXPECT().IsDir().Return(isDir).AnyTimes()
+
+		return entry
+	}
+
+	fileSystem := mocks.NewMockFileSystem(controller)
+
+	fileSystem.EXPECT().
+		Join(gomock.Any()).
+		DoAndReturn(func(elem ...string) string {
+			return filepath.Join(elem...)
+		}).
+		AnyTimes()
+
+	fileSystem.EXPECT().
+		ReadDir("root").
+		Return([]os.DirEntry{dirEntry("skip", true), dirEntry("keep", true), dirEntry("file", false)}, nil)
+
+	fileSystem.EXPECT().
+		ReadDir(filepath.Join("root", "keep")).
+		Return([]os.DirEntry{dirEntry("deep", true), dirEntry("file", false)}, nil)
+
+	fileSystem.EXPECT().
+		Open(gomock.Any()).
+		DoAndReturn(func(name string) (fs.File, error) {
+			return io.NopCloser(strings.NewReader(`{"data": 1}`)), nil
+		}).
+		Times(2)
+
+	c := New[TestType, TestAccumulator]()
+	result, err := c.Collect(ctx, fileSystem, "root", Configuration{
+		SearchWorkers:      1,
+		FileWorkers:        1,
+		AccumulatorWorkers: 1,
+		Exclude:            []string{"skip"},
+		MaxDepth:           2,
+	}, sum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 2, result.Sum)
+}
+
+func TestErrorPolicy(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	files := map[string]string{
+		"a.json":           `{"data": 1}`,
+		"b.json":           `{"data": 2}`,
+		"broken.json":      `{"data": `,
+		"inner/c.json":     `{"data": 4}`,
+		"inner/bad.json":   `{"data": "string"}`,
+		"inner/panic.json": `{"data": 100}`,
+	}
+
+	writeTree(t, rootDir, files)
+
+	panicking := func(current TestType, accum TestAccumulator) TestAccumulator {
+		if current.Data == 100 {
+			panic("accumulator panic")
+		}
+
+		accum.Sum += current.Data
+		return accum
+	}
+
+	conf := func(policy ErrorPolicy, maxErrors int) Configuration {
+		return Configuration{
+			SearchWorkers:      2,
+			FileWorkers:        2,
+			AccumulatorWorkers: 2,
+			ErrorPolicy:        policy,
+			MaxErrors:          maxErrors,
+		}
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	t.Run("skip", func(t *testing.T) {
+		result, report, err := c.CollectWithReport(ctx, fs.NewOsFileSystem(), rootDir, conf(SkipAndReport, 0), panicking, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 7, result.Sum)
+
+		stages := make(map[string]Stage)
+		for _, e := range report.Errors {
+			rel, err := filepath.Rel(rootDir, e.Path)
+			require.NoError(t, err)
+
+			stages[rel] = e.Stage
+			require.Equal(t, e.Stage == StageAccumulate, e.Panic)
+		}
+
+		require.Equal(t, map[string]Stage{
+			"broken.json":                        StageDecode,
+			filepath.Join("inner", "bad.json"):   StageDecode,
+			filepath.Join("inner", "panic.json"): StageAccumulate,
+		}, stages)
+
+		require.Len(t, report.Stages, 3)
+		require.Equal(t, "search", report.Stages[0].Name)
+		require.Equal(t, "decode", report.Stages[1].Name)
+		require.Equal(t, "accumulate", report.Stages[2].Name)
+		require.Equal(t, report.Stages[0].Items, report.Stages[1].Items)
+		require.Positive(t, report.Stages[1].Items)
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, conf(ErrorThreshold, 3), panicking, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 7, result.Sum)
+
+		_, report, err := c.CollectWithReport(ctx, fs.NewOsFileSystem(), rootDir, conf(ErrorThreshold, 1), panicking, combiner)
+		require.ErrorIs(t, err, ErrTooManyErrors)
+		require.GreaterOrEqual(t, len(report.Errors), 2)
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		_, report, err := c.CollectWithReport(ctx, fs.NewOsFileSystem(), rootDir, conf(FailFast, 0), panicking, combiner)
+
+		var fileErr *FileError
+		require.ErrorAs(t, err, &fileErr)
+		require.NotEmpty(t, report.Errors)
+		require.Equal(t, report.Errors[0], *fileErr)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, conf(ErrorThreshold, -1), panicking, combiner)
+		require.Error(t, err)
+	})
+}
+
+func TestErrorReportStages(t *testing.T) {
+	testCases := []struct {
+		op    fs.Op
+		name  string
+		fault fs.Fault
+		stage Stage
+		panic bool
+	}{
+		{op: fs.OpOpen, name: "dir/a.json", fault: fs.Fault{Panic: ErrFileOpenPanic}, stage: StageOpen, panic: true},
+		{op: fs.OpOpen, name: "dir/a.json", fault: fs.Fault{Err: ErrFileOpen}, stage: StageOpen},
+		{op: fs.OpRead, name: "dir/a.json", fault: fs.Fault{Err: ErrFileRead}, stage: StageRead},
+		{op: fs.OpReadDir, name: "dir", fault: fs.Fault{Panic: ErrReadDirPanic}, stage: StageList, panic: true},
+		{op: fs.OpReadDir, name: "dir", fault: fs.Fault{Err: ErrReadDir}, stage: StageList},
+	}
+
+	for _, tt := range testCases {
+		memory := fs.NewMemoryFileSystem()
+		require.NoError(t, memory.WriteFile("dir/a.json", []byte(`{"data": 1}`)))
+		memory.InjectFault(tt.op, tt.name, tt.fault)
+
+		result, report, err := New[TestType, TestAccumulator]().CollectWithReport(
+			context.Background(),
+			memory,
+			".",
+			Configuration{
+				SearchWorkers:      2,
+				FileWorkers:        2,
+				AccumulatorWorkers: 2,
+				ErrorPolicy:        SkipAndReport,
+			},
+			sum,
+			add,
+		)
+
+		require.NoError(t, err)
+		require.Zero(t, result.Sum)
+		require.Len(t, report.Errors, 1)
+		require.Equal(t, tt.stage, report.Errors[0].Stage)
+		require.Equal(t, tt.panic, report.Errors[0].Panic)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	var size int64
+
+	files := map[string]string{"broken.json": "{"}
+
+	for i := range 20 {
+		content := fmt.Sprintf(`{"data": %d}`, i)
+		size += int64(len(content))
+		files[fmt.Sprintf("%d/%d.json", i%4, i)] = content
+	}
+
+	writeTree(t, rootDir, files)
+
+	events := make([]Progress, 0)
+	slowSum := func(current TestType, accum TestAccumulator) TestAccumulator {
+		time.Sleep(10 * time.Millisecond)
+
+		accum.Sum += current.Data
+		return accum
+	}
+
+	c := New[TestType, TestAccumulator]()
+	result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 1,
+		ErrorPolicy:        SkipAndReport,
+		ProgressInterval:   time.Millisecond,
+		OnProgress: func(p Progress) {
+			events = append(events, p)
+		},
+	}, slowSum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 190, result.Sum)
+	require.Greater(t, len(events), 1)
+
+	for i, e := range events[1:] {
+		prev := events[i]
+		require.False(t, prev.Done)
+		require.GreaterOrEqual(t, e.FilesDecoded, prev.FilesDecoded)
+		require.GreaterOrEqual(t, e.BytesRead, prev.BytesRead)
+		require.GreaterOrEqual(t, e.Elapsed, prev.Elapsed)
+	}
+
+	final := events[len(events)-1]
+	require.True(t, final.Done)
+	require.EqualValues(t, 5, final.DirectoriesListed)
+	require.EqualValues(t, 21, final.FilesDiscovered)
+	require.EqualValues(t, 20, final.FilesDecoded)
+	require.EqualValues(t, size+1, final.BytesRead)
+	require.EqualValues(t, 1, final.Errors)
+}
+
+func TestCollectStream(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	writeTree(t, rootDir, numberedTree(20, 2))
+
+	conf := Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 1,
+		StreamEvery:        5,
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	t.Run("every", func(t *testing.T) {
+		results := make([]int64, 0)
+
+		for result, err := range c.CollectStream(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner) {
+			require.NoError(t, err)
+			results = append(results, result.Sum)
+		}
+
+		require.Len(t, results, 6)
+		require.True(t, slices.IsSorted(results))
+		require.EqualValues(t, 190, results[len(results)-1])
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		conf := conf
+		conf.StreamEvery = 0
+		conf.StreamInterval = time.Millisecond
+
+		var last int64
+
+		for result, err := range c.CollectStream(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner) {
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, result.Sum, last)
+
+			last = result.Sum
+		}
+
+		require.EqualValues(t, 190, last)
+	})
+
+	t.Run("break", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+
+		for range c.CollectStream(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner) {
+			break
+		}
+
+		require.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		conf := conf
+		conf.Include = []string{"*.json", "*.txt"}
+
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "broken.txt"), []byte("{"), 0o600))
+
+		var lastErr error
+
+		for _, err := range c.CollectStream(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner) {
+			lastErr = err
+		}
+
+		require.Error(t, lastErr)
+	})
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	writeTree(t, rootDir, map[string]string{
+		"a/0.json":        `{"data": 1}`,
+		"b/1.json":        `{"data": 2}`,
+		"a/nested/2.json": `{"data": 4}`,
+	})
+
+	// a loop to the root, a second path to "b" and a link to a file
+	require.NoError(t, os.Symlink(rootDir, filepath.Join(rootDir, "a", "nested", "loop")))
+	require.NoError(t, os.Symlink(filepath.Join("..", "b"), filepath.Join(rootDir, "a", "b")))
+	require.NoError(t, os.Symlink(filepath.Join("a", "0.json"), filepath.Join(rootDir, "link.json")))
+
+	conf := Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+		FollowSymlinks:     true,
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	t.Run("report loops", func(t *testing.T) {
+		conf := conf
+		conf.ErrorPolicy = SkipAndReport
+
+		result, report, err := c.CollectWithReport(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 1+2+4+1, result.Sum)
+		require.Len(t, report.Errors, 1)
+		require.ErrorIs(t, &report.Errors[0], ErrSymlinkLoop)
+		require.Equal(t, filepath.Join(rootDir, "a", "nested", "loop"), report.Errors[0].Path)
+
+		conf.ErrorPolicy = FailFast
+
+		_, err = c.Collect(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner)
+		require.ErrorIs(t, err, ErrSymlinkLoop)
+	})
+
+	t.Run("skip loops", func(t *testing.T) {
+		conf := conf
+		conf.SkipSymlinkLoops = true
+
+		result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 1+2+4+1, result.Sum)
+	})
+
+	t.Run("several paths", func(t *testing.T) {
+		conf := conf
+		conf.SkipSymlinkLoops = true
+
+		// "b" is listed through "b" or "a/b", whichever is reached first,
+		// and the pattern selects only the first path
+		conf.Include = []string{"b/*"}
+
+		for range 20 {
+			result, err := c.Collect(ctx, fs.NewOsFileSystem(), rootDir, conf, sum, combiner)
+			require.NoError(t, err)
+			require.Contains(t, []int64{0, 2}, result.Sum)
+		}
+	})
+
+	t.Run("same directory by another real path", func(t *testing.T) {
+		info, err := os.Stat(rootDir)
+		require.NoError(t, err)
+
+		if _, _, ok := fs.FileID(info); !ok {
+			t.Skip("no device and inode numbers on this platform")
+		}
+
+		links, err := newSymlinks(conf, fs.NewOsFileSystem())
+		require.NoError(t, err)
+
+		root := &directory{path: rootDir, canonical: rootDir}
+		ok, err := links.enter(root)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// a bind mount gives a directory a second real path, so its canonical paths differ
+		ok, err = links.enter(&directory{path: filepath.Join(rootDir, "b"), canonical: "/mnt/first/b", parent: root})
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = links.enter(&directory{path: filepath.Join(rootDir, "b"), canonical: "/mnt/second/b", parent: root})
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("unsupported file system", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		_, err := c.Collect(ctx, mocks.NewMockFileSystem(ctrl), rootDir, conf, sum, combiner)
+		require.Error(t, err)
+	})
+}
+
+func TestArchives(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	file, err := os.Create(filepath.Join(rootDir, "bundle.zip"))
+	require.NoError(t, err)
+
+	writer := zip.NewWriter(file)
+
+	for i := range 10 {
+		w, err := writer.Create(fmt.Sprintf("%d/%d.json", i%2, i))
+		require.NoError(t, err)
+
+		_, err = fmt.Fprintf(w, `{"data": %d}`, i)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "plain.json"), []byte(`{"data": 100}`), 0o600))
+
+	c := New[TestType, TestAccumulator]()
+	result, err := c.Collect(ctx, fs.NewArchiveFileSystem(fs.NewOsFileSystem()), rootDir, Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+		Include:            []string{"bundle.zip/1/*", "plain.json"},
+	}, sum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 1+3+5+7+9+100, result.Sum)
+}
+
+func TestMemoryFileSystem(t *testing.T) {
+	ctx := context.Background()
+	fileSystem := fs.NewMemoryFileSystem()
+
+	for i := range 10 {
+		name := fileSystem.Join("data", strconv.Itoa(i%3), strconv.Itoa(i)+".json")
+		require.NoError(t, fileSystem.WriteFile(name, []byte(fmt.Sprintf(`{"data": %d}`, i))))
+	}
+
+	errInjected := errors.New("injected")
+
+	fileSystem.InjectFault(fs.OpOpen, "data/0/0.json", fs.Fault{Err: errInjected})
+	fileSystem.InjectFault(fs.OpRead, "data/1/1.json", fs.Fault{Panic: "read panic"})
+	fileSystem.InjectFault(fs.OpReadDir, "data/2", fs.Fault{Err: errInjected, Latency: time.Millisecond})
+
+	// a loop detected by the canonical paths, as the file system has no inodes
+	require.NoError(t, fileSystem.Symlink("..", "data/1/loop"))
+
+	c := New[TestType, TestAccumulator]()
+	result, report, err := c.CollectWithReport(ctx, fileSystem, "data", Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+		ErrorPolicy:        SkipAndReport,
+		FollowSymlinks:     true,
+	}, sum, combiner)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 3+4+6+7+9, result.Sum)
+
+	stages := make(map[string]Stage)
+	for _, fileErr := range report.Errors {
+		stages[fileErr.Path] = fileErr.Stage
+	}
+
+	require.Equal(t, map[string]Stage{
+		"data/0/0.json": StageOpen,
+		"data/1/1.json": StageDecode,
+		"data/2":        StageList,
+		"data/1/loop":   StageList,
+	}, stages)
+}
+
+// concurrencyFileSystem tracks the maximum number of files and directories open at once.
+type concurrencyFileSystem struct {
+	fs.FileSystem
+
+	mu      sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (c *concurrencyFileSystem) opened() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.open++
+	c.maxOpen = max(c.maxOpen, c.open)
+}
+
+func (c *concurrencyFileSystem) closed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.open--
+}
+
+func (c *concurrencyFileSystem) Open(name string) (fs.File, error) {
+	c.opened()
+	time.Sleep(time.Millisecond)
+
+	file, err := c.FileSystem.Open(name)
+	if err != nil {
+		c.closed()
+		return nil, err
+	}
+
+	return &concurrencyFile{File: file, fs: c}, nil
+}
+
+func (c *concurrencyFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	c.opened()
+	defer c.closed()
+
+	time.Sleep(time.Millisecond)
+
+	return c.FileSystem.ReadDir(name)
+}
+
+type concurrencyFile struct {
+	fs.File
+	fs *concurrencyFileSystem
+}
+
+func (f *concurrencyFile) Close() error {
+	f.fs.closed()
+	return f.File.Close()
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	memory := fs.NewMemoryFileSystem()
+
+	for i := range 20 {
+		name := memory.Join(strconv.Itoa(i%4), strconv.Itoa(i)+".json")
+		require.NoError(t, memory.WriteFile(name, []byte(fmt.Sprintf(`{"data": %2d}`, i))))
+	}
+
+	conf := Configuration{
+		SearchWorkers:      4,
+		FileWorkers:        10,
+		AccumulatorWorkers: 2,
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	t.Run("open files", func(t *testing.T) {
+		conf := conf
+		conf.MaxOpenFiles = 2
+
+		fileSystem := &concurrencyFileSystem{FileSystem: memory}
+
+		result, err := c.Collect(ctx, fileSystem, ".", conf, sum, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 190, result.Sum)
+		require.LessOrEqual(t, fileSystem.maxOpen, 2)
+		require.Zero(t, fileSystem.open)
+	})
+
+	t.Run("files per second", func(t *testing.T) {
+		conf := conf
+		conf.MaxFilesPerSecond = 100
+
+		start := time.Now()
+
+		result, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 190, result.Sum)
+
+		// 20 files and 5 directories
+		require.GreaterOrEqual(t, time.Since(start), 240*time.Millisecond)
+	})
+
+	t.Run("bytes per second", func(t *testing.T) {
+		conf := conf
+		conf.MaxBytesPerSecond = 1000
+
+		start := time.Now()
+
+		result, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+		require.NoError(t, err)
+		require.EqualValues(t, 190, result.Sum)
+
+		// 20 files of 12 bytes, the last read is paid after it is done
+		require.GreaterOrEqual(t, time.Since(start), 228*time.Millisecond)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		conf := conf
+		conf.MaxOpenFiles = 1
+		conf.MaxFilesPerSecond = 1
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+
+		_, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("abandoned stream", func(t *testing.T) {
+		conf := conf
+		conf.MaxFilesPerSecond = 10
+		conf.StreamEvery = 1
+
+		var stopped time.Time
+
+		for range c.CollectStream(ctx, memory, ".", conf, sum, combiner) {
+			stopped = time.Now()
+			break
+		}
+
+		// the file workers waiting for the limits stop with the stream
+		require.Less(t, time.Since(stopped), 300*time.Millisecond)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		conf := conf
+		conf.MaxOpenFiles = -1
+
+		_, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+		require.Error(t, err)
+	})
+}
+
+// transientFileSystem fails the first call of every path with EAGAIN.
+type transientFileSystem struct {
+	fs.FileSystem
+	failed sync.Map
+}
+
+func (f *transientFileSystem) Open(name string) (fs.File, error) {
+	if _, failed := f.failed.LoadOrStore(name, true); !failed {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EAGAIN}
+	}
+
+	return f.FileSystem.Open(name)
+}
+
+func (f *transientFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	if _, failed := f.failed.LoadOrStore(name, true); !failed {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.EAGAIN}
+	}
+
+	return f.FileSystem.ReadDir(name)
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	memory := fs.NewMemoryFileSystem()
+
+	for i := range 10 {
+		name := memory.Join(strconv.Itoa(i%2), strconv.Itoa(i)+".json")
+		require.NoError(t, memory.WriteFile(name, []byte(fmt.Sprintf(`{"data": %d}`, i))))
+	}
+
+	// the decorators forward the context to the retries
+	decorators := map[string]func(fileSystem fs.FileSystem) fs.FileSystem{
+		"retry": func(fileSystem fs.FileSystem) fs.FileSystem {
+			return fileSystem
+		},
+		"archive": func(fileSystem fs.FileSystem) fs.FileSystem {
+			return fs.NewArchiveFileSystem(fileSystem)
+		},
+	}
+
+	for name, decorate := range decorators {
+		t.Run(name, func(t *testing.T) {
+			var final Progress
+
+			fileSystem := fs.NewRetryFileSystem(&transientFileSystem{FileSystem: memory}, fs.RetryPolicy{
+				Attempts:       2,
+				InitialBackoff: time.Millisecond,
+			})
+
+			c := New[TestType, TestAccumulator]()
+			result, report, err := c.CollectWithReport(ctx, decorate(fileSystem), ".", Configuration{
+				SearchWorkers:      2,
+				FileWorkers:        2,
+				AccumulatorWorkers: 2,
+				OnProgress: func(p Progress) {
+					final = p
+				},
+			}, sum, combiner)
+
+			require.NoError(t, err)
+			require.EqualValues(t, 45, result.Sum)
+			require.Empty(t, report.Errors)
+
+			// 10 files and 3 directories
+			require.EqualValues(t, 13, report.Retries)
+			require.EqualValues(t, 13, final.Retries)
+		})
+	}
+}
+
+type countingFileSystem struct {
+	fs.FileSystem
+	opens atomic.Int64
+}
+
+func (c *countingFileSystem) Open(name string) (fs.File, error) {
+	c.opens.Add(1)
+	return c.FileSystem.Open(name)
+}
+
+func TestCollectIncremental(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+
+	writeTree(t, rootDir, numberedTree(10, 2))
+
+	var accumulated atomic.Int64
+
+	counting := func(current TestType, accum TestAccumulator) TestAccumulator {
+		accumulated.Add(1)
+
+		accum.Sum += current.Data
+		return accum
+	}
+
+	conf := Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+	}
+
+	incremental := Incremental[TestAccumulator]{
+		Manifest: filepath.Join(t.TempDir(), "manifest.json"),
+		Codec:    JSONCodec[TestAccumulator](),
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	collect := func(t *testing.T, expected int64, opens, accumulations int64) {
+		t.Helper()
+
+		fileSystem := &countingFileSystem{FileSystem: fs.NewOsFileSystem()}
+		accumulated.Store(0)
+
+		result, err := c.CollectIncremental(ctx, fileSystem, rootDir, conf, incremental, counting, combiner)
+		require.NoError(t, err)
+		require.Equal(t, expected, result.Sum)
+		require.Equal(t, opens, fileSystem.opens.Load())
+		require.Equal(t, accumulations, accumulated.Load())
+	}
+
+	collect(t, 45, 10, 10)
+	collect(t, 45, 0, 0)
+
+	changed := filepath.Join(rootDir, "0", "0.json")
+	require.NoError(t, os.WriteFile(changed, []byte(`{"data": 100}`), 0o600))
+	collect(t, 145, 1, 1)
+
+	touched := filepath.Join(rootDir, "1", "1.json")
+	require.NoError(t, os.Chtimes(touched, time.Now(), time.Now().Add(time.Hour)))
+	collect(t, 145, 1, 0)
+	collect(t, 145, 0, 0)
+
+	require.NoError(t, os.Remove(touched))
+	collect(t, 144, 0, 0)
+
+	// the stored results are discarded for a new version or other decoders
+	incremental.Version = "2"
+	collect(t, 144, 9, 9)
+	collect(t, 144, 0, 0)
+
+	conf.Decoders = decoder.Registry{".txt": decoder.CSV}
+	collect(t, 144, 9, 9)
+	collect(t, 144, 0, 0)
+
+	conf.Decoders = nil
+
+	t.Run("resume", func(t *testing.T) {
+		incremental := incremental
+		incremental.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+
+		conf := conf
+		conf.FileWorkers, conf.AccumulatorWorkers = 1, 1
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var calls atomic.Int64
+
+		cancelling := func(current TestType, accum TestAccumulator) TestAccumulator {
+			if calls.Add(1) == 3 {
+				cancel()
+			}
+
+			return counting(current, accum)
+		}
+
+		_, err := c.CollectIncremental(ctx, fs.NewOsFileSystem(), rootDir, conf, incremental, cancelling, combiner)
+		require.ErrorIs(t, err, context.Canceled)
+
+		fileSystem := &countingFileSystem{FileSystem: fs.NewOsFileSystem()}
+
+		result, err := c.CollectIncremental(
+			context.Background(), fileSystem, rootDir, conf, incremental, counting, combiner,
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 144, result.Sum)
+		require.LessOrEqual(t, fileSystem.opens.Load(), int64(9-3))
+	})
+
+	t.Run("corrupted manifest", func(t *testing.T) {
+		incremental := incremental
+		incremental.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+		require.NoError(t, os.WriteFile(incremental.Manifest, []byte("{"), 0o600))
+
+		_, err := c.CollectIncremental(ctx, fs.NewOsFileSystem(), rootDir, conf, incremental, counting, combiner)
+		require.Error(t, err)
+	})
+}
+
+func TestFileScaling(t *testing.T) {
+	ctx := context.Background()
+	memory := fs.NewMemoryFileSystem()
+
+	// slow files build up a backlog for a single file worker
+	for i := range 100 {
+		name := memory.Join(strconv.Itoa(i%4), strconv.Itoa(i)+".json")
+		require.NoError(t, memory.WriteFile(name, []byte(fmt.Sprintf(`{"data": %2d}`, i))))
+		memory.InjectFault(fs.OpOpen, name, fs.Fault{Latency: 5 * time.Millisecond})
+	}
+
+	var (
+		mu        sync.Mutex
+		decisions []workerpool.Decision
+	)
+
+	conf := Configuration{
+		SearchWorkers:      4,
+		AccumulatorWorkers: 2,
+		FileScaling: &workerpool.Scaling{
+			Min:      1,
+			Max:      8,
+			Interval: 10 * time.Millisecond,
+			OnDecision: func(decision workerpool.Decision) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				decisions = append(decisions, decision)
+			},
+		},
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	result, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+	require.NoError(t, err)
+	require.EqualValues(t, 4950, result.Sum)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.NotEmpty(t, decisions)
+	require.Greater(t, decisions[0].To, decisions[0].From)
+
+	conf.FileScaling = &workerpool.Scaling{Min: 2, Max: 1}
+
+	_, err = c.Collect(ctx, memory, ".", conf, sum, combiner)
+	require.Error(t, err)
+}
+
+func TestStageScaling(t *testing.T) {
+	ctx := context.Background()
+	memory := fs.NewMemoryFileSystem()
+
+	for i := range 100 {
+		name := memory.Join(strconv.Itoa(i%4), strconv.Itoa(i%3), strconv.Itoa(i)+".json")
+		require.NoError(t, memory.WriteFile(name, []byte(fmt.Sprintf(`{"data": %2d}`, i))))
+	}
+
+	scaling := func() *workerpool.Scaling {
+		return &workerpool.Scaling{Min: 1, Max: 4, Interval: time.Millisecond}
+	}
+
+	// every stage is scaled, without any fixed number of workers
+	conf := Configuration{
+		SearchScaling:      scaling(),
+		FileScaling:        scaling(),
+		AccumulatorScaling: scaling(),
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	result, err := c.Collect(ctx, memory, ".", conf, sum, combiner)
+	require.NoError(t, err)
+	require.EqualValues(t, 4950, result.Sum)
+
+	byKey, err := CollectByKey(ctx, memory, ".", conf, func(value TestType) int64 {
+		return value.Data % 2
+	}, sum)
+	require.NoError(t, err)
+	require.EqualValues(t, 2450, byKey[0].Sum)
+	require.EqualValues(t, 2500, byKey[1].Sum)
+
+	for name, invalid := range map[string]func(conf *Configuration){
+		"search": func(conf *Configuration) {
+			conf.SearchScaling = &workerpool.Scaling{Min: 2, Max: 1}
+		},
+		"accumulator": func(conf *Configuration) {
+			conf.AccumulatorScaling = &workerpool.Scaling{Min: 0, Max: 1}
+		},
+	} {
+		conf := conf
+		invalid(&conf)
+
+		_, err = c.Collect(ctx, memory, ".", conf, sum, combiner)
+		require.ErrorContains(t, err, "invalid "+name+" worker bounds")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+
+	conf := Configuration{
+		SearchWorkers:      2,
+		FileWorkers:        2,
+		AccumulatorWorkers: 1,
+	}
+
+	c := New[TestType, TestAccumulator]()
+
+	watchConf := WatchConfiguration[TestAccumulator]{
+		Codec:        JSONCodec[TestAccumulator](),
+		Debounce:     10 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	}
+
+	next := func(t *testing.T, next func() (TestAccumulator, error, bool)) int64 {
+		t.Helper()
+
+		result, err, ok := next()
+		require.True(t, ok)
+		require.NoError(t, err)
+
+		return result.Sum
+	}
+
+	for _, subtract := range []bool{false, true} {
+		t.Run(fmt.Sprintf("poll subtract=%v", subtract), func(t *testing.T) {
+			goroutines := runtime.NumGoroutine()
+			memory := fs.NewMemoryFileSystem()
+
+			require.NoError(t, memory.WriteFile(memory.Join("a", "1.json"), []byte(`{"data": 1}`)))
+			require.NoError(t, memory.WriteFile(memory.Join("b", "2.json"), []byte(`{"data": 2}`)))
+
+			watchConf := watchConf
+			if subtract {
+				watchConf.Subtract = func(removed, accum TestAccumulator) TestAccumulator {
+					accum.Sum -= removed.Sum
+					return accum
+				}
+			}
+
+			pull, stop := iter.Pull2(c.Watch(ctx, memory, ".", conf, watchConf, sum, combiner))
+			defer stop()
+
+			require.EqualValues(t, 3, next(t, pull))
+
+			require.NoError(t, memory.WriteFile(memory.Join("c", "4.json"), []byte(`{"data": 4}`)))
+			require.EqualValues(t, 7, next(t, pull))
+
+			require.NoError(t, memory.WriteFile(memory.Join("a", "1.json"), []byte(`{"data": 10}`)))
+			require.EqualValues(t, 16, next(t, pull))
+
+			require.NoError(t, memory.Remove(memory.Join("b", "2.json")))
+			require.EqualValues(t, 14, next(t, pull))
+
+			stop()
+			require.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+		})
+	}
+
+	t.Run("notify", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("inotify is only used on Linux")
+		}
+
+		rootDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "1.json"), []byte(`{"data": 1}`), 0o600))
+
+		// the changes are never polled, so only the notifications update the result
+		watchConf := watchConf
+		watchConf.PollInterval = time.Hour
+
+		pull, stop := iter.Pull2(c.Watch(ctx, fs.NewOsFileSystem(), rootDir, conf, watchConf, sum, combiner))
+		defer stop()
+
+		require.EqualValues(t, 1, next(t, pull))
+
+		// a file in a new directory, which must be watched as well
+		inner := filepath.Join(rootDir, "inner")
+		require.NoError(t, os.Mkdir(inner, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(inner, "2.json"), []byte(`{"data": 2}`), 0o600))
+		require.EqualValues(t, 3, next(t, pull))
+
+		require.NoError(t, os.WriteFile(filepath.Join(inner, "3.json"), []byte(`{"data": 3}`), 0o600))
+		require.EqualValues(t, 6, next(t, pull))
+
+		require.NoError(t, os.Remove(filepath.Join(rootDir, "1.json")))
+		require.EqualValues(t, 5, next(t, pull))
+	})
+
+	t.Run("notify changed paths", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("inotify is only used on Linux")
+		}
+
+		rootDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "1.json"), []byte(`{"data": 1}`), 0o600))
+		writeTree(t, filepath.Join(rootDir, "big"), numberedTree(10, 5))
+
+		var (
+			mu   sync.Mutex
+			last Progress
+		)
+
+		conf := conf
+		conf.OnProgress = func(progress Progress) {
+			if progress.Done {
+				mu.Lock()
+				last = progress
+				mu.Unlock()
+			}
+		}
+
+		updated := func() Progress {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return last
+		}
+
+		watchConf := watchConf
+		watchConf.PollInterval = time.Hour
+
+		pull, stop := iter.Pull2(c.Watch(ctx, fs.NewOsFileSystem(), rootDir, conf, watchConf, sum, combiner))
+		defer stop()
+
+		require.EqualValues(t, 46, next(t, pull))
+		require.EqualValues(t, 7, updated().DirectoriesListed)
+
+		// only the directory of the changed file is listed, without its subdirectories
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "1.json"), []byte(`{"data": 2}`), 0o600))
+		require.EqualValues(t, 47, next(t, pull))
+		require.EqualValues(t, 1, updated().DirectoriesListed)
+		require.EqualValues(t, 1, updated().FilesDecoded)
+
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, "big", "3", "new.json"), []byte(`{"data": 100}`), 0o600))
+		require.EqualValues(t, 147, next(t, pull))
+		require.EqualValues(t, 1, updated().DirectoriesListed)
+		require.EqualValues(t, 1, updated().FilesDecoded)
+
+		// the files of a removed directory are dropped without crawling anything
+		require.NoError(t, os.RemoveAll(filepath.Join(rootDir, "big", "3")))
+		require.EqualValues(t, 36, next(t, pull))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		memory := fs.NewMemoryFileSystem()
+		require.NoError(t, memory.WriteFile("1.json", []byte(`{"data": 1}`)))
+
+		for _, err := range c.Watch(ctx, memory, ".", conf, WatchConfiguration[TestAccumulator]{}, sum, combiner) {
+			require.ErrorContains(t, err, "codec")
+		}
+
+		pull, stop := iter.Pull2(c.Watch(ctx, memory, ".", conf, watchConf, sum, combiner))
+		defer stop()
+
+		require.EqualValues(t, 1, next(t, pull))
+
+		// a broken file fails the crawl, and the watch goes on once it is fixed
+		require.NoError(t, memory.WriteFile("2.json", []byte(`{"data": `)))
+
+		_, err, ok := pull()
+		require.True(t, ok)
+		require.Error(t, err)
+
+		require.NoError(t, memory.WriteFile("2.json", []byte(`{"data": 2}`)))
+		require.EqualValues(t, 3, next(t, pull))
+	})
+
+	for _, subtract := range []bool{false, true} {
+		t.Run(fmt.Sprintf("decode failure subtract=%v", subtract), func(t *testing.T) {
+			memory := fs.NewMemoryFileSystem()
+			require.NoError(t, memory.WriteFile("1.json", []byte(`{"data": 1}`)))
+			require.NoError(t, memory.WriteFile("2.json", []byte(`{"data": 2}`)))
+
+			watchConf := watchConf
+			watchConf.Codec = unluckyCodec{JSONCodec[TestAccumulator]()}
+
+			if subtract {
+				watchConf.Subtract = func(removed, accum TestAccumulator) TestAccumulator {
+					accum.Sum -= removed.Sum
+					return accum
+				}
+			}
+
+			pull, stop := iter.Pull2(c.Watch(ctx, memory, ".", conf, watchConf, sum, combiner))
+			defer stop()
+
+			require.EqualValues(t, 3, next(t, pull))
+
+			// the result of 13.json is stored but cannot be decoded, so nothing is applied
+			require.NoError(t, memory.WriteFile("13.json", []byte(`{"data": 13}`)))
+
+			_, err, ok := pull()
+			require.True(t, ok)
+			require.ErrorIs(t, err, errUnlucky)
+
+			// the next update starts from the entries before the failure
+			require.NoError(t, memory.Remove("13.json"))
+			require.NoError(t, memory.WriteFile("4.json", []byte(`{"data": 4}`)))
+			require.EqualValues(t, 7, next(t, pull))
+		})
+	}
+}
+
+var errUnlucky = errors.New("unlucky result")
+
+// unluckyCodec fails to decode the results summing to 13.
+type unluckyCodec struct {
+	Codec[TestAccumulator]
+}
+
+func (c unluckyCodec) Decode(data []byte) (TestAccumulator, error) {
+	result, err := c.Codec.Decode(data)
+	if err == nil && result.Sum == 13 {
+		return TestAccumulator{}, errUnlucky
+	}
+
+	return result, err
+}
+
+func TestCollectSpilled(t *testing.T) {
+	ctx := context.Background()
+
+	memory := fs.NewMemoryFileSystem()
+	expected := make(map[string]int64)
+
+	for i := range 50 {
+		require.NoError(t, memory.WriteFile(memory.Join(strconv.Itoa(i%3), strconv.Itoa(i)+".json"), []byte(fmt.Sprintf(`{"data": %d}`, i))))
+		expected[fmt.Sprintf("key-%02d", i%7)] += int64(i)
+	}
+
+	conf := Configuration{SearchWorkers: 2, FileWorkers: 2, AccumulatorWorkers: 2}
+
+	accumulator := func(current TestType, accum map[string]TestAccumulator) map[string]TestAccumulator {
+		if accum == nil {
+			accum = make(map[string]TestAccumulator)
+		}
+
+		key := fmt.Sprintf("key-%02d", current.Data%7)
+		accum[key] = TestAccumulator{Sum: accum[key].Sum + current.Data}
+
+		return accum
+	}
+
+	collect := func(t *testing.T, maxBytes int64) (map[string]int64, int) {
+		dir := t.TempDir()
+
+		var (
+			result = make(map[string]int64)
+			keys   []string
+			runs   = -1
+		)
+
+		for entry, err := range CollectSpilled(ctx, memory, ".", conf, Spill{MaxBytes: maxBytes, Dir: dir}, accumulator, add) {
+			require.NoError(t, err)
+
+			if runs < 0 {
+				entries, err := os.ReadDir(dir)
+				require.NoError(t, err)
+
+				runs = len(entries)
+			}
+
+			keys = append(keys, entry.Key)
+			result[entry.Key] = entry.Value.Sum
+		}
+
+		require.True(t, slices.IsSorted(keys))
+
+		// the run files are removed
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+
+		return result, runs
+	}
+
+	t.Run("spilled", func(t *testing.T) {
+		result, runs := collect(t, 1)
+		require.Equal(t, expected, result)
+		require.Greater(t, runs, 10)
+	})
+
+	t.Run("in memory", func(t *testing.T) {
+		result, runs := collect(t, 1<<20)
+		require.Equal(t, expected, result)
+		require.Zero(t, runs)
+	})
+
+	t.Run("encoded size", func(t *testing.T) {
+		concat := func(current, accum string) string { return accum + current }
+
+		// the same number of keys is held in memory while the values are small,
+		// and spilled once they are large
+		for _, length := range []int{8, 1024} {
+			s := newSpiller[int](Spill{MaxBytes: 4096, Dir: t.TempDir()}, concat)
+
+			for key := range 10 {
+				require.NoError(t, s.add(map[int]string{key: strings.Repeat("x", length)}))
+			}
+
+			s.remove()
+
+			if length < 1024 {
+				require.Empty(t, s.runs)
+				require.Len(t, s.memory, 10)
+			} else {
+				require.Len(t, s.runs, 2)
+			}
+		}
+	})
+
+	t.Run("break", func(t *testing.T) {
+		dir := t.TempDir()
+
+		for range CollectSpilled(ctx, memory, ".", conf, Spill{MaxBytes: 1, Dir: dir}, accumulator, add) {
+			break
+		}
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing")
+
+		var last error
+		for _, err := range CollectSpilled(ctx, memory, ".", conf, Spill{MaxBytes: 1, Dir: missing}, accumulator, add) {
+			last = err
+		}
+
+		require.ErrorContains(t, last, "spill")
+		require.NotErrorIs(t, last, context.Canceled)
+
+		broken := fs.NewMemoryFileSystem()
+		require.NoError(t, broken.WriteFile("1.json", []byte(`{"data": 1}`)))
+		require.NoError(t, broken.WriteFile("2.json", []byte(`{"data": `)))
+
+		last = nil
+		for _, err := range CollectSpilled(ctx, broken, ".", conf, Spill{MaxBytes: 1, Dir: t.TempDir()}, accumulator, add) {
+			last = err
+		}
+
+		require.ErrorContains(t, last, "2.json")
+	})
+}
+
+// arrayFileSystem serves the files of the underlying file system, except for the ones named
+// "huge.json", which hold a JSON array of the given number of elements generated while
+// they are read. The bytes read from them are counted.
+type arrayFileSystem struct {
+	fs.FileSystem
+	elements int
+	read     *atomic.Int64
+}
+
+func (a arrayFileSystem) Open(name string) (fs.File, error) {
+	if filepath.Base(name) != "huge.json" {
+		return a.FileSystem.Open(name)
+	}
+
+	return io.NopCloser(&arrayReader{elements: a.elements, read: a.read}), nil
+}
+
+// arrayReader generates a JSON array of {"data": 1} elements.
+type arrayReader struct {
+	elements int
+	next     int
+	pending  []byte
+	read     *atomic.Int64
+}
+
+func (r *arrayReader) Read(p []byte) (int, error) {
+	for len(r.pending) < len(p) && r.next <= r.elements {
+		switch {
+		case r.next == 0:
+			r.pending = append(r.pending, `[{"data": 1}`...)
+		case r.next < r.elements:
+			r.pending = append(r.pending, `,{"data": 1}`...)
+		default:
+			r.pending = append(r.pending, ']')
+		}
+
+		r.next++
+	}
+
+	if len(r.pending) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.read.Add(int64(n))
+
+	return n, nil
+}
+
+func TestStreamValues(t *testing.T) {
+	ctx := context.Background()
+
+	const elements = 100000
+
+	memory := fs.NewMemoryFileSystem()
+	require.NoError(t, memory.WriteFile("huge.json", nil))
+	require.NoError(t, memory.WriteFile("small.json", []byte(`[{"data": 2}, {"data": "x"}, {"data": 3}]`)))
+	require.NoError(t, memory.WriteFile("broken.json", []byte(`[{"data": 5}, {"data": `)))
+
+	conf := Configuration{
+		SearchWorkers:      1,
+		FileWorkers:        2,
+		AccumulatorWorkers: 2,
+		Decoders:           decoder.Registry{".json": decoder.JSONArray},
+		StreamValues:       true,
+		ErrorPolicy:        SkipAndReport,
+	}
+
+	collect := func(t *testing.T, conf Configuration) (int64, int64, Report, error) {
+		read := new(atomic.Int64)
+		firstRead := new(atomic.Int64)
+		firstRead.Store(-1)
+
+		result, report, err := New[TestType, TestAccumulator]().CollectWithReport(ctx,
+			arrayFileSystem{FileSystem: memory, elements: elements, read: read}, ".", conf,
+			func(current TestType, accum TestAccumulator) TestAccumulator {
+				if current.Data == 1 {
+					firstRead.CompareAndSwap(-1, read.Load())
+				}
+
+				accum.Sum += current.Data
+
+				return accum
+			}, add)
+
+		return result.Sum, firstRead.Load(), report, err
+	}
+
+	t.Run("streamed", func(t *testing.T) {
+		total, firstRead, report, err := collect(t, conf)
+		require.NoError(t, err)
+
+		// the values of the broken file decoded before it failed are kept
+		require.EqualValues(t, elements+2+3+5, total)
+
+		// the first element is accumulated long before the whole array is read
+		require.Less(t, firstRead, int64(64<<10))
+
+		require.Len(t, report.Errors, 2)
+
+		failures := make(map[string]string)
+		for _, fileErr := range report.Errors {
+			require.Equal(t, StageDecode, fileErr.Stage)
+			failures[fileErr.Path] = fileErr.Err.Error()
+		}
+
+		require.Contains(t, failures["small.json"], "value 1")
+		require.Contains(t, failures, "broken.json")
+
+		// the files are decoded by the decode stage, which passes on every value
+		require.Equal(t, []string{"search", "decode", "accumulate"},
+			[]string{report.Stages[0].Name, report.Stages[1].Name, report.Stages[2].Name})
+		require.EqualValues(t, 3, report.Stages[1].Items)
+		require.EqualValues(t, elements+2+1, report.Stages[2].Items)
+	})
+
+	t.Run("collected", func(t *testing.T) {
+		conf := conf
+		conf.StreamValues = false
+
+		total, firstRead, _, err := collect(t, conf)
+		require.NoError(t, err)
+
+		// a failing file yields no values
+		require.EqualValues(t, elements+2+3, total)
+
+		// all values of a file are decoded before they are accumulated
+		require.Greater(t, firstRead, int64(elements*10))
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		conf := conf
+		conf.ErrorPolicy = FailFast
+
+		_, _, _, err := collect(t, conf)
+
+		var fileErr *FileError
+		require.ErrorAs(t, err, &fileErr)
+		require.Equal(t, StageDecode, fileErr.Stage)
+	})
+}
+
+func TestDecompression(t *testing.T) {
+	ctx := context.Background()
+
+	gzipped := func(contents string) []byte {
+		buf := new(bytes.Buffer)
+		writer := gzip.NewWriter(buf)
+		_, err := writer.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return buf.Bytes()
+	}
+
+	// bzip2 -c of {"data": 2} and {"data": 3} on separate lines
+	bzipped, err := hex.DecodeString("425a6839314159265359879fd89b00000a59800010500018102400040a2000212a184d3f5420" +
+		"1a69a12ce8945889c28c17e2ee48a70a1210f3fb1360")
+	require.NoError(t, err)
+
+	files := map[string][]byte{
+		"a.json.gz":    gzipped(`{"data": 1}`),
+		"b.ndjson.bz2": bzipped,
+		"c.json":       gzipped(`{"data": 4}`), // recognised by its contents
+		"d.json":       []byte(`{"data": 5}`),
+		"e.json.gz":    []byte(`{"data": 6, "padding": "not gzipped"}`),
+	}
+
+	memory := fs.NewMemoryFileSystem()
+
+	var read, compressed int64
+
+	for name, data := range files {
+		require.NoError(t, memory.WriteFile(name, data))
+
+		read += int64(len(data))
+
+		if name != "d.json" && name != "e.json.gz" {
+			compressed += int64(len(data))
+		}
+	}
+
+	decompressed := int64(len(`{"data": 1}`) + len("{\"data\": 2}\n{\"data\": 3}\n") + len(`{"data": 4}`))
+
+	var final Progress
+
+	conf := Configuration{
+		SearchWorkers:      1,
+		FileWorkers:        2,
+		AccumulatorWorkers: 1,
+		ErrorPolicy:        SkipAndReport,
+	}
+
+	// the report counts the compressed bytes without a progress callback
+	_, report, err := New[TestType, TestAccumulator]().CollectWithReport(ctx, memory, ".", conf, sum, add)
+	require.NoError(t, err)
+	require.Equal(t, compressed, report.BytesCompressed)
+	require.Equal(t, decompressed, report.BytesDecompressed)
+
+	conf.OnProgress = func(p Progress) {
+		final = p
+	}
+
+	result, report, err := New[TestType, TestAccumulator]().CollectWithReport(ctx, memory, ".", conf, sum, add)
+
+	require.NoError(t, err)
+	require.EqualValues(t, 1+2+3+4+5, result.Sum)
+
+	require.Len(t, report.Errors, 1)
+	require.Equal(t, "e.json.gz", report.Errors[0].Path)
+	require.Equal(t, StageDecode, report.Errors[0].Stage)
+	require.ErrorIs(t, report.Errors[0].Err, gzip.ErrHeader)
+
+	require.True(t, final.Done)
+	require.EqualValues(t, 4, final.FilesDecoded)
+	require.Equal(t, read, final.BytesRead)
+	require.Equal(t, compressed, final.BytesCompressed)
+	require.Equal(t, decompressed, final.BytesDecompressed)
+	require.Equal(t, final.BytesCompressed, report.BytesCompressed)
+	require.Equal(t, final.BytesDecompressed, report.BytesDecompressed)
+}
+
+// keyedTree returns a memory file system of NDJSON files and the sums of their values by key.
+func keyedTree(tb testing.TB, files, lines, keys int) (fs.FileSystem, map[int64]int64) {
+	tb.Helper()
+
+	memory := fs.NewMemoryFileSystem()
+	expected := make(map[int64]int64)
+
+	for i := range files {
+		var data strings.Builder
+
+		for j := range lines {
+			value := int64(i*lines + j)
+			expected[value%int64(keys)] += value
+
+			_, _ = fmt.Fprintf(&data, "{\"data\": %d}\n", value)
+		}
+
+		require.NoError(tb, memory.WriteFile(memory.Join(strconv.Itoa(i%4), strconv.Itoa(i)+".ndjson"), []byte(data.String())))
+	}
+
+	return memory, expected
+}
+
+func TestCollectByKey(t *testing.T) {
+	ctx := context.Background()
+
+	memory, expected := keyedTree(t, 20, 50, 13)
+
+	conf := Configuration{SearchWorkers: 2, FileWorkers: 4, AccumulatorWorkers: 4}
+
+	key := func(current TestType) int64 {
+		return current.Data % 13
+	}
+
+	result, err := CollectByKey(ctx, memory, ".", conf, key, sum)
+	require.NoError(t, err)
+	require.Len(t, result, len(expected))
+
+	for k, v := range expected {
+		require.Equal(t, TestAccumulator{Sum: v}, result[k])
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		result, err := CollectByKey(ctx, fs.NewMemoryFileSystem(), ".", conf, key, sum)
+		require.NoError(t, err)
+		require.NotNil(t, result)
+		require.Empty(t, result)
+	})
+
+	t.Run("panics", func(t *testing.T) {
+		conf := conf
+		conf.ErrorPolicy = SkipAndReport
+
+		// the values 7 and 8 are dropped
+		result, err := CollectByKey(ctx, memory, ".", conf, func(current TestType) int64 {
+			if current.Data == 7 {
+				panic("key")
+			}
+
+			return current.Data % 13
+		}, func(current TestType, accum TestAccumulator) TestAccumulator {
+			if current.Data == 8 {
+				panic("accumulator")
+			}
+
+			accum.Sum += current.Data
+
+			return accum
+		})
+		require.NoError(t, err)
+		require.Equal(t, expected[7]-7, result[7].Sum)
+		require.Equal(t, expected[8]-8, result[8].Sum)
+
+		conf.ErrorPolicy = FailFast
+
+		_, err = CollectByKey(ctx, memory, ".", conf, func(TestType) int64 {
+			panic("key")
+		}, sum)
+		require.ErrorContains(t, err, "panic")
+	})
+}
+
+// BenchmarkCollectByKey compares CollectByKey with the accumulators carrying maps
+// in a single R, which the combiner merges.
+func BenchmarkCollectByKey(b *testing.B) {
+	ctx := context.Background()
+	conf := Configuration{SearchWorkers: 4, FileWorkers: 8, AccumulatorWorkers: 8}
+
+	for _, keys := range []int{16, 100000} {
+		memory, _ := keyedTree(b, 64, 2000, keys)
+
+		key := func(current TestType) int64 {
+			return current.Data % int64(keys)
+		}
+
+		b.Run(fmt.Sprintf("keys=%d/by key", keys), func(b *testing.B) {
+			for range b.N {
+				_, err := CollectByKey(ctx, memory, ".", conf, key, func(current TestType, accum int64) int64 {
+					return accum + current.Data
+				})
+				require.NoError(b, err)
+			}
+		})
+
+		b.Run(fmt.Sprintf("keys=%d/map in R", keys), func(b *testing.B) {
+			c := New[TestType, map[int64]int64]()
+
+			for range b.N {
+				_, err := c.Collect(ctx, memory, ".", conf, func(current TestType, accum map[int64]int64) map[int64]int64 {
+					if accum == nil {
+						accum = make(map[int64]int64)
+					}
+
+					accum[key(current)] += current.Data
+
+					return accum
+				}, func(current, accum map[int64]int64) map[int64]int64 {
+					if accum == nil {
+						return current
+					}
+
+					for k, v := range current {
+						accum[k] += v
+					}
+
+					return accum
+				})
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// workerEnv holds the address of the coordinator for the worker processes of TestDistributed.
+const workerEnv = "CRAWLER_TEST_COORDINATOR"
+
+// TestDistributedWorker is the worker process spawned by TestDistributed.
+func TestDistributedWorker(t *testing.T) {
+	address := os.Getenv(workerEnv)
+	if address == "" {
+		return
+	}
+
+	die := os.Getenv(workerEnv+"_DIE") != ""
+
+	dying := func(current TestType, accum TestAccumulator) TestAccumulator {
+		if die {
+			os.Exit(3)
+		}
+
+		accum.Sum += current.Data
+
+		return accum
+	}
+
+	conf := Configuration{SearchWorkers: 2, FileWorkers: 2, AccumulatorWorkers: 2}
+
+	c := New[TestType, TestAccumulator]()
+	if err := c.Work(context.Background(), "tcp", address, fs.NewOsFileSystem(), conf, JSONCodec[TestAccumulator](), dying, add); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func TestDistributed(t *testing.T) {
+	ctx := context.Background()
+
+	rootDir := t.TempDir()
+
+	files := map[string]string{
+		"1.json":          `{"data": 1}`,
+		"a/2.json":        `{"data": 2}`,
+		"a/b/3.json":      `{"data": 3}`,
+		"a/b/c/4.json":    `{"data": 4}`,
+		"d/5.json":        `{"data": 5}`,
+		"e/f/skipped.txt": `{"data": 100}`,
+		".hidden/6.json":  `{"data": 100}`,
+	}
+
+	writeTree(t, rootDir, files)
+
+	// the filters of the coordinator apply to the workers
+	conf := Configuration{Include: []string{"*.json"}, SkipHidden: true}
+	dist := Distribution[TestAccumulator]{Codec: JSONCodec[TestAccumulator](), SplitDepth: 2}
+
+	workerConf := Configuration{SearchWorkers: 2, FileWorkers: 2, AccumulatorWorkers: 2}
+
+	c := New[TestType, TestAccumulator]()
+
+	type outcome struct {
+		result TestAccumulator
+		err    error
+	}
+
+	coordinate := func(listener net.Listener, dist Distribution[TestAccumulator]) <-chan outcome {
+		done := make(chan outcome)
+
+		go func() {
+			result, err := c.Coordinate(ctx, listener, fs.NewOsFileSystem(), rootDir, conf, dist, add)
+			done <- outcome{result, err}
+		}()
+
+		return done
+	}
+
+	spawn := func(t *testing.T, address string, die bool) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDistributedWorker$")
+		cmd.Env = append(os.Environ(), workerEnv+"="+address)
+		cmd.Stderr = os.Stderr
+
+		if die {
+			cmd.Env = append(cmd.Env, workerEnv+"_DIE=1")
+		}
+
+		require.NoError(t, cmd.Start())
+
+		return cmd
+	}
+
+	t.Run("split", func(t *testing.T) {
+		tasks, err := split(fs.NewOsFileSystem(), rootDir, conf, 2)
+		require.NoError(t, err)
+
+		var parts []string
+		for _, task := range tasks {
+			parts = append(parts, fmt.Sprintf("%s %d %v", task.Rel, task.Depth, task.Recursive))
+		}
+
+		require.ElementsMatch(t, []string{". 0 false", "a 1 false", "a/b 2 true", "d 1 false", "e/f 2 true"}, parts)
+	})
+
+	t.Run("workers", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+
+		// the path of a unix socket is limited in length
+		socketDir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, os.RemoveAll(socketDir))
+		})
+
+		socket := filepath.Join(socketDir, "coordinator.sock")
+
+		listener, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+
+		done := coordinate(listener, dist)
+
+		var wg sync.WaitGroup
+
+		errs := make([]error, 3)
+
+		for i := range errs {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				errs[i] = c.Work(ctx, "unix", socket, fs.NewOsFileSystem(), workerConf, dist.Codec, sum, add)
+			}()
+		}
+
+		got := <-done
+		wg.Wait()
+
+		require.NoError(t, got.err)
+		require.EqualValues(t, 15, got.result.Sum)
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	})
+
+	t.Run("processes", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		done := coordinate(listener, dist)
+
+		workers := []*exec.Cmd{
+			spawn(t, listener.Addr().String(), false),
+			spawn(t, listener.Addr().String(), false),
+		}
+
+		got := <-done
+		require.NoError(t, got.err)
+		require.EqualValues(t, 15, got.result.Sum)
+
+		for _, worker := range workers {
+			require.NoError(t, worker.Wait())
+		}
+	})
+
+	t.Run("worker death", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		// the dying worker takes the first task and exits while crawling it
+		done := coordinate(listener, dist)
+
+		dying := spawn(t, listener.Addr().String(), true)
+
+		var exit *exec.ExitError
+		require.ErrorAs(t, dying.Wait(), &exit)
+		require.Equal(t, 3, exit.ExitCode())
+
+		worker := spawn(t, listener.Addr().String(), false)
+
+		got := <-done
+		require.NoError(t, got.err)
+		require.EqualValues(t, 15, got.result.Sum)
+		require.NoError(t, worker.Wait())
+	})
+
+	t.Run("hung worker", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		dist := dist
+		dist.LeaseTimeout = 50 * time.Millisecond
+
+		done := coordinate(listener, dist)
+
+		// the hung worker stays connected, but neither completes its task nor renews it
+		hung, err := rpc.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+
+		defer hung.Close()
+
+		var task Task
+		require.NoError(t, hung.Call("Coordinator.Next", struct{}{}, &task))
+		require.False(t, task.Done)
+
+		// the working worker outlives several leases with its heartbeats
+		slow := func(current TestType, accum TestAccumulator) TestAccumulator {
+			time.Sleep(40 * time.Millisecond)
+			return sum(current, accum)
+		}
+
+		require.NoError(t, c.Work(ctx, "tcp", listener.Addr().String(), fs.NewOsFileSystem(), workerConf, dist.Codec, slow, add))
+
+		got := <-done
+		require.NoError(t, got.err)
+		require.EqualValues(t, 15, got.result.Sum)
+
+		// the connection of the hung worker is closed once its lease timeout elapses
+		require.Error(t, hung.Call("Coordinator.Next", struct{}{}, &task))
+	})
+
+	t.Run("failing task", func(t *testing.T) {
+		broken := filepath.Join(rootDir, "d", "broken.json")
+		require.NoError(t, os.WriteFile(broken, []byte(`{"data": `), 0o600))
+
+		t.Cleanup(func() {
+			require.NoError(t, os.Remove(broken))
+		})
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		done := coordinate(listener, dist)
+
+		workErr := c.Work(ctx, "tcp", listener.Addr().String(), fs.NewOsFileSystem(), workerConf, dist.Codec, sum, add)
+		require.ErrorContains(t, workErr, "broken.json")
+
+		got := <-done
+		require.ErrorContains(t, got.err, `task "d"`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		_, err = c.Coordinate(ctx, listener, fs.NewOsFileSystem(), rootDir, conf, Distribution[TestAccumulator]{}, add)
+		require.ErrorContains(t, err, "codec")
+
+		// the listener is closed
+		_, err = net.Dial("tcp", listener.Addr().String())
+		require.Error(t, err)
+
+		cancelled, cancel := context.WithCancel(ctx)
+
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		// no workers connect
+		_, err = c.Coordinate(cancelled, listener, fs.NewOsFileSystem(), rootDir, conf, dist, add)
+		require.ErrorIs(t, err, context.Canceled)
+	})
 }
 
 func TestWorkers(t *testing.T) {
@@ -493,6 +2552,41 @@ func combiner(first, second TestAccumulator) TestAccumulator {
 	return second
 }
 
+// sum accumulates the values like accum, without its delay.
+func sum(current TestType, accum TestAccumulator) TestAccumulator {
+	accum.Sum += current.Data
+	return accum
+}
+
+// add combines the accumulators like combiner, but it is thread-safe.
+func add(current, accum TestAccumulator) TestAccumulator {
+	accum.Sum += current.Sum
+	return accum
+}
+
+// writeTree writes the files, named by their slash-separated paths relative to root,
+// creating the directories.
+func writeTree(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	}
+}
+
+// numberedTree returns the files "<i % dirs>/<i>.json" holding {"data": i} for i below n.
+func numberedTree(n, dirs int) map[string]string {
+	files := make(map[string]string, n)
+
+	for i := range n {
+		files[fmt.Sprintf("%d/%d.json", i%dirs, i)] = fmt.Sprintf(`{"data": %d}`, i)
+	}
+
+	return files
+}
+
 func testCompilation[T, R any]() Crawler[T, R] {
 	return &crawlerImpl[T, R]{}
 }
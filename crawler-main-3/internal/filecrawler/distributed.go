@@ -0,0 +1,648 @@
+package crawler
+
+import (
+	"context"
+	"crawler/internal/fs"
+	"crawler/internal/pipeline"
+	"crawler/internal/workerpool"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSplitDepth is the default depth the coordinator splits the tree at.
+	DefaultSplitDepth = 1
+
+	// DefaultLeaseTimeout is the default time a task stays leased without a heartbeat.
+	DefaultLeaseTimeout = 30 * time.Second
+)
+
+// Distribution configures Coordinate.
+type Distribution[R any] struct {
+	// Codec decodes the results of the tasks sent by the workers, which must use the same codec.
+	Codec Codec[R]
+
+	// SplitDepth is the depth the tree is split into tasks at: every directory at that depth
+	// is a task crawled with all its subdirectories, and the files of every directory above
+	// it are a task of their own. DefaultSplitDepth if not set.
+	SplitDepth int
+
+	// LeaseTimeout is the time a task stays leased to a worker without a heartbeat, after
+	// which it is handed out again, so that a stopped worker process or a silently broken
+	// connection does not stall the crawl. The workers send a heartbeat every third of it.
+	// Once the crawl has ended, the workers are given as long to disconnect before their
+	// connections are closed. DefaultLeaseTimeout if not set.
+	LeaseTimeout time.Duration
+}
+
+// Task is a part of the tree crawled by a worker, sent by the coordinator.
+type Task struct {
+	ID        int
+	Root      string // root of the tree
+	Rel       string // slash-separated path of the directory relative to the root
+	Depth     int    // depth of the directory relative to the root
+	Recursive bool   // whether the subdirectories are crawled, or only the files of the directory
+	Filter    TaskFilter
+
+	// Heartbeat is the interval the worker renews the lease of the task at while crawling it.
+	Heartbeat time.Duration
+
+	// Done is set instead of a task when the crawl has ended, and the worker should stop.
+	Done bool
+}
+
+// TaskFilter holds the filtering options of the coordinator's configuration,
+// which replace the ones of the workers, so that every task is filtered alike.
+type TaskFilter struct {
+	Include     []string
+	Exclude     []string
+	MaxDepth    int
+	SkipHidden  bool
+	MinFileSize int64
+	MaxFileSize int64
+}
+
+// TaskResult is the outcome of a task sent by a worker: the encoded result or the error.
+type TaskResult struct {
+	ID     int
+	Result []byte
+	Err    string
+}
+
+func (c *crawlerImpl[T, R]) Coordinate(
+	ctx context.Context,
+	listener net.Listener,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	dist Distribution[R],
+	combiner Combiner[R],
+) (R, error) {
+	if dist.LeaseTimeout <= 0 {
+		dist.LeaseTimeout = DefaultLeaseTimeout
+	}
+
+	coord := &coordinator{
+		requests: make(chan request),
+		results:  make(chan completion),
+		renewals: make(chan renewal),
+		lost:     make(chan int),
+		failed:   make(chan error),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	coord.wg.Add(1)
+
+	go func() {
+		defer coord.wg.Done()
+
+		if err := coord.serve(listener); err != nil {
+			select {
+			case coord.failed <- err:
+			case <-coord.done:
+			}
+		}
+	}()
+
+	result, err := schedule(ctx, coord, fileSystem, root, conf, dist, combiner)
+
+	coord.stop(ctx, listener, err != nil, dist.LeaseTimeout)
+
+	return result, err
+}
+
+// coordinator hands the tasks out to the workers connected over RPC. Every connection
+// is served by its own session, and the tasks leased by a session whose connection is
+// lost, or which stopped renewing them, are handed out again. The sessions talk to
+// the scheduling goroutine over channels.
+type coordinator struct {
+	requests chan request    // a session asks for a task
+	results  chan completion // a session sends the result of a task
+	renewals chan renewal    // a session renews the lease of a task
+	lost     chan int        // the connection of the session has ended
+	failed   chan error      // the workers cannot be served
+	done     chan struct{}   // closed when the scheduling has ended
+
+	wg sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+type request struct {
+	session int
+	reply   chan<- Task
+}
+
+type completion struct {
+	result TaskResult
+}
+
+type renewal struct {
+	session int
+	task    int
+}
+
+// lease is a task handed out to a session.
+type lease struct {
+	session int
+	expires time.Time
+}
+
+// session is the RPC service of a connection, registered as "Coordinator".
+type session struct {
+	*coordinator
+	id int
+}
+
+// Next waits for a task, or for the end of the crawl, which is reported by Task.Done.
+func (s *session) Next(_ struct{}, task *Task) error {
+	reply := make(chan Task)
+
+	select {
+	case s.requests <- request{session: s.id, reply: reply}:
+	case <-s.done:
+		task.Done = true
+		return nil
+	}
+
+	select {
+	case *task = <-reply:
+	case <-s.done:
+		task.Done = true
+	}
+
+	return nil
+}
+
+// Complete delivers the result of a task.
+func (s *session) Complete(result TaskResult, _ *struct{}) error {
+	select {
+	case s.results <- completion{result: result}:
+	case <-s.done:
+	}
+
+	return nil
+}
+
+// Renew renews the lease of the task, if the session still holds it.
+func (s *session) Renew(id int, _ *struct{}) error {
+	select {
+	case s.renewals <- renewal{session: s.id, task: id}:
+	case <-s.done:
+	}
+
+	return nil
+}
+
+// serve accepts the connections of the workers until the listener is closed,
+// and returns the error which prevents serving them.
+func (c *coordinator) serve(listener net.Listener) error {
+	for id := 0; ; id++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("accept workers: %w", err)
+		}
+
+		if !c.track(conn) {
+			_ = conn.Close()
+			return nil
+		}
+
+		server := rpc.NewServer()
+		if err := server.RegisterName("Coordinator", &session{coordinator: c, id: id}); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("register the coordinator service: %w", err)
+		}
+
+		c.wg.Add(1)
+
+		go func() {
+			defer c.wg.Done()
+
+			// returns once the connection has ended and its calls have been answered
+			server.ServeConn(conn)
+
+			select {
+			case c.lost <- id:
+			case <-c.done:
+			}
+		}()
+	}
+}
+
+// schedule splits the tree into tasks, hands them out to the sessions asking for them
+// and combines their results, until all tasks are completed or one of them fails.
+func schedule[R any](
+	ctx context.Context,
+	c *coordinator,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	dist Distribution[R],
+	combiner Combiner[R],
+) (R, error) {
+	var result, zero R
+
+	if dist.Codec == nil {
+		return zero, errors.New("distribution codec is not set")
+	}
+
+	tasks, err := split(fileSystem, root, conf, dist.SplitDepth)
+	if err != nil {
+		return zero, err
+	}
+
+	heartbeat := dist.LeaseTimeout / 3
+	for i := range tasks {
+		tasks[i].Heartbeat = heartbeat
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	var (
+		pending   = tasks
+		waiting   []request
+		leases    = make(map[int]lease) // by the leased tasks
+		completed = make([]bool, len(tasks))
+		remaining = len(tasks)
+	)
+
+	for remaining > 0 {
+		var (
+			send chan<- Task
+			next Task
+		)
+
+		// a task handed out again may have been completed by its previous session meanwhile
+		for len(pending) > 0 && completed[pending[0].ID] {
+			pending = pending[1:]
+		}
+
+		if len(waiting) > 0 && len(pending) > 0 {
+			send, next = waiting[0].reply, pending[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case err := <-c.failed:
+			return zero, err
+		case req := <-c.requests:
+			waiting = append(waiting, req)
+		case send <- next:
+			leases[next.ID] = lease{session: waiting[0].session, expires: time.Now().Add(dist.LeaseTimeout)}
+			pending, waiting = pending[1:], waiting[1:]
+		case renewed := <-c.renewals:
+			if leased, ok := leases[renewed.task]; ok && leased.session == renewed.session {
+				leased.expires = time.Now().Add(dist.LeaseTimeout)
+				leases[renewed.task] = leased
+			}
+		case now := <-ticker.C:
+			for id, leased := range leases {
+				if now.After(leased.expires) {
+					delete(leases, id)
+					pending = append(pending, tasks[id])
+				}
+			}
+		case done := <-c.results:
+			id := done.result.ID
+			if id < 0 || id >= len(tasks) || completed[id] {
+				continue
+			}
+
+			if done.result.Err != "" {
+				return zero, fmt.Errorf("task %q: %s", tasks[id].Rel, done.result.Err)
+			}
+
+			partial, err := dist.Codec.Decode(done.result.Result)
+			if err != nil {
+				return zero, fmt.Errorf("decode the result of task %q: %w", tasks[id].Rel, err)
+			}
+
+			result = combiner(partial, result)
+			completed[id] = true
+			remaining--
+
+			delete(leases, id)
+		case lost := <-c.lost:
+			for id, leased := range leases {
+				if leased.session == lost {
+					delete(leases, id)
+					pending = append(pending, tasks[id])
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// track records the connection, so that it can be closed by stop,
+// and reports false if the coordinator is already stopped.
+func (c *coordinator) track(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.conns[conn] = struct{}{}
+
+	return true
+}
+
+// closeConns closes the connections of all workers.
+func (c *coordinator) closeConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+}
+
+// stop ends the scheduling, so that the workers asking for tasks are told to stop,
+// closes the listener and waits for all connections. If the crawl has failed, the
+// connections are closed at once, otherwise the workers disconnect on their own,
+// unless the context is done or the timeout elapses first.
+func (c *coordinator) stop(ctx context.Context, listener net.Listener, failed bool, timeout time.Duration) {
+	close(c.done)
+
+	_ = listener.Close()
+
+	if failed {
+		c.closeConns()
+	}
+
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		c.wg.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	c.closeConns()
+	<-finished
+}
+
+// split splits the tree into tasks, see Distribution.SplitDepth. The tree is filtered
+// by the configuration as the directories are listed. A directory that cannot be listed
+// becomes a recursive task, so that its error is handled by the error policy of the worker.
+// With FollowSymlinks, the tree is a single task, since the links may lead across the tasks.
+func split(fileSystem fs.FileSystem, root string, conf Configuration, depth int) ([]Task, error) {
+	filter, err := newFilter(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if depth <= 0 {
+		depth = DefaultSplitDepth
+	}
+
+	if conf.FollowSymlinks {
+		depth = 0
+	}
+
+	taskFilter := TaskFilter{
+		Include:     conf.Include,
+		Exclude:     conf.Exclude,
+		MaxDepth:    conf.MaxDepth,
+		SkipHidden:  conf.SkipHidden,
+		MinFileSize: conf.MinFileSize,
+		MaxFileSize: conf.MaxFileSize,
+	}
+
+	var tasks []Task
+
+	add := func(dir directory, recursive bool) {
+		tasks = append(tasks, Task{
+			ID:        len(tasks),
+			Root:      root,
+			Rel:       dir.rel,
+			Depth:     dir.depth,
+			Recursive: recursive,
+			Filter:    taskFilter,
+		})
+	}
+
+	dirs := []directory{{path: root, rel: "."}}
+
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		if dir.depth == depth {
+			add(dir, true)
+			continue
+		}
+
+		entries, err := fileSystem.ReadDir(dir.path)
+		if err != nil {
+			add(dir, true)
+			continue
+		}
+
+		files := false
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = true
+				continue
+			}
+
+			name := entry.Name()
+			child := directory{
+				path:  fileSystem.Join(dir.path, name),
+				rel:   path.Join(dir.rel, name),
+				depth: dir.depth + 1,
+			}
+
+			if filter.descend(child.rel, name, child.depth) {
+				dirs = append(dirs, child)
+			}
+		}
+
+		if files {
+			add(dir, false)
+		}
+	}
+
+	return tasks, nil
+}
+
+func (c *crawlerImpl[T, R]) Work(
+	ctx context.Context,
+	network string,
+	address string,
+	fileSystem fs.FileSystem,
+	conf Configuration,
+	codec Codec[R],
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) error {
+	if codec == nil {
+		return errors.New("distribution codec is not set")
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+
+	client := rpc.NewClient(conn)
+	defer client.Close()
+
+	// closing the client interrupts a pending call, the crawl is cancelled by the context
+	stop := context.AfterFunc(ctx, func() {
+		_ = client.Close()
+	})
+	defer stop()
+
+	for {
+		var task Task
+
+		if err := client.Call("Coordinator.Next", struct{}{}, &task); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("next task: %w", err)
+		}
+
+		if task.Done {
+			return nil
+		}
+
+		renewed := renew(client, task)
+		result, taskErr := c.collectTask(ctx, fileSystem, conf, task, accumulator, combiner)
+		renewed()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		reply := TaskResult{ID: task.ID}
+
+		if taskErr == nil {
+			reply.Result, taskErr = codec.Encode(result)
+		}
+
+		if taskErr != nil {
+			reply.Err = taskErr.Error()
+		}
+
+		if err := client.Call("Coordinator.Complete", reply, &struct{}{}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("complete task %q: %w", task.Rel, err)
+		}
+
+		// the coordinator fails the crawl, there is nothing more to do
+		if taskErr != nil {
+			return fmt.Errorf("task %q: %w", task.Rel, taskErr)
+		}
+	}
+}
+
+// renew sends the heartbeats of the task until the returned function is called.
+// A failed heartbeat is not retried, the connection is checked by the next call.
+func renew(client *rpc.Client, task Task) func() {
+	if task.Heartbeat <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(task.Heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := client.Call("Coordinator.Renew", task.ID, &struct{}{}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// collectTask crawls the directory of the task like Collect, with the filtering options
+// of the task, keeping the relative paths and depths of the whole tree.
+func (c *crawlerImpl[T, R]) collectTask(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	conf Configuration,
+	task Task,
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) (R, error) {
+	var zero R
+
+	conf.Include, conf.Exclude = task.Filter.Include, task.Filter.Exclude
+	conf.MaxDepth, conf.SkipHidden = task.Filter.MaxDepth, task.Filter.SkipHidden
+	conf.MinFileSize, conf.MaxFileSize = task.Filter.MinFileSize, task.Filter.MaxFileSize
+
+	if !task.Recursive && (conf.MaxDepth == 0 || conf.MaxDepth > task.Depth+1) {
+		conf.MaxDepth = task.Depth + 1
+	}
+
+	dirPath := task.Root
+	if task.Rel != "." {
+		dirPath = fileSystem.Join(append([]string{task.Root}, strings.Split(task.Rel, "/")...)...)
+	}
+
+	seed := directory{path: dirPath, rel: task.Rel, depth: task.Depth, canonical: dirPath}
+
+	run, err := c.startAt(ctx, fileSystem, seed, conf, accumulator, 0, nil)
+	if err != nil {
+		return zero, err
+	}
+
+	result, metrics, err := pipeline.Reduce(run.ctx, run.pipeline, combiner)
+
+	if _, err = run.finish(metrics, err); err != nil {
+		return zero, err
+	}
+
+	return result, nil
+}
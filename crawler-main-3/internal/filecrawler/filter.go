@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// filter decides which entries found by the search stage are crawled.
+// It is built once per Collect and is safe for concurrent use.
+type filter struct {
+	include    []string
+	exclude    []string
+	maxDepth   int
+	skipHidden bool
+	minSize    int64
+	maxSize    int64
+}
+
+// newFilter validates the filtering options of the configuration.
+func newFilter(conf Configuration) (*filter, error) {
+	for _, pattern := range append(append([]string(nil), conf.Include...), conf.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	if conf.MaxDepth < 0 {
+		return nil, fmt.Errorf("negative max depth %d", conf.MaxDepth)
+	}
+
+	if conf.MinFileSize < 0 || conf.MaxFileSize < 0 {
+		return nil, fmt.Errorf("negative file size limit")
+	}
+
+	if conf.MaxFileSize > 0 && conf.MinFileSize > conf.MaxFileSize {
+		return nil, fmt.Errorf("min file size %d exceeds max file size %d", conf.MinFileSize, conf.MaxFileSize)
+	}
+
+	return &filter{
+		include:    conf.Include,
+		exclude:    conf.Exclude,
+		maxDepth:   conf.MaxDepth,
+		skipHidden: conf.SkipHidden,
+		minSize:    conf.MinFileSize,
+		maxSize:    conf.MaxFileSize,
+	}, nil
+}
+
+// descend reports whether the directory at the given depth should be read.
+// The rel is the slash-separated path relative to the root.
+func (f *filter) descend(rel, name string, depth int) bool {
+	if f.maxDepth > 0 && depth >= f.maxDepth {
+		return false
+	}
+
+	return !f.hidden(name) && !matchAny(f.exclude, rel, name)
+}
+
+// accept reports whether the file at the given depth should be processed.
+// The entry is inspected for its size only if a size limit is set.
+func (f *filter) accept(rel, name string, depth int, entry os.DirEntry) (bool, error) {
+	if f.maxDepth > 0 && depth > f.maxDepth {
+		return false, nil
+	}
+
+	if f.hidden(name) || matchAny(f.exclude, rel, name) {
+		return false, nil
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, rel, name) {
+		return false, nil
+	}
+
+	if f.minSize == 0 && f.maxSize == 0 {
+		return true, nil
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return false, err
+	}
+
+	return info.Size() >= f.minSize && (f.maxSize == 0 || info.Size() <= f.maxSize), nil
+}
+
+func (f *filter) hidden(name string) bool {
+	return f.skipHidden && strings.HasPrefix(name, ".")
+}
+
+// matchAny matches the patterns containing a slash against the relative path
+// and the other ones against the name.
+func matchAny(patterns []string, rel, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+
+		// patterns are validated by newFilter
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,302 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crawler/internal/decoder"
+	"crawler/internal/fs"
+	"crawler/internal/pipeline"
+	"crawler/internal/workerpool"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+// Incremental configures CollectIncremental.
+type Incremental[R any] struct {
+	// Manifest is the path of the manifest file in the OS file system. The file is created
+	// by the first run and replaced atomically by every following one.
+	Manifest string
+
+	// Codec encodes the results of single files stored in the manifest.
+	Codec Codec[R]
+
+	// Version identifies the accumulator and the decoders, which cannot be compared across
+	// runs. A manifest saved with another version, or with decoders registered for other
+	// extensions, is discarded and every file is processed again, so Version must be changed
+	// whenever the accumulator or a decoder changes the results of the files.
+	Version string
+}
+
+// Codec encodes and decodes the results of single files stored in the manifest.
+// Codec must be thread-safe, as it is called concurrently by multiple workers.
+type Codec[R any] interface {
+	Encode(value R) ([]byte, error)
+	Decode(data []byte) (R, error)
+}
+
+// JSONCodec returns the codec storing the results as JSON.
+func JSONCodec[R any]() Codec[R] {
+	return jsonCodec[R]{}
+}
+
+// GobCodec returns the codec storing the results as gob.
+func GobCodec[R any]() Codec[R] {
+	return gobCodec[R]{}
+}
+
+type jsonCodec[R any] struct{}
+
+func (jsonCodec[R]) Encode(value R) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[R]) Decode(data []byte) (R, error) {
+	var value R
+	err := json.Unmarshal(data, &value)
+
+	return value, err
+}
+
+type gobCodec[R any] struct{}
+
+func (gobCodec[R]) Encode(value R) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(value)
+
+	return buf.Bytes(), err
+}
+
+func (gobCodec[R]) Decode(data []byte) (R, error) {
+	var value R
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+
+	return value, err
+}
+
+func (c *crawlerImpl[T, R]) CollectIncremental(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	incremental Incremental[R],
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) (R, error) {
+	var result R
+
+	m, err := loadManifest(incremental, conf)
+	if err != nil {
+		return result, err
+	}
+
+	run, err := c.start(ctx, fileSystem, root, conf, accumulator, 1, m)
+	if err != nil {
+		return result, err
+	}
+
+	result, metrics, err := pipeline.Reduce(run.ctx, run.pipeline, combiner)
+	_, err = run.finish(metrics, err)
+
+	if saveErr := m.save(err == nil); saveErr != nil {
+		err = errors.Join(err, fmt.Errorf("save manifest: %w", saveErr))
+	}
+
+	if err != nil {
+		var zero R
+		return zero, err
+	}
+
+	return result, nil
+}
+
+// manifestEntry describes a processed file and holds its encoded result.
+type manifestEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+	Result  []byte    `json:"result"`
+}
+
+// manifestFile is the persisted form of the manifest.
+type manifestFile struct {
+	Version     int                      `json:"version"`
+	Fingerprint string                   `json:"fingerprint"`
+	Entries     map[string]manifestEntry `json:"entries"`
+}
+
+// manifest holds the entries of the previous run, which are only read during the crawl,
+// and collects the entries of the files processed by the current one.
+type manifest[R any] struct {
+	path        string
+	codec       Codec[R]
+	fingerprint string
+	previous    map[string]manifestEntry
+
+	mu      sync.Mutex
+	current map[string]manifestEntry
+}
+
+// loadManifest reads the manifest of the previous run, a missing file means there was none.
+// The entries of a manifest saved with another fingerprint are discarded.
+func loadManifest[R any](incremental Incremental[R], conf Configuration) (*manifest[R], error) {
+	if incremental.Manifest == "" {
+		return nil, errors.New("manifest path is not set")
+	}
+
+	if incremental.Codec == nil {
+		return nil, errors.New("manifest codec is not set")
+	}
+
+	m := &manifest[R]{
+		path:        incremental.Manifest,
+		codec:       incremental.Codec,
+		fingerprint: fingerprint(incremental.Version, conf),
+		previous:    make(map[string]manifestEntry),
+		current:     make(map[string]manifestEntry),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var file manifestFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode manifest %q: %w", m.path, err)
+	}
+
+	if file.Version != manifestVersion {
+		return nil, fmt.Errorf("manifest %q: unsupported version %d", m.path, file.Version)
+	}
+
+	if file.Entries != nil && file.Fingerprint == m.fingerprint {
+		m.previous = file.Entries
+	}
+
+	return m, nil
+}
+
+// unchanged returns the previous entry of the file if its size and modification time match.
+func (m *manifest[R]) unchanged(path string, info os.FileInfo) (manifestEntry, bool) {
+	entry, ok := m.previous[path]
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return manifestEntry{}, false
+	}
+
+	return entry, true
+}
+
+// cached returns the previous entry of the file if its content hash matches.
+func (m *manifest[R]) cached(path, hash string) (manifestEntry, bool) {
+	entry, ok := m.previous[path]
+	if !ok || entry.Hash != hash {
+		return manifestEntry{}, false
+	}
+
+	return entry, true
+}
+
+// store records the processed file.
+func (m *manifest[R]) store(path string, entry manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.current[path] = entry
+}
+
+// save atomically replaces the manifest file. The entries of the previous run missing
+// from the current one are dropped only if the crawl is complete, so an interrupted
+// crawl can be resumed.
+func (m *manifest[R]) save(complete bool) error {
+	file := manifestFile{Version: manifestVersion, Fingerprint: m.fingerprint, Entries: m.entries(complete)}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// entries returns the entries of the current run, and the ones of the previous run
+// missing from it if the run is incomplete.
+func (m *manifest[R]) entries(complete bool) map[string]manifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if complete {
+		return m.current
+	}
+
+	entries := make(map[string]manifestEntry, len(m.previous)+len(m.current))
+
+	for path, entry := range m.previous {
+		entries[path] = entry
+	}
+
+	for path, entry := range m.current {
+		entries[path] = entry
+	}
+
+	return entries
+}
+
+// fingerprint identifies what the results of the files depend on: the version of the caller
+// and the extensions with registered decoders. The functions themselves cannot be identified
+// across runs. StreamValues is ignored by incremental crawls, so it is left out.
+func fingerprint(version string, conf Configuration) string {
+	extensions := slices.Sorted(maps.Keys(decoder.Default().Merge(conf.Decoders)))
+
+	data, _ := json.Marshal(struct {
+		Version    string   `json:"version"`
+		Extensions []string `json:"extensions"`
+		Fallback   bool     `json:"fallback"`
+	}{
+		Version:    version,
+		Extensions: extensions,
+		Fallback:   conf.DefaultDecoder != nil,
+	})
+
+	return contentHash(data)
+}
+
+// contentHash returns the hex-encoded SHA-256 of the file contents.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,115 @@
+package crawler
+
+import (
+	"context"
+	"crawler/internal/fs"
+	"crawler/internal/pipeline"
+	"crawler/internal/workerpool"
+	"maps"
+)
+
+// keyedValue is a decoded value with its key and the path of its file.
+type keyedValue[T any, K comparable] struct {
+	key   K
+	value T
+	path  string
+}
+
+// CollectByKey performs the same crawling operation as Collect for a group-by: every value
+// is reduced into the result of its key, returned as a map. The values are partitioned among
+// the accumulator workers by the hashes of their keys, so every key is reduced by exactly one
+// worker starting from the zero R, and no combiner is needed, as the workers hold disjoint keys.
+// Unlike a map carried in a single R, the accumulators never merge whole maps.
+//
+// The key function is called by many workers at once, so it must be thread-safe. Its panics,
+// like the ones of the accumulator, are reported under StageAccumulate and drop the value.
+// StreamValues is ignored.
+func CollectByKey[T any, K comparable, R any](
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	key func(T) K,
+	accumulator workerpool.Accumulator[T, R],
+) (map[K]R, error) {
+	c := New[T, map[K]R]()
+
+	// the values of a file are keyed and routed to the workers at once
+	conf.StreamValues = false
+
+	run, decoded, err := c.prepare(ctx, fileSystem, directory{path: root, rel: ".", canonical: root}, conf, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// keyOf returns the key of the value, or false if key panics
+	keyOf := func(path string, value T) (k K, ok bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				run.errs.addPanic(path, StageAccumulate, r)
+				ok = false
+			}
+		}()
+
+		return key(value), true
+	}
+
+	keys := func(file decodedFile[T, map[K]R]) []keyedValue[T, K] {
+		keyed := make([]keyedValue[T, K], 0, len(file.values))
+
+		for _, value := range file.values {
+			if k, ok := keyOf(file.path, value); ok {
+				keyed = append(keyed, keyedValue[T, K]{key: k, value: value, path: file.path})
+			}
+		}
+
+		return keyed
+	}
+
+	// every key is accumulated by one of a fixed number of workers, so only the keys
+	// are extracted by the scaled workers
+	workers := conf.AccumulatorWorkers
+	values := pipeline.Then(decoded, "key", workers, keys)
+
+	if conf.AccumulatorScaling != nil {
+		workers = conf.AccumulatorScaling.Max
+		values = pipeline.ThenScaled(decoded, "key", *conf.AccumulatorScaling, keys)
+	}
+
+	run.pipeline = pipeline.AccumulateByKey(values, "accumulate", workers,
+		func(current keyedValue[T, K]) K {
+			return current.key
+		},
+		func(current keyedValue[T, K], accum R) (result R) {
+			result = accum
+
+			defer func() {
+				if r := recover(); r != nil {
+					run.errs.addPanic(current.path, StageAccumulate, r)
+				}
+			}()
+
+			return accumulator(current.value, accum)
+		},
+	)
+
+	result, metrics, err := pipeline.Reduce(run.ctx, run.pipeline, func(partial, accum map[K]R) map[K]R {
+		if accum == nil {
+			return partial
+		}
+
+		maps.Copy(accum, partial)
+
+		return accum
+	})
+
+	if _, err = run.finish(metrics, err); err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		result = make(map[K]R)
+	}
+
+	return result, nil
+}
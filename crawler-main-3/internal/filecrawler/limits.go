@@ -0,0 +1,220 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// limits enforces the open file and throughput limits of the configuration
+// for the search and file stages. A nil value imposes no limits.
+type limits struct {
+	openFiles *semaphore
+	files     *rateLimiter
+	bytes     *rateLimiter
+}
+
+// newLimits returns nil if the configuration sets no limits.
+func newLimits(conf Configuration) (*limits, error) {
+	if conf.MaxOpenFiles < 0 || conf.MaxFilesPerSecond < 0 || conf.MaxBytesPerSecond < 0 {
+		return nil, fmt.Errorf("negative open file or throughput limit")
+	}
+
+	if conf.MaxOpenFiles == 0 && conf.MaxFilesPerSecond == 0 && conf.MaxBytesPerSecond == 0 {
+		return nil, nil
+	}
+
+	return &limits{
+		openFiles: newSemaphore(conf.MaxOpenFiles),
+		files:     newRateLimiter(float64(conf.MaxFilesPerSecond)),
+		bytes:     newRateLimiter(float64(conf.MaxBytesPerSecond)),
+	}, nil
+}
+
+// open waits until a file or a directory may be opened, and returns the function
+// to call once it is closed. It fails only if the context is cancelled.
+func (l *limits) open(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if err := l.files.wait(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	if err := l.openFiles.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	return l.openFiles.release, nil
+}
+
+// reader limits the rate the reader is read at.
+func (l *limits) reader(ctx context.Context, reader io.Reader) io.Reader {
+	if l == nil || l.bytes == nil {
+		return reader
+	}
+
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiter: l.bytes}
+}
+
+// semaphore limits the number of concurrent holders, granting the waiters in order.
+// A nil semaphore has no limit.
+type semaphore struct {
+	size int
+
+	mu      sync.Mutex
+	held    int
+	waiters []chan struct{}
+}
+
+// newSemaphore returns nil if size is zero.
+func newSemaphore(size int) *semaphore {
+	if size == 0 {
+		return nil
+	}
+
+	return &semaphore{size: size}
+}
+
+// acquire waits until the semaphore is acquired or the context is cancelled.
+func (s *semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+
+	if s.held < s.size && len(s.waiters) == 0 {
+		s.held++
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	// release hands the semaphore over by closing the channel
+	ready := make(chan struct{})
+	s.waiters = append(s.waiters, ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-ready:
+		// acquired while being cancelled, pass it on
+		s.handOver()
+	default:
+		for i, waiter := range s.waiters {
+			if waiter == ready {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// release releases the semaphore acquired by acquire.
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handOver()
+}
+
+// handOver passes a held semaphore to the first waiter, or releases it.
+func (s *semaphore) handOver() {
+	if len(s.waiters) == 0 {
+		s.held--
+		return
+	}
+
+	close(s.waiters[0])
+	s.waiters = s.waiters[1:]
+}
+
+// rateLimiter spaces the events so that their rate does not exceed the limit.
+// Idle time is not saved up, so there are no bursts. A nil limiter has no limit.
+type rateLimiter struct {
+	interval float64 // seconds per unit
+
+	mu   sync.Mutex
+	next time.Time // when the events reserved so far are spent
+}
+
+// newRateLimiter returns nil if perSecond is zero.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond == 0 {
+		return nil
+	}
+
+	return &rateLimiter{interval: 1 / perSecond}
+}
+
+// wait reserves n units and waits until they may be used or the context is cancelled.
+func (r *rateLimiter) wait(ctx context.Context, n int) error {
+	if r == nil {
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+
+	now := time.Now()
+	start := r.next
+
+	if start.Before(now) {
+		start = now
+	}
+
+	r.next = start.Add(time.Duration(float64(n) * r.interval * float64(time.Second)))
+	r.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedReader pays for the read bytes before returning them.
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+		return 0, waitErr
+	}
+
+	return n, err
+}
@@ -0,0 +1,191 @@
+//go:build linux
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// notifyMask selects the events signalling a change of the watched files.
+const notifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyEventSize is the size of syscall.InotifyEvent without the name.
+const inotifyEventSize = syscall.SizeofInotifyEvent
+
+// notifier collects the paths changed under a directory using inotify.
+// Every directory is watched separately, including the ones created later.
+type notifier struct {
+	file    *os.File
+	fd      int
+	root    string
+	dirs    map[int32]string // the watched directories by their watch descriptors
+	signals chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+
+	// the changes not taken yet, collected while the watcher is busy
+	mu       sync.Mutex
+	changed  map[string]struct{}
+	overflow bool
+}
+
+func newNotifier(root string) (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	n := &notifier{
+		// a non-blocking descriptor is read through the runtime poller, so closing it
+		// interrupts the read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		root:    root,
+		dirs:    make(map[int32]string),
+		changed: make(map[string]struct{}),
+		signals: make(chan struct{}),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err = n.watch(root); err != nil {
+		_ = n.file.Close()
+		return nil, err
+	}
+
+	go n.run()
+
+	return n, nil
+}
+
+// changes returns the channel receiving a signal after a batch of events, if it is received
+// at that moment: the changes are collected anyway, see pending.
+func (n *notifier) changes() <-chan struct{} {
+	return n.signals
+}
+
+// take returns the changed paths since the previous call, and whether some events were lost,
+// so the changes are unknown.
+func (n *notifier) take() (changed []string, overflow bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for path := range n.changed {
+		changed = append(changed, path)
+	}
+
+	overflow = n.overflow
+	n.changed, n.overflow = make(map[string]struct{}), false
+
+	return changed, overflow
+}
+
+// pending reports whether there are changes to take.
+func (n *notifier) pending() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.changed) > 0 || n.overflow
+}
+
+// close stops the notifier and waits for it.
+func (n *notifier) close() {
+	close(n.closing)
+	_ = n.file.Close()
+	<-n.done
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+
+	buf := make([]byte, 64*(inotifyEventSize+syscall.NAME_MAX+1))
+
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		n.handle(buf[:count])
+
+		// the reader never waits for the watcher, which takes the changes once it is free
+		select {
+		case <-n.closing:
+			return
+		case n.signals <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handle records the changed paths, watches the created directories and forgets
+// the removed ones.
+func (n *notifier) handle(events []byte) {
+	for len(events) >= inotifyEventSize {
+		wd := int32(binary.NativeEndian.Uint32(events[0:4]))
+		mask := binary.NativeEndian.Uint32(events[4:8])
+		size := int(binary.NativeEndian.Uint32(events[12:16]))
+
+		name := string(bytes.TrimRight(events[inotifyEventSize:inotifyEventSize+size], "\x00"))
+		events = events[inotifyEventSize+size:]
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			// the events of the created directories may be lost too, so they are watched again
+			_ = n.watch(n.root)
+
+			n.mu.Lock()
+			n.overflow = true
+			n.mu.Unlock()
+
+			continue
+		}
+
+		dir, ok := n.dirs[wd]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case mask&syscall.IN_IGNORED != 0:
+			delete(n.dirs, wd)
+			continue
+		case mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			// the directory may be gone already, which is notified anyway
+			_ = n.watch(filepath.Join(dir, name))
+		}
+
+		n.mu.Lock()
+		n.changed[filepath.Join(dir, name)] = struct{}{}
+		n.mu.Unlock()
+	}
+}
+
+// watch watches the directory and all its subdirectories. Only the error of the directory
+// itself is returned, the subdirectories that cannot be watched are skipped.
+func (n *notifier) watch(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, notifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+
+	n.dirs[int32(wd)] = dir
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			_ = n.watch(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package crawler
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// inotifyEvent encodes the event the way the kernel reports it.
+func inotifyEvent(wd int32, mask uint32, name string) []byte {
+	size := 0
+	if name != "" {
+		size = len(name) + 1
+	}
+
+	event := make([]byte, inotifyEventSize+size)
+	binary.NativeEndian.PutUint32(event[0:4], uint32(wd))
+	binary.NativeEndian.PutUint32(event[4:8], mask)
+	binary.NativeEndian.PutUint32(event[12:16], uint32(size))
+	copy(event[inotifyEventSize:], name)
+
+	return event
+}
+
+func TestNotifierHandle(t *testing.T) {
+	root := t.TempDir()
+
+	n, err := newNotifier(root)
+	require.NoError(t, err)
+
+	defer n.close()
+
+	var wd int32
+	for descriptor := range n.dirs {
+		wd = descriptor
+	}
+
+	// handled while the reader waits for the kernel, which reports nothing in the empty root
+	n.handle(append(inotifyEvent(wd, syscall.IN_CLOSE_WRITE, "a.json"), inotifyEvent(wd, syscall.IN_DELETE, "b.json")...))
+	require.True(t, n.pending())
+
+	changed, overflow := n.take()
+	require.ElementsMatch(t, []string{filepath.Join(root, "a.json"), filepath.Join(root, "b.json")}, changed)
+	require.False(t, overflow)
+	require.False(t, n.pending())
+
+	// the lost events leave the changes unknown
+	n.handle(inotifyEvent(-1, syscall.IN_Q_OVERFLOW, ""))
+	require.True(t, n.pending())
+
+	changed, overflow = n.take()
+	require.Empty(t, changed)
+	require.True(t, overflow)
+	require.Equal(t, map[int32]string{wd: root}, n.dirs)
+}
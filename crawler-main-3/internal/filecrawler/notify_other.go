@@ -0,0 +1,26 @@
+//go:build !linux
+
+package crawler
+
+import "errors"
+
+// notifier is not supported, so the changes are polled.
+type notifier struct{}
+
+func newNotifier(string) (*notifier, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (n *notifier) changes() <-chan struct{} {
+	return nil
+}
+
+func (n *notifier) take() ([]string, bool) {
+	return nil, false
+}
+
+func (n *notifier) pending() bool {
+	return false
+}
+
+func (n *notifier) close() {}
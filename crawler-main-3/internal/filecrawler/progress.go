@@ -0,0 +1,150 @@
+package crawler
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval is used when Configuration.ProgressInterval is not set.
+const DefaultProgressInterval = time.Second
+
+// Progress is a snapshot of the counters of a running crawl.
+type Progress struct {
+	DirectoriesListed int64         // directories successfully read
+	FilesDiscovered   int64         // files accepted by the search stage
+	FilesDecoded      int64         // files decoded without errors
+	FilesCached       int64         // files whose results were reused from the manifest
+	BytesRead         int64         // bytes read from the files
+	BytesCompressed   int64         // bytes read from compressed files, included in BytesRead
+	BytesDecompressed int64         // bytes decompressed from the compressed files
+	Errors            int64         // failures, see Report
+	Retries           int64         // operations retried by the file system
+	Elapsed           time.Duration // time since the start of the crawl
+
+	// Done is set for the final event, delivered before Collect returns.
+	Done bool
+}
+
+// progressTracker counts the crawl events and periodically reports them
+// from its own goroutine, so a slow callback never blocks the workers.
+// A nil tracker ignores all events.
+type progressTracker struct {
+	callback func(Progress)
+	interval time.Duration
+	start    time.Time
+	stats    *crawlStats
+
+	mu       sync.Mutex
+	counters Progress
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newProgressTracker returns nil if the configuration has no progress callback.
+// The retries and the compressed bytes are read from the stats.
+func newProgressTracker(conf Configuration, stats *crawlStats) *progressTracker {
+	if conf.OnProgress == nil {
+		return nil
+	}
+
+	interval := conf.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+
+	return &progressTracker{
+		callback: conf.OnProgress,
+		interval: interval,
+		start:    time.Now(),
+		stats:    stats,
+		stop:     make(chan struct{}),
+	}
+}
+
+// run starts emitting periodic events until finish is called.
+func (p *progressTracker) run() {
+	if p == nil {
+		return
+	}
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.callback(p.snapshot(false))
+			}
+		}
+	}()
+}
+
+// finish stops the periodic events and delivers the final one.
+func (p *progressTracker) finish() {
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	p.wg.Wait()
+
+	p.callback(p.snapshot(true))
+}
+
+func (p *progressTracker) snapshot(done bool) Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := p.stats.snapshot()
+
+	progress := p.counters
+	progress.Retries = counts.retries
+	progress.BytesCompressed = counts.compressed
+	progress.BytesDecompressed = counts.decompressed
+	progress.Elapsed = time.Since(p.start)
+	progress.Done = done
+
+	return progress
+}
+
+func (p *progressTracker) update(apply func(*Progress)) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	apply(&p.counters)
+	p.mu.Unlock()
+}
+
+func (p *progressTracker) directoryListed() {
+	p.update(func(c *Progress) { c.DirectoriesListed++ })
+}
+
+func (p *progressTracker) fileDiscovered() {
+	p.update(func(c *Progress) { c.FilesDiscovered++ })
+}
+
+func (p *progressTracker) fileDecoded() {
+	p.update(func(c *Progress) { c.FilesDecoded++ })
+}
+
+func (p *progressTracker) fileCached() {
+	p.update(func(c *Progress) { c.FilesCached++ })
+}
+
+func (p *progressTracker) bytesRead(n int) {
+	p.update(func(c *Progress) { c.BytesRead += int64(n) })
+}
+
+func (p *progressTracker) failed() {
+	p.update(func(c *Progress) { c.Errors++ })
+}
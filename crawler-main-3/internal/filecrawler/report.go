@@ -0,0 +1,252 @@
+package crawler
+
+import (
+	"context"
+	"crawler/internal/pipeline"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrTooManyErrors is returned when the number of failed files exceeds
+// Configuration.MaxErrors under the ErrorThreshold policy.
+var ErrTooManyErrors = errors.New("too many errors")
+
+// ErrorPolicy defines how the crawler reacts to files that cannot be processed.
+type ErrorPolicy int
+
+const (
+	// FailFast cancels the crawl on the first error and returns it.
+	FailFast ErrorPolicy = iota
+
+	// SkipAndReport skips failing files and directories, the crawl never fails because of them.
+	// The errors are available through CollectWithReport.
+	SkipAndReport
+
+	// ErrorThreshold skips failing files and directories until more than
+	// Configuration.MaxErrors errors occur, then cancels the crawl with ErrTooManyErrors.
+	ErrorThreshold
+)
+
+// Stage is the stage of the crawl a path failed in.
+type Stage string
+
+const (
+	StageList       Stage = "list"       // reading a directory
+	StageOpen       Stage = "open"       // opening a file
+	StageRead       Stage = "read"       // reading the file contents
+	StageDecode     Stage = "decode"     // decoding the file contents
+	StageAccumulate Stage = "accumulate" // accumulating the decoded values
+	StageManifest   Stage = "manifest"   // storing the result in the manifest
+)
+
+// FileError describes a path that failed during the crawl.
+type FileError struct {
+	Path  string
+	Stage Stage
+	Err   error
+
+	// Panic is true if Err was recovered from a panic, e.g. of fs.FileSystem.
+	Panic bool
+}
+
+func (e *FileError) Error() string {
+	if e.Panic {
+		return fmt.Sprintf("%s %q: panic: %v", e.Stage, e.Path, e.Err)
+	}
+
+	return fmt.Sprintf("%s %q: %v", e.Stage, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Report is a structured report of a crawl.
+type Report struct {
+	// Errors lists the failed paths in the order the failures were observed.
+	Errors []FileError
+
+	// Retries is the number of operations retried by the file system, see fs.ContextFileSystem.
+	Retries int64
+
+	// BytesCompressed is the number of bytes read from compressed files, and BytesDecompressed
+	// the number of bytes decompressed from them, counted like the ones of Progress.
+	BytesCompressed   int64
+	BytesDecompressed int64
+
+	// Stages holds the metrics of the search, decode and accumulate stages of the crawl.
+	Stages []pipeline.StageMetrics
+}
+
+// errorCollector records the errors of the crawl and cancels it according to the policy.
+type errorCollector struct {
+	policy    ErrorPolicy
+	maxErrors int
+	cancel    context.CancelFunc
+	progress  *progressTracker
+
+	mu     sync.Mutex
+	errs   []FileError
+	failed error
+}
+
+func newErrorCollector(conf Configuration, cancel context.CancelFunc, progress *progressTracker) *errorCollector {
+	return &errorCollector{
+		policy:    conf.ErrorPolicy,
+		maxErrors: conf.MaxErrors,
+		cancel:    cancel,
+		progress:  progress,
+	}
+}
+
+// add records the failure of the path.
+func (e *errorCollector) add(path string, stage Stage, err error) {
+	e.record(FileError{Path: path, Stage: stage, Err: err})
+}
+
+// addPanic records the failure of the path caused by the recovered value.
+func (e *errorCollector) addPanic(path string, stage Stage, recovered any) {
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
+	}
+
+	e.record(FileError{Path: path, Stage: stage, Err: err, Panic: true})
+}
+
+func (e *errorCollector) record(fileErr FileError) {
+	e.progress.failed()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.errs = append(e.errs, fileErr)
+
+	if e.failed != nil {
+		return
+	}
+
+	switch e.policy {
+	case FailFast:
+		e.failed = &fileErr
+	case ErrorThreshold:
+		if len(e.errs) > e.maxErrors {
+			e.failed = fmt.Errorf("%w: %d errors, last: %w", ErrTooManyErrors, len(e.errs), &fileErr)
+		}
+	case SkipAndReport:
+	}
+
+	if e.failed != nil {
+		e.cancel()
+	}
+}
+
+// err returns the error that failed the crawl, if any.
+func (e *errorCollector) err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.failed
+}
+
+// report returns the report of the errors recorded so far.
+func (e *errorCollector) report() Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Report{Errors: append([]FileError(nil), e.errs...)}
+}
+
+// crawlStats counts the retries and the compressed bytes of the crawl,
+// which are read by both the Report and the Progress events.
+type crawlStats struct {
+	mu     sync.Mutex
+	counts statCounts
+}
+
+// statCounts are the counters of crawlStats.
+type statCounts struct {
+	retries      int64
+	compressed   int64
+	decompressed int64
+}
+
+// retried records an operation retried by the file system.
+func (s *crawlStats) retried() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts.retries++
+}
+
+// compressedRead records the bytes read from a compressed file.
+func (s *crawlStats) compressedRead(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts.compressed += n
+}
+
+// bytesDecompressed records the bytes decompressed from a compressed file.
+func (s *crawlStats) bytesDecompressed(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts.decompressed += int64(n)
+}
+
+// snapshot returns the counters recorded so far.
+func (s *crawlStats) snapshot() statCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counts
+}
+
+// readTracker remembers the first error of the underlying reader,
+// so that read failures can be told apart from decoding ones, and counts the read bytes.
+type readTracker struct {
+	reader     io.Reader
+	err        error
+	progress   *progressTracker
+	stats      *crawlStats
+	read       int64
+	compressed bool
+}
+
+func (r *readTracker) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.progress.bytesRead(n)
+
+	if r.compressed {
+		r.stats.compressedRead(int64(n))
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && r.err == nil {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// markCompressed counts the bytes read so far and the following ones as compressed.
+func (r *readTracker) markCompressed() {
+	r.compressed = true
+	r.stats.compressedRead(r.read)
+}
+
+// decompressedReader counts the bytes decompressed from a compressed file.
+type decompressedReader struct {
+	reader io.Reader
+	stats  *crawlStats
+}
+
+func (r *decompressedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.stats.bytesDecompressed(n)
+
+	return n, err
+}
@@ -0,0 +1,389 @@
+package crawler
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"context"
+	"crawler/internal/fs"
+	"crawler/internal/pipeline"
+	"crawler/internal/workerpool"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+)
+
+// DefaultSpillBytes is the default size of the entries held in memory by CollectSpilled.
+const DefaultSpillBytes = 64 << 20
+
+// Spill configures CollectSpilled.
+type Spill struct {
+	// MaxBytes is the size of the entries held in memory before they are spilled to a run file.
+	// The size of an entry is the size of its gob encoding, as written to the run file, so the
+	// memory held is proportional to it whatever the sizes of the values. DefaultSpillBytes
+	// if not set.
+	MaxBytes int64
+
+	// Dir is the directory of the run files in the OS file system, os.TempDir() if empty.
+	Dir string
+}
+
+// Entry is a key and its combined value.
+type Entry[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// CollectSpilled performs the same crawling operation as Collect for keyed results, whose
+// values are combined by key, without holding all keys in memory. Every file is accumulated
+// separately into a map, and the maps are merged into the one held in memory, combining the
+// values of equal keys. Once the encoded size of the merged entries reaches Spill.MaxBytes,
+// they are written to a run file sorted by key, and the map is emptied. At the end, the run
+// files are merged, and the entries are yielded sorted by key, reading a single entry of every
+// run at a time. If nothing was spilled, the entries are yielded from memory.
+//
+// The keys and values must be encodable by encoding/gob. The run files are removed when the
+// iteration ends. If the crawl or the merge fails, the last pair holds the error.
+func CollectSpilled[T any, K cmp.Ordered, V any](
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	spill Spill,
+	accumulator workerpool.Accumulator[T, map[K]V],
+	combiner Combiner[V],
+) iter.Seq2[Entry[K, V], error] {
+	return func(yield func(Entry[K, V], error) bool) {
+		if spill.MaxBytes <= 0 {
+			spill.MaxBytes = DefaultSpillBytes
+		}
+
+		s := newSpiller[K, V](spill, combiner)
+		defer s.remove()
+
+		run, err := New[T, map[K]V]().start(ctx, fileSystem, root, conf, accumulator, 1, nil)
+		if err != nil {
+			yield(Entry[K, V]{}, err)
+			return
+		}
+
+		partials, stop := pipeline.Run(run.ctx, run.pipeline)
+
+		var spillErr error
+
+		for partial := range partials {
+			if spillErr != nil {
+				continue
+			}
+
+			if spillErr = s.add(partial); spillErr != nil {
+				run.cancel()
+			}
+		}
+
+		// the crawl is cancelled by a failed spill
+		if _, err = run.finish(stop()); spillErr != nil {
+			err = spillErr
+		}
+
+		if err != nil {
+			yield(Entry[K, V]{}, err)
+			return
+		}
+
+		if len(s.runs) == 0 {
+			for _, key := range slices.Sorted(maps.Keys(s.memory)) {
+				if !yield(Entry[K, V]{Key: key, Value: s.memory[key]}, nil) {
+					return
+				}
+			}
+
+			return
+		}
+
+		// the rest is spilled too, so that all entries are merged alike
+		if len(s.memory) > 0 {
+			if err := s.flush(); err != nil {
+				yield(Entry[K, V]{}, err)
+				return
+			}
+		}
+
+		for entry, err := range s.merge(ctx) {
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// spiller merges the keyed results in memory and spills them to the run files.
+// It is used by a single goroutine.
+type spiller[K cmp.Ordered, V any] struct {
+	spill    Spill
+	combiner Combiner[V]
+
+	memory map[K]V
+	runs   []string
+
+	// the encoded size of every entry held in memory, and their total
+	sizes map[K]int64
+	bytes int64
+
+	// the encoder of the sizes, which writes the type of the entries once
+	sizer   *byteCounter
+	encoder *gob.Encoder
+}
+
+func newSpiller[K cmp.Ordered, V any](spill Spill, combiner Combiner[V]) *spiller[K, V] {
+	sizer := &byteCounter{}
+
+	return &spiller[K, V]{
+		spill:    spill,
+		combiner: combiner,
+		memory:   make(map[K]V),
+		sizes:    make(map[K]int64),
+		sizer:    sizer,
+		encoder:  gob.NewEncoder(sizer),
+	}
+}
+
+// add merges the partial result into the memory, and spills it if it exceeds the budget.
+func (s *spiller[K, V]) add(partial map[K]V) error {
+	for key, value := range partial {
+		if accum, ok := s.memory[key]; ok {
+			value = s.combiner(value, accum)
+		}
+
+		size, err := s.size(key, value)
+		if err != nil {
+			return err
+		}
+
+		s.memory[key] = value
+		s.bytes += size - s.sizes[key]
+		s.sizes[key] = size
+	}
+
+	if s.bytes < s.spill.MaxBytes {
+		return nil
+	}
+
+	return s.flush()
+}
+
+// size returns the size of the gob encoding of the entry.
+func (s *spiller[K, V]) size(key K, value V) (int64, error) {
+	before := s.sizer.count
+
+	if err := s.encoder.Encode(Entry[K, V]{Key: key, Value: value}); err != nil {
+		return 0, fmt.Errorf("spill: %w", err)
+	}
+
+	return s.sizer.count - before, nil
+}
+
+// flush writes the entries held in memory to a new run file sorted by key and empties the memory.
+func (s *spiller[K, V]) flush() (err error) {
+	file, err := os.CreateTemp(s.spill.Dir, "crawler-run-*")
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+
+	s.runs = append(s.runs, file.Name())
+
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("spill: %w", closeErr)
+		}
+	}()
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+
+	for _, key := range slices.Sorted(maps.Keys(s.memory)) {
+		if err := encoder.Encode(Entry[K, V]{Key: key, Value: s.memory[key]}); err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+
+	clear(s.memory)
+	clear(s.sizes)
+	s.bytes = 0
+
+	return nil
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	count int64
+}
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	b.count += int64(len(p))
+	return len(p), nil
+}
+
+// remove removes the run files.
+func (s *spiller[K, V]) remove() {
+	for _, name := range s.runs {
+		_ = os.Remove(name)
+	}
+}
+
+// merge yields the entries of the run files sorted by key, combining the values of equal keys
+// in the order of the runs.
+func (s *spiller[K, V]) merge(ctx context.Context) iter.Seq2[Entry[K, V], error] {
+	return func(yield func(Entry[K, V], error) bool) {
+		runs := make(runHeap[K, V], 0, len(s.runs))
+
+		defer func() {
+			for _, r := range runs {
+				_ = r.file.Close()
+			}
+		}()
+
+		for index, name := range s.runs {
+			file, err := os.Open(name)
+			if err != nil {
+				yield(Entry[K, V]{}, fmt.Errorf("merge: %w", err))
+				return
+			}
+
+			r := &runReader[K, V]{
+				index:   index,
+				file:    file,
+				decoder: gob.NewDecoder(bufio.NewReader(file)),
+			}
+
+			ok, err := r.next()
+			if err != nil {
+				_ = file.Close()
+				yield(Entry[K, V]{}, err)
+
+				return
+			}
+
+			if ok {
+				runs = append(runs, r)
+			} else {
+				_ = file.Close()
+			}
+		}
+
+		heap.Init(&runs)
+
+		for len(runs) > 0 {
+			if err := ctx.Err(); err != nil {
+				yield(Entry[K, V]{}, err)
+				return
+			}
+
+			entry := runs[0].head
+
+			// every run holds a key at most once, so the equal keys of the other runs
+			// follow at the top of the heap
+			for {
+				if err := runs.advance(); err != nil {
+					yield(Entry[K, V]{}, err)
+					return
+				}
+
+				if len(runs) == 0 || runs[0].head.Key != entry.Key {
+					break
+				}
+
+				entry.Value = s.combiner(runs[0].head.Value, entry.Value)
+			}
+
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// runReader reads the entries of a run file one at a time.
+type runReader[K cmp.Ordered, V any] struct {
+	index   int
+	file    *os.File
+	decoder *gob.Decoder
+	head    Entry[K, V] // the current entry
+}
+
+// next reads the next entry into head and reports false at the end of the run.
+func (r *runReader[K, V]) next() (bool, error) {
+	var entry Entry[K, V]
+
+	if err := r.decoder.Decode(&entry); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("merge %s: %w", r.file.Name(), err)
+	}
+
+	r.head = entry
+
+	return true, nil
+}
+
+// runHeap orders the runs by their current keys, and the equal keys by the order of the runs.
+type runHeap[K cmp.Ordered, V any] []*runReader[K, V]
+
+// advance reads the next entry of the top run, which is removed at its end.
+func (h *runHeap[K, V]) advance() error {
+	top := (*h)[0]
+
+	ok, err := top.next()
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		heap.Fix(h, 0)
+		return nil
+	}
+
+	_ = top.file.Close()
+	heap.Pop(h)
+
+	return nil
+}
+
+func (h runHeap[K, V]) Len() int {
+	return len(h)
+}
+
+func (h runHeap[K, V]) Less(i, j int) bool {
+	if c := cmp.Compare(h[i].head.Key, h[j].head.Key); c != 0 {
+		return c < 0
+	}
+
+	return h[i].index < h[j].index
+}
+
+func (h runHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *runHeap[K, V]) Push(x any) {
+	*h = append(*h, x.(*runReader[K, V]))
+}
+
+func (h *runHeap[K, V]) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return r
+}
@@ -0,0 +1,151 @@
+package crawler
+
+import (
+	"crawler/internal/fs"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrSymlinkLoop is reported for a symbolic link leading to a directory being crawled
+// through it, unless Configuration.SkipSymlinkLoops is set.
+var ErrSymlinkLoop = errors.New("symbolic link loop")
+
+// maxSymlinkHops limits the length of the chains of links resolved to a canonical path.
+const maxSymlinkHops = 255
+
+// identity identifies a directory by its device and inode numbers on the OS file system,
+// so that the paths of a bind mount are the same directory, or else by its canonical path.
+type identity struct {
+	path     string
+	dev, ino uint64
+}
+
+// symlinks follows the symbolic links found by the search stage and makes sure
+// every directory is listed only once, so loops never cause an endless crawl.
+// A nil value does not follow links.
+type symlinks struct {
+	fileSystem fs.SymlinkFileSystem
+	skipLoops  bool
+	os         bool // the paths are the paths of the OS file system
+
+	mu      sync.Mutex
+	visited map[identity]struct{} // the listed directories
+}
+
+// newSymlinks returns nil if the configuration does not follow symbolic links.
+func newSymlinks(conf Configuration, fileSystem fs.FileSystem) (*symlinks, error) {
+	if !conf.FollowSymlinks {
+		return nil, nil
+	}
+
+	linkFileSystem, ok := fileSystem.(fs.SymlinkFileSystem)
+	if !ok {
+		return nil, fmt.Errorf("following symbolic links requires fs.SymlinkFileSystem, got %T", fileSystem)
+	}
+
+	return &symlinks{
+		fileSystem: linkFileSystem,
+		skipLoops:  conf.SkipSymlinkLoops,
+		os:         fs.IsOS(fileSystem),
+		visited:    make(map[identity]struct{}),
+	}, nil
+}
+
+// isLink reports whether the entry is a symbolic link to follow.
+func (s *symlinks) isLink(entry os.DirEntry) bool {
+	return s != nil && entry.Type()&os.ModeSymlink != 0
+}
+
+// resolve returns the entry describing the destination of the link and its canonical path.
+// The canonical path of the link is the one with all the previous links resolved.
+// The links of the OS file system are resolved by filepath.EvalSymlinks.
+func (s *symlinks) resolve(name, canonical string) (os.DirEntry, string, error) {
+	info, err := s.fileSystem.Stat(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if s.os {
+		if real, err := filepath.EvalSymlinks(name); err == nil {
+			return iofs.FileInfoToDirEntry(info), real, nil
+		}
+	}
+
+	for range maxSymlinkHops {
+		target, err := s.fileSystem.Readlink(canonical)
+		if err != nil {
+			return iofs.FileInfoToDirEntry(info), canonical, nil
+		}
+
+		if !filepath.IsAbs(target) {
+			target = s.fileSystem.Join(canonical, "..", target)
+		}
+
+		canonical = target
+	}
+
+	return nil, "", ErrSymlinkLoop
+}
+
+// enter identifies the directory and reports whether it should be listed: a directory
+// is skipped if it has been listed already, and a directory that is its own ancestor
+// is a loop, which is either skipped or reported.
+//
+// A directory reached by several paths is listed through the first path the search reaches
+// it by, which depends on the scheduling of the workers. Its files are then filtered by that
+// path, so Include, Exclude and MaxDepth must select all the paths or none of them for the
+// result to be the same on every run.
+func (s *symlinks) enter(dir *directory) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	info, err := s.fileSystem.Stat(dir.path)
+	if err != nil {
+		return false, err
+	}
+
+	// the canonical paths of the OS file system start from the real path of the root
+	if dir.parent == nil && s.os {
+		if real, err := filepath.Abs(dir.canonical); err == nil {
+			if real, err = filepath.EvalSymlinks(real); err == nil {
+				dir.canonical = real
+			}
+		}
+	}
+
+	dir.id = identity{path: dir.canonical}
+
+	if s.os {
+		if dev, ino, ok := fs.FileID(info); ok {
+			dir.id = identity{dev: dev, ino: ino}
+		}
+	}
+
+	for parent := dir.parent; parent != nil; parent = parent.parent {
+		if parent.id != dir.id {
+			continue
+		}
+
+		if s.skipLoops {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("%w: %q leads to %q", ErrSymlinkLoop, dir.path, parent.path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.visited[dir.id]; ok {
+		return false, nil
+	}
+
+	s.visited[dir.id] = struct{}{}
+
+	return true, nil
+}
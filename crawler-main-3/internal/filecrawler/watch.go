@@ -0,0 +1,427 @@
+package crawler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crawler/internal/fs"
+	"crawler/internal/pipeline"
+	"crawler/internal/workerpool"
+	"errors"
+	"iter"
+	"maps"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDebounce is the default quiet period before the changes are processed.
+	DefaultDebounce = 100 * time.Millisecond
+
+	// DefaultPollInterval is the default interval of the polling for changes.
+	DefaultPollInterval = time.Second
+)
+
+// WatchConfiguration configures Watch.
+type WatchConfiguration[R any] struct {
+	// Codec stores the results of single files, so that they can be combined again.
+	Codec Codec[R]
+
+	// Subtract, if set, removes the result of a single file from the accumulated result and
+	// returns it, which must be the inverse of the combiner. It lets the result be updated by
+	// the changed files only, otherwise the results of all files are combined again after
+	// every change. It may modify its arguments like the combiner.
+	Subtract func(removed R, accum R) R
+
+	// Debounce is the quiet period after a notification of a change before the changes are
+	// processed, so that a burst of changes is processed at once. DefaultDebounce if not set.
+	Debounce time.Duration
+
+	// PollInterval is the interval of the polling for changes, used when the changes cannot
+	// be notified by the operating system. DefaultPollInterval if not set.
+	PollInterval time.Duration
+}
+
+func (c *crawlerImpl[T, R]) Watch(
+	ctx context.Context,
+	fileSystem fs.FileSystem,
+	root string,
+	conf Configuration,
+	watch WatchConfiguration[R],
+	accumulator workerpool.Accumulator[T, R],
+	combiner Combiner[R],
+) iter.Seq2[R, error] {
+	return func(yield func(R, error) bool) {
+		var zero R
+
+		if watch.Codec == nil {
+			yield(zero, errors.New("watch codec is not set"))
+			return
+		}
+
+		if watch.Debounce <= 0 {
+			watch.Debounce = DefaultDebounce
+		}
+
+		if watch.PollInterval <= 0 {
+			watch.PollInterval = DefaultPollInterval
+		}
+
+		filter, err := newFilter(conf)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		var (
+			n       *notifier
+			changes <-chan struct{}
+		)
+
+		// the notifications are set up before the first crawl, so no change is missed
+		if fs.IsOS(fileSystem) {
+			if n, err = newNotifier(root); err == nil {
+				defer n.close()
+
+				changes = n.changes()
+			}
+		}
+
+		w := &watcher[T, R]{
+			crawler:     c,
+			fileSystem:  fileSystem,
+			root:        root,
+			conf:        conf,
+			watch:       watch,
+			filter:      filter,
+			accumulator: accumulator,
+			combiner:    combiner,
+		}
+
+		if !w.update(ctx, yield, nil, true) {
+			return
+		}
+
+		var (
+			debounce = time.NewTimer(watch.Debounce)
+			poll     <-chan time.Time
+		)
+
+		debounce.Stop()
+		defer debounce.Stop()
+
+		if changes == nil {
+			ticker := time.NewTicker(watch.PollInterval)
+			defer ticker.Stop()
+
+			poll = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+				debounce.Reset(watch.Debounce)
+				continue
+			case <-debounce.C:
+			case <-poll:
+				if !w.update(ctx, yield, nil, true) {
+					return
+				}
+
+				continue
+			}
+
+			changed, overflow := n.take()
+			if !w.update(ctx, yield, changed, overflow) {
+				return
+			}
+
+			// the signals of the changes during the update were not received
+			if n.pending() {
+				debounce.Reset(watch.Debounce)
+			}
+		}
+	}
+}
+
+// watcher keeps the result of a watched crawl up to date.
+type watcher[T, R any] struct {
+	crawler     *crawlerImpl[T, R]
+	fileSystem  fs.FileSystem
+	root        string
+	conf        Configuration
+	watch       WatchConfiguration[R]
+	filter      *filter
+	accumulator workerpool.Accumulator[T, R]
+	combiner    Combiner[R]
+
+	// entries hold the encoded results of the files by their paths, nil before the first update
+	entries map[string]manifestEntry
+	result  R
+
+	// failed is set after a failed update, so that the next one crawls the whole tree
+	failed bool
+}
+
+// update crawls the changed paths, or the whole tree if rescan is set, and yields the result
+// if it changed or the error of the crawl. Only the changed files are read in either case.
+// It reports whether the watch goes on.
+func (w *watcher[T, R]) update(ctx context.Context, yield func(R, error) bool, paths []string, rescan bool) bool {
+	var zero R
+
+	// the paths reached through the links cannot be told from the changed ones
+	rescan = rescan || w.failed || w.entries == nil || w.conf.FollowSymlinks
+
+	seed := directory{path: w.root, rel: ".", canonical: w.root}
+	stale := &staleEntries{subtrees: make(map[string]struct{}), dirs: make(map[string]struct{})}
+
+	if !rescan {
+		seed = directory{seeds: w.targets(paths, stale)}
+		if len(stale.subtrees) == 0 && len(stale.dirs) == 0 {
+			return true
+		}
+	}
+
+	entries := make(map[string]manifestEntry)
+
+	// nothing is crawled if the changed paths were only removed
+	if rescan || len(seed.seeds) > 0 {
+		m := &manifest[R]{
+			codec:    w.watch.Codec,
+			previous: w.entries,
+			current:  entries,
+		}
+
+		run, err := w.crawler.startAt(ctx, w.fileSystem, seed, w.conf, w.accumulator, 1, m)
+		if err != nil {
+			yield(zero, err)
+			return false
+		}
+
+		// the per-file results are collected by the manifest
+		results, stop := pipeline.Run(run.ctx, run.pipeline)
+		for range results {
+		}
+
+		_, err = run.finish(stop())
+		if ctx.Err() != nil {
+			return false
+		}
+
+		if err != nil {
+			// the next update crawls the whole tree, processing the changes again
+			w.failed = true
+			return yield(zero, err)
+		}
+	}
+
+	if !rescan {
+		// the entries outside the crawled paths are kept
+		for path, entry := range w.entries {
+			if _, ok := entries[path]; !ok && !stale.contains(path) {
+				entries[path] = entry
+			}
+		}
+	}
+
+	changed, err := w.apply(entries)
+	if err != nil {
+		// the previous entries and result are kept, and the next update crawls the whole tree
+		w.failed = true
+		return yield(zero, err)
+	}
+
+	w.failed = false
+
+	if !changed {
+		return true
+	}
+
+	return yield(w.result, nil)
+}
+
+// targets returns the directories to crawl for the changed paths, recording the paths
+// whose entries they replace. A changed directory is crawled entirely, and the directory
+// of a changed file is listed without its subdirectories, whose unchanged files are not
+// read again. The entries of a removed path are dropped.
+func (w *watcher[T, R]) targets(changed []string, stale *staleEntries) []directory {
+	var seeds []directory
+
+	// the shorter paths first, so the paths inside the crawled directories are skipped
+	slices.SortFunc(changed, func(a, b string) int {
+		return cmp.Compare(len(a), len(b))
+	})
+
+	for _, path := range changed {
+		if stale.inSubtree(path) {
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err == nil && info.IsDir() {
+			if dir, ok := w.directory(path); ok {
+				stale.subtrees[path] = struct{}{}
+				seeds = append(seeds, dir)
+			}
+
+			continue
+		}
+
+		stale.subtrees[path] = struct{}{}
+
+		parent := filepath.Dir(path)
+		if _, listed := stale.dirs[parent]; err != nil || listed {
+			continue
+		}
+
+		if dir, ok := w.directory(parent); ok {
+			dir.shallow = true
+			stale.dirs[parent] = struct{}{}
+			seeds = append(seeds, dir)
+		}
+	}
+
+	return seeds
+}
+
+// directory returns the directory of the tree at the path, and whether the crawl reaches it.
+func (w *watcher[T, R]) directory(path string) (directory, bool) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return directory{}, false
+	}
+
+	dir := directory{path: path, rel: ".", canonical: path}
+
+	if rel == "." {
+		return dir, true
+	}
+
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		dir.rel = pathpkg.Join(dir.rel, name)
+		dir.depth++
+
+		if !w.filter.descend(dir.rel, name, dir.depth) {
+			return directory{}, false
+		}
+	}
+
+	return dir, true
+}
+
+// staleEntries are the paths whose entries are replaced by an update: the ones inside
+// subtrees, and the files directly inside dirs.
+type staleEntries struct {
+	subtrees map[string]struct{}
+	dirs     map[string]struct{}
+}
+
+func (s *staleEntries) contains(path string) bool {
+	if _, ok := s.dirs[filepath.Dir(path)]; ok {
+		return true
+	}
+
+	return s.inSubtree(path)
+}
+
+func (s *staleEntries) inSubtree(path string) bool {
+	for {
+		if _, ok := s.subtrees[path]; ok {
+			return true
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+
+		path = parent
+	}
+}
+
+// apply updates the result with the entries of the files and reports whether it changed.
+// All the results are decoded before the entries and the result are replaced, so a failed
+// update leaves them as they were.
+func (w *watcher[T, R]) apply(entries map[string]manifestEntry) (bool, error) {
+	var added, removed []manifestEntry
+
+	for path, entry := range entries {
+		if previous, ok := w.entries[path]; !ok || !bytes.Equal(previous.Result, entry.Result) {
+			added = append(added, entry)
+		}
+	}
+
+	for path, previous := range w.entries {
+		if entry, ok := entries[path]; !ok || !bytes.Equal(previous.Result, entry.Result) {
+			removed = append(removed, previous)
+		}
+	}
+
+	// the first update yields the result even if there are no files
+	if len(added) == 0 && len(removed) == 0 && w.entries != nil {
+		w.entries = entries
+		return false, nil
+	}
+
+	if w.watch.Subtract == nil {
+		partials, err := w.decode(slices.Collect(maps.Values(entries)))
+		if err != nil {
+			return false, err
+		}
+
+		var result R
+		for _, partial := range partials {
+			result = w.combiner(partial, result)
+		}
+
+		w.entries, w.result = entries, result
+
+		return true, nil
+	}
+
+	subtracted, err := w.decode(removed)
+	if err != nil {
+		return false, err
+	}
+
+	combined, err := w.decode(added)
+	if err != nil {
+		return false, err
+	}
+
+	result := w.result
+
+	for _, partial := range subtracted {
+		result = w.watch.Subtract(partial, result)
+	}
+
+	for _, partial := range combined {
+		result = w.combiner(partial, result)
+	}
+
+	w.entries, w.result = entries, result
+
+	return true, nil
+}
+
+// decode decodes the results of the entries.
+func (w *watcher[T, R]) decode(entries []manifestEntry) ([]R, error) {
+	partials := make([]R, 0, len(entries))
+
+	for _, entry := range entries {
+		partial, err := w.watch.Codec.Decode(entry.Result)
+		if err != nil {
+			return nil, err
+		}
+
+		partials = append(partials, partial)
+	}
+
+	return partials, nil
+}
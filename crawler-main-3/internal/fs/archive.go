@@ -0,0 +1,733 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ ContextFileSystem = (*archiveFileSystem)(nil)
+
+// ErrNotRandomAccess is returned for zip archives whose files do not implement
+// io.ReaderAt and io.Seeker, as zip archives cannot be read sequentially.
+var ErrNotRandomAccess = errors.New("zip archive requires io.ReaderAt and io.Seeker")
+
+// archiveKind is the format of an archive, recognised by the file extension.
+type archiveKind int
+
+const (
+	notArchive archiveKind = iota
+	zipArchive
+	tarArchive
+	tarGzipArchive
+)
+
+const (
+	// maxIndexes bounds the archives whose indexes are kept in memory,
+	// the least recently used index is dropped and read again when needed.
+	maxIndexes = 64
+	// maxIdleCursors bounds the tar readers kept open between the entries they read.
+	maxIdleCursors = 4
+)
+
+// archiveFileSystem decorates a FileSystem presenting zip, tar and tar.gz archives as
+// directories. Nothing is extracted to disk: the entries are read from the archives on
+// demand, and only the names, sizes and positions of the entries are kept in memory.
+type archiveFileSystem struct {
+	base FileSystem
+	*archiveCache
+}
+
+// archiveCache holds the indexes and the readers of the archives, shared by the copies
+// of an archiveFileSystem bound to contexts.
+type archiveCache struct {
+	mu      sync.Mutex
+	indexes map[string]*archiveIndex
+	// recent lists the archives of indexes, the most recently used last.
+	recent []string
+	// cursors are the idle readers of tar archives, the most recently used last.
+	cursors []*tarCursor
+}
+
+// NewArchiveFileSystem returns the FileSystem presenting the files of base with the
+// extensions .zip, .tar, .tar.gz and .tgz as directories. An entry of an archive is
+// addressed by joining the path of the archive with the path of the entry,
+// e.g. Join("data", "2024.zip", "january", "1.json").
+//
+// Paths existing in base take precedence over the ones inside archives, archives inside
+// archives are presented as regular files, and archives are assumed not to change while
+// the file system is used. Zip archives require the files of base to implement
+// io.ReaderAt and io.Seeker, as os.File does, and their entries must be stored or
+// deflated, the methods supported by archive/zip without registered decompressors.
+// An entry of a zip archive is read at the offset recorded in the index. An entry of a tar archive is read at the
+// offset recorded in the index when the file of base implements io.Seeker. Otherwise,
+// and for compressed tar archives, the archive is read sequentially: a reader closed
+// after an entry is kept to open the following entries without reading the archive
+// from the start again, so the entries are cheapest to open in the archive order.
+// If base is a ContextFileSystem, WithContext binds it, e.g. to count and interrupt
+// the retries of NewRetryFileSystem.
+func NewArchiveFileSystem(base FileSystem) *archiveFileSystem {
+	return &archiveFileSystem{
+		base:         base,
+		archiveCache: &archiveCache{indexes: make(map[string]*archiveIndex)},
+	}
+}
+
+// WithContext returns the file system reading base bound to the context, sharing the
+// indexes and the readers of the archives, or the file system itself if base is not
+// a ContextFileSystem.
+func (a *archiveFileSystem) WithContext(ctx context.Context, hooks Hooks) FileSystem {
+	bound, ok := a.base.(ContextFileSystem)
+	if !ok {
+		return a
+	}
+
+	return &archiveFileSystem{base: bound.WithContext(ctx, hooks), archiveCache: a.archiveCache}
+}
+
+// Open opens the file of base or the entry of an archive.
+func (a *archiveFileSystem) Open(name string) (File, error) {
+	file, err := a.base.Open(name)
+	if err == nil {
+		return file, nil
+	}
+
+	archive, entry, kind := splitArchive(name)
+	if kind == notArchive || entry == "." {
+		return nil, err
+	}
+
+	switch kind {
+	case zipArchive:
+		return a.openZip(archive, entry)
+	default:
+		return a.openTar(archive, entry, kind)
+	}
+}
+
+// ReadDir reads the directory of base, presenting archives as directories,
+// or the directory of an archive.
+func (a *archiveFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := a.base.ReadDir(name)
+	if err == nil {
+		for i, entry := range entries {
+			if entry.Type().IsRegular() && kindOf(entry.Name()) != notArchive {
+				entries[i] = archiveDirEntry{entry}
+			}
+		}
+
+		return entries, nil
+	}
+
+	archive, dir, kind := splitArchive(name)
+	if kind == notArchive {
+		return nil, err
+	}
+
+	index, err := a.index(archive, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	listed, ok := index.dirs[dir]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	return slices.Clone(listed), nil
+}
+
+// Join joins the path elements using base.
+func (a *archiveFileSystem) Join(elem ...string) string {
+	return a.base.Join(elem...)
+}
+
+// index returns the index of the archive, reading it once while it stays among
+// the maxIndexes most recently used ones.
+func (a *archiveFileSystem) index(archive string, kind archiveKind) (*archiveIndex, error) {
+	a.mu.Lock()
+	index, ok := a.indexes[archive]
+
+	if ok {
+		i := slices.Index(a.recent, archive)
+		a.recent = slices.Delete(a.recent, i, i+1)
+	} else {
+		index = new(archiveIndex)
+		a.indexes[archive] = index
+
+		if len(a.recent) == maxIndexes {
+			delete(a.indexes, a.recent[0])
+			a.recent = slices.Delete(a.recent, 0, 1)
+		}
+	}
+
+	a.recent = append(a.recent, archive)
+	a.mu.Unlock()
+
+	index.once.Do(func() {
+		index.err = a.readIndex(index, archive, kind)
+	})
+
+	return index, index.err
+}
+
+func (a *archiveFileSystem) readIndex(index *archiveIndex, archive string, kind archiveKind) error {
+	builder := newIndexBuilder()
+	index.dirs = builder.dirs
+
+	if kind == zipArchive {
+		index.zipEntries = make(map[string]zipEntry)
+
+		return a.withZip(archive, func(reader *zip.Reader) error {
+			for _, file := range reader.File {
+				info := file.FileInfo()
+				builder.add(file.Name, info)
+
+				name := cleanEntry(file.Name)
+				if _, ok := index.zipEntries[name]; ok || info.IsDir() {
+					continue
+				}
+
+				// the position of the content follows the local header, which is read here
+				// once instead of parsing the central directory again for every entry
+				offset, err := file.DataOffset()
+				if err != nil {
+					return fmt.Errorf("archive %q: %w", archive, err)
+				}
+
+				index.zipEntries[name] = zipEntry{
+					offset:     offset,
+					compressed: int64(file.CompressedSize64),
+					size:       int64(file.UncompressedSize64),
+					method:     file.Method,
+					crc32:      file.CRC32,
+				}
+			}
+
+			return nil
+		})
+	}
+
+	index.entries = make(map[string]tarEntry)
+	ordinal := 0
+
+	return a.withTar(archive, kind, func(header *tar.Header, offset int64) {
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeDir {
+			builder.add(header.Name, header.FileInfo())
+		}
+
+		name := cleanEntry(header.Name)
+		if _, ok := index.entries[name]; !ok && header.Typeflag == tar.TypeReg {
+			index.entries[name] = tarEntry{ordinal: ordinal, offset: offset, size: header.Size}
+		}
+
+		ordinal++
+	})
+}
+
+func (a *archiveFileSystem) openZip(archive, entry string) (File, error) {
+	index, err := a.index(archive, zipArchive)
+	if err != nil {
+		return nil, err
+	}
+
+	located, ok := index.zipEntries[entry]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: a.base.Join(archive, entry), Err: os.ErrNotExist}
+	}
+
+	file, err := a.base.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		_ = file.Close()
+		return nil, fmt.Errorf("archive %q: %w", archive, ErrNotRandomAccess)
+	}
+
+	var content io.Reader = io.NewSectionReader(readerAt, located.offset, located.compressed)
+	closers := []io.Closer{file}
+
+	switch located.method {
+	case zip.Store:
+	case zip.Deflate:
+		decompressed := flate.NewReader(content)
+		content = decompressed
+		closers = []io.Closer{decompressed, file}
+	default:
+		_ = file.Close()
+		return nil, fmt.Errorf("archive %q: entry %q: %w", archive, entry, zip.ErrAlgorithm)
+	}
+
+	return &archiveFile{
+		Reader:  &checksumReader{reader: content, entry: located, hash: crc32.NewIEEE()},
+		closers: closers,
+	}, nil
+}
+
+func (a *archiveFileSystem) openTar(archive, entry string, kind archiveKind) (File, error) {
+	index, err := a.index(archive, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	located, ok := index.entries[entry]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: a.base.Join(archive, entry), Err: os.ErrNotExist}
+	}
+
+	if kind == tarArchive {
+		file, err := a.base.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+
+		if seeker, ok := file.(io.Seeker); ok {
+			if _, err := seeker.Seek(located.offset, io.SeekStart); err != nil {
+				_ = file.Close()
+				return nil, fmt.Errorf("archive %q: %w", archive, err)
+			}
+
+			return &archiveFile{Reader: io.LimitReader(file, located.size), closers: []io.Closer{file}}, nil
+		}
+
+		_ = file.Close()
+	}
+
+	cursor, err := a.cursor(archive, kind, located.ordinal)
+	if err != nil {
+		return nil, err
+	}
+
+	for cursor.next <= located.ordinal {
+		_, err := cursor.reader.Next()
+		if err != nil {
+			_ = closeAll(cursor.closers)
+
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return nil, fmt.Errorf("archive %q: %w", archive, err)
+		}
+
+		cursor.next++
+	}
+
+	return &archiveFile{Reader: cursor.reader, closers: []io.Closer{cursorRelease{a, cursor}}}, nil
+}
+
+// cursor takes the idle reader of the archive closest before the entry with the ordinal,
+// or opens a reader at the start of the archive.
+func (a *archiveFileSystem) cursor(archive string, kind archiveKind, ordinal int) (*tarCursor, error) {
+	a.mu.Lock()
+	best := -1
+
+	for i, cursor := range a.cursors {
+		if cursor.archive == archive && cursor.next <= ordinal && (best < 0 || cursor.next > a.cursors[best].next) {
+			best = i
+		}
+	}
+
+	if best >= 0 {
+		cursor := a.cursors[best]
+		a.cursors = slices.Delete(a.cursors, best, best+1)
+		a.mu.Unlock()
+
+		return cursor, nil
+	}
+	a.mu.Unlock()
+
+	reader, closers, err := a.tarReader(archive, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarCursor{archive: archive, reader: reader, closers: closers}, nil
+}
+
+// release keeps the reader for the following entries, closing the least recently
+// used idle reader above maxIdleCursors.
+func (a *archiveFileSystem) release(cursor *tarCursor) error {
+	a.mu.Lock()
+	a.cursors = append(a.cursors, cursor)
+
+	var evicted *tarCursor
+	if len(a.cursors) > maxIdleCursors {
+		evicted = a.cursors[0]
+		a.cursors = slices.Delete(a.cursors, 0, 1)
+	}
+	a.mu.Unlock()
+
+	if evicted == nil {
+		return nil
+	}
+
+	return closeAll(evicted.closers)
+}
+
+// tarReader opens the tar archive, the closers must be closed once the reader is not needed.
+func (a *archiveFileSystem) tarReader(archive string, kind archiveKind) (*tar.Reader, []io.Closer, error) {
+	file, err := a.base.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if kind != tarGzipArchive {
+		return tar.NewReader(file), []io.Closer{file}, nil
+	}
+
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, fmt.Errorf("archive %q: %w", archive, err)
+	}
+
+	return tar.NewReader(decompressed), []io.Closer{decompressed, file}, nil
+}
+
+// withZip calls f with the reader of the zip archive.
+func (a *archiveFileSystem) withZip(archive string, f func(reader *zip.Reader) error) error {
+	file, err := a.base.Open(archive)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader, err := newZipReader(archive, file)
+	if err != nil {
+		return err
+	}
+
+	return f(reader)
+}
+
+// withTar calls f for every header of the tar archive with the offset of its content
+// in the uncompressed archive.
+func (a *archiveFileSystem) withTar(archive string, kind archiveKind, f func(header *tar.Header, offset int64)) error {
+	file, err := a.base.Open(archive)
+	if err != nil {
+		return err
+	}
+
+	closers := []io.Closer{file}
+
+	defer func() {
+		_ = closeAll(closers)
+	}()
+
+	var source io.Reader = file
+
+	if kind == tarGzipArchive {
+		decompressed, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("archive %q: %w", archive, err)
+		}
+
+		closers = append(closers, decompressed)
+		source = decompressed
+	}
+
+	// tar.Reader reads whole blocks without buffering ahead, so after Next the count
+	// is the offset of the content of the entry.
+	counted := &countingReader{reader: source}
+	reader := tar.NewReader(counted)
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("archive %q: %w", archive, err)
+		}
+
+		f(header, counted.count)
+	}
+}
+
+func newZipReader(archive string, file File) (*zip.Reader, error) {
+	readerAt, ok := file.(io.ReaderAt)
+	seeker, seekable := file.(io.Seeker)
+
+	if !ok || !seekable {
+		return nil, fmt.Errorf("archive %q: %w", archive, ErrNotRandomAccess)
+	}
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("archive %q: %w", archive, err)
+	}
+
+	reader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, fmt.Errorf("archive %q: %w", archive, err)
+	}
+
+	return reader, nil
+}
+
+// archiveIndex lists the directories of an archive by their slash-separated paths,
+// the root of the archive is ".", and locates its regular files.
+type archiveIndex struct {
+	once       sync.Once
+	dirs       map[string][]os.DirEntry
+	entries    map[string]tarEntry
+	zipEntries map[string]zipEntry
+	err        error
+}
+
+// zipEntry locates a regular file of a zip archive.
+type zipEntry struct {
+	// offset is the position of the content in the archive.
+	offset     int64
+	compressed int64
+	size       int64
+	method     uint16
+	crc32      uint32
+}
+
+// tarEntry locates a regular file of a tar archive.
+type tarEntry struct {
+	// ordinal is the number of headers before the entry.
+	ordinal int
+	// offset is the position of the content in the uncompressed archive.
+	offset int64
+	size   int64
+}
+
+// tarCursor reads a tar archive sequentially, next is the ordinal of the following header.
+type tarCursor struct {
+	archive string
+	next    int
+	reader  *tar.Reader
+	closers []io.Closer
+}
+
+// cursorRelease returns the cursor to the file system once its entry is closed.
+type cursorRelease struct {
+	fileSystem *archiveFileSystem
+	cursor     *tarCursor
+}
+
+func (r cursorRelease) Close() error {
+	return r.fileSystem.release(r.cursor)
+}
+
+// countingReader counts the bytes read from reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
+
+// checksumReader verifies the size and the checksum of a zip entry once it is read,
+// as zip.File.Open does.
+type checksumReader struct {
+	reader io.Reader
+	entry  zipEntry
+	hash   hash.Hash32
+	read   int64
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	_, _ = r.hash.Write(p[:n])
+	r.read += int64(n)
+
+	if r.read > r.entry.size {
+		return n, zip.ErrFormat
+	}
+
+	if errors.Is(err, io.EOF) {
+		if r.read != r.entry.size {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		if r.entry.crc32 != 0 && r.hash.Sum32() != r.entry.crc32 {
+			return n, zip.ErrChecksum
+		}
+	}
+
+	return n, err
+}
+
+// indexBuilder collects the entries of an archive, adding the directories
+// which are implied by the paths of the entries but missing from the archive.
+type indexBuilder struct {
+	dirs  map[string][]os.DirEntry
+	names map[string]struct{}
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{
+		dirs:  map[string][]os.DirEntry{".": nil},
+		names: map[string]struct{}{".": {}},
+	}
+}
+
+func (b *indexBuilder) add(name string, info os.FileInfo) {
+	name = cleanEntry(name)
+	if _, ok := b.names[name]; ok {
+		return
+	}
+
+	if info.IsDir() {
+		b.dirs[name] = nil
+	}
+
+	b.names[name] = struct{}{}
+
+	parent := path.Dir(name)
+	if _, ok := b.names[parent]; !ok {
+		b.add(parent, dirInfo{name: path.Base(parent)})
+	}
+
+	base := path.Base(name)
+	entries := b.dirs[parent]
+	i, _ := slices.BinarySearchFunc(entries, base, func(entry os.DirEntry, name string) int {
+		return strings.Compare(entry.Name(), name)
+	})
+	b.dirs[parent] = slices.Insert(entries, i, iofs.FileInfoToDirEntry(namedInfo{info, base}))
+}
+
+// archiveFile is an open entry of an archive.
+type archiveFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *archiveFile) Close() error {
+	return closeAll(f.closers)
+}
+
+func closeAll(closers []io.Closer) error {
+	var errs []error
+
+	for _, closer := range closers {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// archiveDirEntry presents an archive file as a directory.
+type archiveDirEntry struct {
+	os.DirEntry
+}
+
+func (e archiveDirEntry) IsDir() bool {
+	return true
+}
+
+func (e archiveDirEntry) Type() os.FileMode {
+	return os.ModeDir
+}
+
+func (e archiveDirEntry) Info() (os.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return dirInfo{name: info.Name(), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// dirInfo describes a directory of an archive or an archive presented as a directory.
+type dirInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i dirInfo) Name() string       { return i.name }
+func (i dirInfo) Size() int64        { return i.size }
+func (i dirInfo) Mode() os.FileMode  { return os.ModeDir | 0o555 }
+func (i dirInfo) ModTime() time.Time { return i.modTime }
+func (i dirInfo) IsDir() bool        { return true }
+func (i dirInfo) Sys() any           { return nil }
+
+// namedInfo overrides the name of the file info, as the names of
+// the archive headers may differ from the cleaned paths of the entries.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string { return i.name }
+
+// splitArchive splits the name into the path of the first archive it contains
+// and the slash-separated path of the entry inside the archive.
+func splitArchive(name string) (archive, entry string, kind archiveKind) {
+	start := 0
+
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && !isSeparator(name[i]) {
+			continue
+		}
+
+		if kind = kindOf(name[start:i]); kind != notArchive {
+			return name[:i], cleanEntry(filepath.ToSlash(name[i:])), kind
+		}
+
+		start = i + 1
+	}
+
+	return "", "", notArchive
+}
+
+func isSeparator(c byte) bool {
+	return c == '/' || c == filepath.Separator
+}
+
+func kindOf(name string) archiveKind {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return zipArchive
+	case strings.HasSuffix(name, ".tar"):
+		return tarArchive
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return tarGzipArchive
+	default:
+		return notArchive
+	}
+}
+
+// cleanEntry converts the path of an archive entry to the form used by the index.
+func cleanEntry(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+
+	return name[1:]
+}
@@ -0,0 +1,315 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var archived = map[string]string{
+	"a.json":          `{"data": 1}`,
+	"dir/b.json":      `{"data": 2}`,
+	"dir/deep/c.json": `{"data": 3}`,
+}
+
+func writeZip(t *testing.T, name string) {
+	t.Helper()
+
+	file, err := os.Create(name)
+	require.NoError(t, err)
+
+	writer := zip.NewWriter(file)
+
+	for entry, content := range archived {
+		w, err := writer.Create(entry)
+		require.NoError(t, err)
+
+		_, err = io.WriteString(w, content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
+}
+
+func writeTar(t *testing.T, name string) {
+	t.Helper()
+
+	file, err := os.Create(name)
+	require.NoError(t, err)
+
+	var compressed io.WriteCloser = nopWriteCloser{file}
+	if strings.HasSuffix(name, ".gz") {
+		compressed = gzip.NewWriter(file)
+	}
+
+	writer := tar.NewWriter(compressed)
+
+	require.NoError(t, writer.WriteHeader(&tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0o755}))
+
+	for entry, content := range archived {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name:     "./" + entry,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		}))
+
+		_, err = io.WriteString(writer, content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, compressed.Close())
+	require.NoError(t, file.Close())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestArchiveFileSystem(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeZip(t, filepath.Join(root, "data.zip"))
+	writeTar(t, filepath.Join(root, "data.tar"))
+	writeTar(t, filepath.Join(root, "data.tar.gz"))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "plain.json"), []byte(`{}`), 0o600))
+
+	fileSystem := NewArchiveFileSystem(NewOsFileSystem())
+
+	entries, err := fileSystem.ReadDir(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	for _, entry := range entries {
+		require.Equal(t, entry.Name() != "plain.json", entry.IsDir(), entry.Name())
+	}
+
+	for _, archive := range []string{"data.zip", "data.tar", "data.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			t.Parallel()
+
+			names := func(dir ...string) []string {
+				entries, err := fileSystem.ReadDir(fileSystem.Join(append([]string{root, archive}, dir...)...))
+				require.NoError(t, err)
+
+				result := make([]string, 0, len(entries))
+				for _, entry := range entries {
+					result = append(result, entry.Name())
+				}
+
+				return result
+			}
+
+			require.Equal(t, []string{"a.json", "dir"}, names())
+			require.Equal(t, []string{"b.json", "deep"}, names("dir"))
+			require.Equal(t, []string{"c.json"}, names("dir", "deep"))
+
+			for entry, content := range archived {
+				file, err := fileSystem.Open(fileSystem.Join(root, archive, entry))
+				require.NoError(t, err)
+
+				data, err := io.ReadAll(file)
+				require.NoError(t, err)
+				require.Equal(t, content, string(data))
+				require.NoError(t, file.Close())
+			}
+
+			_, err := fileSystem.Open(fileSystem.Join(root, archive, "missing.json"))
+			require.ErrorIs(t, err, os.ErrNotExist)
+
+			_, err = fileSystem.ReadDir(fileSystem.Join(root, archive, "missing"))
+			require.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+// countingFileSystem counts the files opened in base.
+type countingFileSystem struct {
+	FileSystem
+	opened int
+}
+
+func (c *countingFileSystem) Open(name string) (File, error) {
+	c.opened++
+	return c.FileSystem.Open(name)
+}
+
+func TestArchiveFileSystemReuse(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeTar(t, filepath.Join(root, "data.tar"))
+	writeTar(t, filepath.Join(root, "data.tar.gz"))
+
+	for _, archive := range []string{"data.tar", "data.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			t.Parallel()
+
+			base := &countingFileSystem{FileSystem: NewOsFileSystem()}
+			fileSystem := NewArchiveFileSystem(base)
+
+			var order []string
+
+			// the index is read once, listing the entries in the archive order
+			index, err := fileSystem.index(filepath.Join(root, archive), kindOf(archive))
+			require.NoError(t, err)
+
+			for entry := range archived {
+				order = append(order, entry)
+			}
+
+			slices.SortFunc(order, func(a, b string) int {
+				return index.entries[a].ordinal - index.entries[b].ordinal
+			})
+
+			for _, entry := range order {
+				file, err := fileSystem.Open(fileSystem.Join(root, archive, entry))
+				require.NoError(t, err)
+
+				data, err := io.ReadAll(file)
+				require.NoError(t, err)
+				require.Equal(t, archived[entry], string(data))
+				require.NoError(t, file.Close())
+			}
+
+			// the index, the path of every entry tried in base, and the archive opened
+			// for every entry or once for the sequential reader of the compressed archive
+			opened := 1 + len(archived) + len(archived)
+			if archive == "data.tar.gz" {
+				opened = 1 + len(archived) + 1
+			}
+
+			require.Equal(t, opened, base.opened)
+		})
+	}
+}
+
+// readCountingFileSystem counts the bytes read from the files opened in base.
+type readCountingFileSystem struct {
+	FileSystem
+	read int64
+}
+
+func (c *readCountingFileSystem) Open(name string) (File, error) {
+	file, err := c.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCountingFile{File: file.(*os.File), read: &c.read}, nil
+}
+
+type readCountingFile struct {
+	*os.File
+	read *int64
+}
+
+func (f *readCountingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	*f.read += int64(n)
+
+	return n, err
+}
+
+func (f *readCountingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	*f.read += int64(n)
+
+	return n, err
+}
+
+func TestArchiveFileSystemZipIndex(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "many.zip")
+	file, err := os.Create(name)
+	require.NoError(t, err)
+
+	writer := zip.NewWriter(file)
+	entries := make([]string, 0, 500)
+
+	for i := range cap(entries) {
+		entry := fmt.Sprintf("dir/%03d.json", i)
+		entries = append(entries, entry)
+
+		w, err := writer.Create(entry)
+		require.NoError(t, err)
+
+		_, err = fmt.Fprintf(w, `{"data": %d}`, i)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
+
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+
+	base := &readCountingFileSystem{FileSystem: NewOsFileSystem()}
+	fileSystem := NewArchiveFileSystem(base)
+
+	for i, entry := range entries {
+		file, err := fileSystem.Open(fileSystem.Join(name, entry))
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(`{"data": %d}`, i), string(data))
+		require.NoError(t, file.Close())
+	}
+
+	// the central directory is parsed once for the index, then only the entries are read,
+	// while parsing it for every entry would read the archive hundreds of times
+	require.Less(t, base.read, 2*info.Size())
+}
+
+func TestArchiveFileSystemZipChecksum(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "data.zip")
+	file, err := os.Create(name)
+	require.NoError(t, err)
+
+	writer := zip.NewWriter(file)
+	w, err := writer.CreateHeader(&zip.FileHeader{Name: "a.json", Method: zip.Store})
+	require.NoError(t, err)
+
+	_, err = io.WriteString(w, archived["a.json"])
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
+
+	fileSystem := NewArchiveFileSystem(NewOsFileSystem())
+	index, err := fileSystem.index(name, zipArchive)
+	require.NoError(t, err)
+
+	// corrupt the stored content, keeping its size
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+
+	data[index.zipEntries["a.json"].offset] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+
+	entry, err := fileSystem.Open(fileSystem.Join(name, "a.json"))
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(entry)
+	require.ErrorIs(t, err, zip.ErrChecksum)
+	require.NoError(t, entry.Close())
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package fs
+
+import "os"
+
+// FileID returns the device and inode numbers identifying the file described by info,
+// which are not available on this platform.
+func FileID(_ os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileID returns the device and inode numbers identifying the file described by info,
+// if info comes from the OS file system.
+func FileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return uint64(stat.Dev), uint64(stat.Ino), true //nolint:unconvert // the types differ across platforms
+}
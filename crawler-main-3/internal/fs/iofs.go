@@ -0,0 +1,227 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+)
+
+var (
+	_ ContextFileSystem = (*ioFileSystem)(nil)
+	_ iofs.ReadDirFS    = (*standardFS)(nil)
+	_ iofs.StatFS       = (*standardFS)(nil)
+	_ iofs.ReadDirFile  = (*standardDir)(nil)
+)
+
+// ioFileSystem adapts a standard io/fs.FS, e.g. embed.FS or testing/fstest.MapFS,
+// to the FileSystem interface.
+type ioFileSystem struct {
+	fsys iofs.FS
+}
+
+// FromFS returns the FileSystem reading the files of fsys. The paths are the ones
+// of io/fs: slash-separated and unrooted, "." is the root. The FileSystem is as
+// thread-safe as fsys is. If fsys was returned by ToFS for a ContextFileSystem,
+// WithContext binds that file system.
+func FromFS(fsys iofs.FS) *ioFileSystem {
+	return &ioFileSystem{fsys: fsys}
+}
+
+// WithContext returns the file system reading fsys bound to the context, or the file
+// system itself if fsys does not adapt a ContextFileSystem.
+func (f *ioFileSystem) WithContext(ctx context.Context, hooks Hooks) FileSystem {
+	adapted, ok := f.fsys.(*standardFS)
+	if !ok {
+		return f
+	}
+
+	bound, ok := adapted.fileSystem.(ContextFileSystem)
+	if !ok {
+		return f
+	}
+
+	return &ioFileSystem{fsys: &standardFS{fileSystem: bound.WithContext(ctx, hooks), root: adapted.root}}
+}
+
+// Open opens the named file using fsys.Open.
+func (f *ioFileSystem) Open(name string) (File, error) {
+	return f.fsys.Open(name)
+}
+
+// ReadDir reads the named directory using io/fs.ReadDir.
+func (f *ioFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return iofs.ReadDir(f.fsys, name)
+}
+
+// Join joins the path elements using path.Join.
+func (f *ioFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// standardFS adapts a FileSystem to the standard io/fs.FS interface.
+type standardFS struct {
+	fileSystem FileSystem
+	root       string
+}
+
+// ToFS returns the io/fs.FS holding the tree of the fileSystem rooted at root.
+// The files are described by the entries of their parent directories, or by
+// SymlinkFileSystem.Stat if the fileSystem implements it. The io/fs.FS cannot be bound
+// to a context: a ContextFileSystem is used as it is, unless FromFS adapts it back.
+func ToFS(fileSystem FileSystem, root string) iofs.FS {
+	return &standardFS{fileSystem: fileSystem, root: root}
+}
+
+// Open opens the named file or directory.
+func (s *standardFS) Open(name string) (iofs.File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, replaceOp(err, "open")
+	}
+
+	if info.IsDir() {
+		return &standardDir{fs: s, name: name, info: info}, nil
+	}
+
+	file, err := s.fileSystem.Open(s.path(name))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: unwrapPath(err)}
+	}
+
+	return &standardFile{File: file, info: info}, nil
+}
+
+// ReadDir reads the named directory.
+func (s *standardFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	entries, err := s.fileSystem.ReadDir(s.path(name))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: unwrapPath(err)}
+	}
+
+	return entries, nil
+}
+
+// Stat returns the file info of the named file.
+func (s *standardFS) Stat(name string) (iofs.FileInfo, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "stat", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	if linkFileSystem, ok := s.fileSystem.(SymlinkFileSystem); ok {
+		info, err := linkFileSystem.Stat(s.path(name))
+		if err != nil {
+			return nil, &iofs.PathError{Op: "stat", Path: name, Err: unwrapPath(err)}
+		}
+
+		return info, nil
+	}
+
+	if name == "." {
+		return dirInfo{name: "."}, nil
+	}
+
+	entries, err := s.fileSystem.ReadDir(s.path(path.Dir(name)))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "stat", Path: name, Err: unwrapPath(err)}
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == path.Base(name) {
+			return entry.Info()
+		}
+	}
+
+	return nil, &iofs.PathError{Op: "stat", Path: name, Err: iofs.ErrNotExist}
+}
+
+func (s *standardFS) path(name string) string {
+	return s.fileSystem.Join(s.root, name)
+}
+
+// standardFile is an opened regular file of standardFS.
+type standardFile struct {
+	File
+	info iofs.FileInfo
+}
+
+func (f *standardFile) Stat() (iofs.FileInfo, error) {
+	return f.info, nil
+}
+
+// standardDir is an opened directory of standardFS, its entries are read on the first use.
+type standardDir struct {
+	fs      *standardFS
+	name    string
+	info    iofs.FileInfo
+	entries []iofs.DirEntry
+	read    bool
+}
+
+func (d *standardDir) Stat() (iofs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *standardDir) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *standardDir) Close() error {
+	return nil
+}
+
+func (d *standardDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+
+		d.entries, d.read = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+// unwrapPath returns the error wrapped by a path error, so that it can be reported
+// with the io/fs path instead of the one of the FileSystem. Only the path error itself
+// is unwrapped, as the errors wrapping it, e.g. with the cancellation of the retries,
+// carry more than it does.
+func unwrapPath(err error) error {
+	if pathErr, ok := err.(*iofs.PathError); ok {
+		return pathErr.Err
+	}
+
+	return err
+}
+
+// replaceOp returns the path error with the operation replaced.
+func replaceOp(err error, op string) error {
+	var pathErr *iofs.PathError
+	if errors.As(err, &pathErr) {
+		return &iofs.PathError{Op: op, Path: pathErr.Path, Err: pathErr.Err}
+	}
+
+	return err
+}
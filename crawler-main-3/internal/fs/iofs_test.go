@@ -0,0 +1,39 @@
+package fs
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromFS(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := FromFS(fstest.MapFS{
+		"data/a.json":     {Data: []byte(`{"data": 1}`)},
+		"data/dir/b.json": {Data: []byte(`{"data": 2}`)},
+	})
+
+	entries, err := fileSystem.ReadDir("data")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "a.json", entries[0].Name())
+	require.True(t, entries[1].IsDir())
+
+	require.Equal(t, `{"data": 2}`, readFile(t, fileSystem, fileSystem.Join("data", "dir", "b.json")))
+}
+
+func TestToFS(t *testing.T) {
+	t.Parallel()
+
+	memory := NewMemoryFileSystem()
+	require.NoError(t, memory.WriteFile("root/a.json", []byte(`{"data": 1}`)))
+	require.NoError(t, memory.WriteFile("root/dir/b.json", []byte(`{"data": 2}`)))
+	require.NoError(t, memory.MkdirAll("root/empty"))
+
+	require.NoError(t, fstest.TestFS(ToFS(memory, "root"), "a.json", "dir/b.json", "empty"))
+
+	// without SymlinkFileSystem the files are described by the directory entries
+	require.NoError(t, fstest.TestFS(ToFS(FromFS(ToFS(memory, "root")), "."), "a.json", "dir/b.json"))
+}
@@ -0,0 +1,525 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ SymlinkFileSystem = (*memoryFileSystem)(nil)
+	_ ContextFileSystem = (*memoryFileSystem)(nil)
+)
+
+// errNotDir is returned when a path element that is not a directory is used as one.
+var errNotDir = errors.New("not a directory")
+
+// errTooManyLinks is returned when resolving a path takes more than maxLinkHops links.
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// maxLinkHops limits the number of links followed while resolving a path.
+const maxLinkHops = 255
+
+// Op is an operation of a FileSystem a fault can be injected into.
+type Op string
+
+const (
+	OpOpen     Op = "open"     // FileSystem.Open
+	OpReadDir  Op = "readdir"  // FileSystem.ReadDir
+	OpRead     Op = "read"     // Read, ReadAt, ReadByte and WriteTo of the opened file, on every call
+	OpStat     Op = "stat"     // SymlinkFileSystem.Stat
+	OpReadlink Op = "readlink" // SymlinkFileSystem.Readlink
+)
+
+// Fault describes the misbehaviour injected into an operation on a path.
+// The latency is applied first, interrupted by the cancellation of the context the file
+// system is bound to with WithContext, then the operation panics with Panic if it is not nil,
+// or fails with Err if it is not nil.
+type Fault struct {
+	Latency time.Duration
+	Panic   any
+	Err     error
+}
+
+type faultKey struct {
+	op   Op
+	name string
+}
+
+// memoryNode is a file, a directory or a symbolic link.
+type memoryNode struct {
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte                 // contents of a file
+	target   string                 // destination of a link
+	children map[string]*memoryNode // entries of a directory
+}
+
+// memoryFileSystem is a thread-safe in-memory implementation of the SymlinkFileSystem
+// interface, which is writable and can simulate failures.
+type memoryFileSystem struct {
+	*memoryTree
+	ctx context.Context
+}
+
+// memoryTree holds the nodes and the faults, shared by the copies of a memoryFileSystem
+// bound to contexts.
+type memoryTree struct {
+	mu     sync.RWMutex
+	root   *memoryNode
+	faults map[faultKey]Fault
+}
+
+// NewMemoryFileSystem creates an empty in-memory file system.
+// Paths are slash-separated, relative paths are resolved from the root, so "a/b" and "/a/b"
+// are the same file. The opened files implement io.ReaderAt and io.Seeker.
+// Symbolic links are followed when reading, but not when writing.
+func NewMemoryFileSystem() *memoryFileSystem {
+	return &memoryFileSystem{
+		memoryTree: &memoryTree{
+			root:   newMemoryDir(),
+			faults: make(map[faultKey]Fault),
+		},
+		ctx: context.Background(),
+	}
+}
+
+// WithContext returns a copy of the file system, sharing its files and faults, whose
+// injected latencies are interrupted by the context. It has no events for the hooks.
+func (m *memoryFileSystem) WithContext(ctx context.Context, _ Hooks) FileSystem {
+	return &memoryFileSystem{memoryTree: m.memoryTree, ctx: ctx}
+}
+
+func newMemoryDir() *memoryNode {
+	return &memoryNode{
+		mode:     os.ModeDir | 0o755,
+		modTime:  time.Now(),
+		children: make(map[string]*memoryNode),
+	}
+}
+
+// Open opens the named file for reading.
+func (m *memoryFileSystem) Open(name string) (File, error) {
+	if err := m.fault(OpOpen, name); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+
+	// the contents are never modified in place, so the file is a consistent snapshot
+	return &memoryFile{reader: bytes.NewReader(node.data), fs: m, name: name}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (m *memoryFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	if err := m.fault(OpReadDir, name); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	if !node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	entries := make([]os.DirEntry, 0, len(node.children))
+	for childName, child := range node.children {
+		entries = append(entries, iofs.FileInfoToDirEntry(child.info(childName)))
+	}
+
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return entries, nil
+}
+
+// Join joins the path elements using path.Join.
+func (m *memoryFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Stat returns the file info of the named file, following symbolic links.
+func (m *memoryFileSystem) Stat(name string) (os.FileInfo, error) {
+	if err := m.fault(OpStat, name); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return node.info(path.Base(name)), nil
+}
+
+// Readlink returns the destination of the named symbolic link.
+func (m *memoryFileSystem) Readlink(name string) (string, error) {
+	if err := m.fault(OpReadlink, name); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, err := m.lookup(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	if node.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	return node.target, nil
+}
+
+// WriteFile writes the data to the named file, creating it and its parent directories
+// if necessary. The data is copied.
+func (m *memoryFileSystem) WriteFile(name string, data []byte) error {
+	return m.create("write", name, &memoryNode{mode: 0o644, data: bytes.Clone(data)})
+}
+
+// Symlink creates the named symbolic link to the target, creating its parent directories
+// if necessary. A relative target is resolved from the directory of the link.
+func (m *memoryFileSystem) Symlink(target, name string) error {
+	return m.create("symlink", name, &memoryNode{mode: os.ModeSymlink | 0o777, target: target})
+}
+
+// MkdirAll creates the named directory and its parents if necessary.
+func (m *memoryFileSystem) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.mkdirAll(elements(name)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Remove removes the named file, link or directory with all its contents.
+func (m *memoryFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parts := elements(name)
+	if len(parts) == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	parent, err := m.walk(parts[:len(parts)-1])
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	last := parts[len(parts)-1]
+	if _, ok := parent.children[last]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	delete(parent.children, last)
+	parent.modTime = time.Now()
+
+	return nil
+}
+
+// InjectFault makes the operation on the named path misbehave as described by the fault,
+// replacing the previous fault of the operation on the path.
+// The path must be passed to the operation as it is passed to InjectFault.
+func (m *memoryFileSystem) InjectFault(op Op, name string, fault Fault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults[faultKey{op: op, name: name}] = fault
+}
+
+// ClearFaults removes all the injected faults.
+func (m *memoryFileSystem) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.faults)
+}
+
+// fault applies the fault injected into the operation on the path, if any.
+func (m *memoryFileSystem) fault(op Op, name string) error {
+	m.mu.RLock()
+	fault, ok := m.faults[faultKey{op: op, name: name}]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			return &os.PathError{Op: string(op), Path: name, Err: m.ctx.Err()}
+		case <-timer.C:
+		}
+	}
+
+	if fault.Panic != nil {
+		panic(fault.Panic)
+	}
+
+	if fault.Err != nil {
+		return &os.PathError{Op: string(op), Path: name, Err: fault.Err}
+	}
+
+	return nil
+}
+
+// create adds the node to its parent directory, which is created if necessary.
+func (m *memoryFileSystem) create(op, name string, node *memoryNode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parts := elements(name)
+	if len(parts) == 0 {
+		return &os.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	parent, err := m.mkdirAll(parts[:len(parts)-1])
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	last := parts[len(parts)-1]
+	if existing, ok := parent.children[last]; ok && existing.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	}
+
+	node.modTime = time.Now()
+	parent.children[last] = node
+	parent.modTime = node.modTime
+
+	return nil
+}
+
+// mkdirAll returns the directory of the path elements, creating the missing ones.
+func (m *memoryFileSystem) mkdirAll(parts []string) (*memoryNode, error) {
+	node := m.root
+
+	for _, part := range parts {
+		child, ok := node.children[part]
+		if !ok {
+			child = newMemoryDir()
+			node.children[part] = child
+			node.modTime = child.modTime
+		}
+
+		if !child.mode.IsDir() {
+			return nil, errNotDir
+		}
+
+		node = child
+	}
+
+	return node, nil
+}
+
+// walk returns the directory of the path elements without following links.
+func (m *memoryFileSystem) walk(parts []string) (*memoryNode, error) {
+	node := m.root
+
+	for _, part := range parts {
+		child, ok := node.children[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		if !child.mode.IsDir() {
+			return nil, errNotDir
+		}
+
+		node = child
+	}
+
+	return node, nil
+}
+
+// lookup returns the node of the path. Links are followed in all the elements of the path
+// but the last one, which is followed only if follow is set.
+func (m *memoryFileSystem) lookup(name string, follow bool) (*memoryNode, error) {
+	parts := elements(name)
+
+	// the directories from the root to the current one, to resolve ".." in link targets
+	dirs := []*memoryNode{m.root}
+	node := m.root
+	hops := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		if part == "." {
+			continue
+		}
+
+		if part == ".." {
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+
+			node = dirs[len(dirs)-1]
+
+			continue
+		}
+
+		if !node.mode.IsDir() {
+			return nil, errNotDir
+		}
+
+		child, ok := node.children[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		if child.mode&os.ModeSymlink != 0 && (len(parts) > 0 || follow) {
+			if hops++; hops > maxLinkHops {
+				return nil, errTooManyLinks
+			}
+
+			if strings.HasPrefix(child.target, "/") {
+				dirs, node = dirs[:1], m.root
+			}
+
+			parts = append(strings.Split(path.Clean(strings.TrimPrefix(child.target, "/")), "/"), parts...)
+
+			continue
+		}
+
+		node = child
+		dirs = append(dirs, child)
+	}
+
+	return node, nil
+}
+
+// elements splits the path into its elements, the root has none.
+func elements(name string) []string {
+	name = cleanEntry(name)
+	if name == "." {
+		return nil
+	}
+
+	return strings.Split(name, "/")
+}
+
+func (n *memoryNode) info(name string) os.FileInfo {
+	return memoryInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// memoryInfo describes a node of the memory file system.
+type memoryInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memoryInfo) Name() string       { return i.name }
+func (i memoryInfo) Size() int64        { return i.size }
+func (i memoryInfo) Mode() os.FileMode  { return i.mode }
+func (i memoryInfo) ModTime() time.Time { return i.modTime }
+func (i memoryInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memoryInfo) Sys() any           { return nil }
+
+// memoryFile is an opened file of the memory file system. Every read fails once
+// the file is closed and applies the fault injected into OpRead.
+type memoryFile struct {
+	reader *bytes.Reader
+	fs     *memoryFileSystem
+	name   string
+	closed bool
+}
+
+func (f *memoryFile) Read(p []byte) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.reader.Read(p)
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.reader.ReadAt(p, off)
+}
+
+func (f *memoryFile) ReadByte() (byte, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.reader.ReadByte()
+}
+
+func (f *memoryFile) WriteTo(w io.Writer) (int64, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+
+	return f.reader.WriteTo(w)
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *memoryFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	f.closed = true
+
+	return nil
+}
+
+func (f *memoryFile) check() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	return f.fs.fault(OpRead, f.name)
+}
@@ -0,0 +1,213 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, fileSystem FileSystem, name string) string {
+	t.Helper()
+
+	file, err := fileSystem.Open(name)
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, file.Close())
+	}()
+
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestMemoryFileSystem(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := NewMemoryFileSystem()
+
+	require.NoError(t, fileSystem.WriteFile("/data/a.json", []byte(`{"data": 1}`)))
+	require.NoError(t, fileSystem.WriteFile("data/dir/b.json", []byte(`{"data": 2}`)))
+	require.NoError(t, fileSystem.MkdirAll("data/empty"))
+
+	require.Equal(t, `{"data": 1}`, readFile(t, fileSystem, "data/a.json"))
+	require.Equal(t, `{"data": 2}`, readFile(t, fileSystem, fileSystem.Join("/data", "dir", "b.json")))
+
+	entries, err := fileSystem.ReadDir("data")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "a.json", entries[0].Name())
+	require.False(t, entries[0].IsDir())
+	require.True(t, entries[1].IsDir())
+
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	require.EqualValues(t, 11, info.Size())
+
+	require.ErrorIs(t, fileSystem.WriteFile("data/a.json/c.json", nil), errNotDir)
+	require.ErrorIs(t, fileSystem.WriteFile("data/dir", nil), os.ErrExist)
+
+	_, err = fileSystem.ReadDir("data/a.json")
+	require.ErrorIs(t, err, errNotDir)
+
+	require.NoError(t, fileSystem.Remove("data/dir"))
+
+	_, err = fileSystem.Open("data/dir/b.json")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, fileSystem.Remove("data/dir"), os.ErrNotExist)
+}
+
+func TestMemoryFileSystemSymlinks(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := NewMemoryFileSystem()
+
+	require.NoError(t, fileSystem.WriteFile("data/dir/a.json", []byte("a")))
+	require.NoError(t, fileSystem.Symlink("dir/a.json", "data/file"))
+	require.NoError(t, fileSystem.Symlink("..", "data/dir/up"))
+	require.NoError(t, fileSystem.Symlink("/data/dir", "other/abs"))
+	require.NoError(t, fileSystem.Symlink("loop", "loop"))
+
+	require.Equal(t, "a", readFile(t, fileSystem, "data/file"))
+	require.Equal(t, "a", readFile(t, fileSystem, "data/dir/up/dir/up/file"))
+	require.Equal(t, "a", readFile(t, fileSystem, "other/abs/a.json"))
+
+	entries, err := fileSystem.ReadDir("data")
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSymlink, entries[1].Type())
+
+	info, err := fileSystem.Stat("data/dir/up")
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+	require.Equal(t, "up", info.Name())
+
+	target, err := fileSystem.Readlink("data/dir/up")
+	require.NoError(t, err)
+	require.Equal(t, "..", target)
+
+	_, err = fileSystem.Readlink("data/dir")
+	require.Error(t, err)
+
+	_, err = fileSystem.Stat("loop")
+	require.ErrorIs(t, err, errTooManyLinks)
+}
+
+func TestMemoryFileSystemFaults(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := NewMemoryFileSystem()
+	require.NoError(t, fileSystem.WriteFile("a.json", []byte("a")))
+
+	errInjected := errors.New("injected")
+
+	fileSystem.InjectFault(OpReadDir, ".", Fault{Err: errInjected})
+	fileSystem.InjectFault(OpOpen, "a.json", Fault{Latency: 50 * time.Millisecond})
+	fileSystem.InjectFault(OpRead, "a.json", Fault{Panic: "read panic"})
+
+	_, err := fileSystem.ReadDir(".")
+	require.ErrorIs(t, err, errInjected)
+
+	start := time.Now()
+	file, err := fileSystem.Open("a.json")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	require.PanicsWithValue(t, "read panic", func() {
+		_, _ = file.Read(make([]byte, 1))
+	})
+
+	fileSystem.ClearFaults()
+
+	_, err = fileSystem.ReadDir(".")
+	require.NoError(t, err)
+	require.Equal(t, "a", readFile(t, fileSystem, "a.json"))
+}
+
+func TestMemoryFileReads(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := NewMemoryFileSystem()
+	require.NoError(t, fileSystem.WriteFile("a.json", []byte("abc")))
+
+	errInjected := errors.New("injected")
+
+	reads := map[string]func(file File) error{
+		"Read": func(file File) error {
+			_, err := file.Read(make([]byte, 1))
+			return err
+		},
+		"ReadAt": func(file File) error {
+			_, err := file.(io.ReaderAt).ReadAt(make([]byte, 1), 1)
+			return err
+		},
+		"ReadByte": func(file File) error {
+			_, err := file.(io.ByteReader).ReadByte()
+			return err
+		},
+		"WriteTo": func(file File) error {
+			_, err := file.(io.WriterTo).WriteTo(io.Discard)
+			return err
+		},
+	}
+
+	for name, read := range reads {
+		file, err := fileSystem.Open("a.json")
+		require.NoError(t, err)
+		require.NoError(t, read(file), name)
+
+		fileSystem.InjectFault(OpRead, "a.json", Fault{Err: errInjected})
+		require.ErrorIs(t, read(file), errInjected, name)
+		fileSystem.ClearFaults()
+
+		require.NoError(t, file.Close())
+		require.ErrorIs(t, read(file), os.ErrClosed, name)
+	}
+
+	file, err := fileSystem.Open("a.json")
+	require.NoError(t, err)
+
+	offset, err := file.(io.Seeker).Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, offset)
+	require.NoError(t, file.Close())
+
+	_, err = file.(io.Seeker).Seek(0, io.SeekStart)
+	require.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestMemoryFileSystemConcurrency(t *testing.T) {
+	t.Parallel()
+
+	fileSystem := NewMemoryFileSystem()
+	wg := new(sync.WaitGroup)
+
+	for i := range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 100 {
+				name := fileSystem.Join("dir", strconv.Itoa(i), strconv.Itoa(j))
+				require.NoError(t, fileSystem.WriteFile(name, []byte(name)))
+				require.Equal(t, name, readFile(t, fileSystem, name))
+
+				_, err := fileSystem.ReadDir("dir")
+				require.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	entries, err := fileSystem.ReadDir("dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 10)
+}
@@ -37,3 +37,10 @@ func (o *osFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
 func (o *osFileSystem) Join(elem ...string) string {
 	return filepath.Join(elem...)
 }
+
+// IsOS reports whether the file system is the one returned by NewOsFileSystem,
+// so its paths are the paths of the operating system.
+func IsOS(fileSystem FileSystem) bool {
+	_, ok := fileSystem.(*osFileSystem)
+	return ok
+}
@@ -0,0 +1,186 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+var _ ContextFileSystem = (*retryFileSystem)(nil)
+
+// ContextFileSystem is a FileSystem whose operations may wait, e.g. between retries.
+// The crawler binds it to the context of the crawl, so that the waits are interrupted
+// by the cancellation, and receives its events through the hooks.
+type ContextFileSystem interface {
+	FileSystem
+
+	// WithContext returns the FileSystem bound to the context, reporting to the hooks.
+	WithContext(ctx context.Context, hooks Hooks) FileSystem
+}
+
+// Hooks receive the events of a ContextFileSystem. The hooks must be thread-safe.
+type Hooks struct {
+	// OnRetry is called before an operation on the path is retried after the error.
+	OnRetry func(op Op, name string, err error)
+}
+
+// RetryPolicy configures the retries of the failed operations. The zero value of
+// a field means the value of DefaultRetryPolicy.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts of an operation, including the first one.
+	Attempts int
+
+	// InitialBackoff is the delay before the first retry, and every next delay is
+	// Multiplier times longer, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction of a delay that is randomised: a delay d becomes
+	// a random one between d*(1-Jitter) and d. It must be between 0 and 1.
+	Jitter float64
+
+	// Retryable reports whether the operation failed with the error may be retried.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns the policy retrying transient errors 3 times.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:       4,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		Retryable:      IsTransient,
+	}
+}
+
+// IsTransient reports whether the error is likely to go away on its own: a timeout or
+// an error reporting itself as temporary. The errors of the system calls report it,
+// e.g. an interrupted call, a resource temporarily unavailable or too many open files.
+func IsTransient(err error) bool {
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// retryFileSystem decorates a FileSystem retrying the failed Open and ReadDir calls.
+type retryFileSystem struct {
+	base   FileSystem
+	policy RetryPolicy
+	ctx    context.Context
+	hooks  Hooks
+}
+
+// NewRetryFileSystem returns the FileSystem retrying the Open and ReadDir calls of base
+// according to the policy. Unless it is bound to a context with WithContext, as the
+// crawler does, its waits between the retries cannot be interrupted.
+// It panics if the jitter of the policy is not between 0 and 1.
+func NewRetryFileSystem(base FileSystem, policy RetryPolicy) *retryFileSystem {
+	defaults := DefaultRetryPolicy()
+
+	if policy.Attempts <= 0 {
+		policy.Attempts = defaults.Attempts
+	}
+
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaults.InitialBackoff
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaults.MaxBackoff
+	}
+
+	if policy.Multiplier <= 0 {
+		policy.Multiplier = defaults.Multiplier
+	}
+
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		panic("retry jitter must be between 0 and 1")
+	}
+
+	if policy.Retryable == nil {
+		policy.Retryable = defaults.Retryable
+	}
+
+	return &retryFileSystem{base: base, policy: policy, ctx: context.Background()}
+}
+
+// WithContext returns a copy of the file system whose waits are interrupted by the context.
+func (r *retryFileSystem) WithContext(ctx context.Context, hooks Hooks) FileSystem {
+	bound := *r
+	bound.ctx, bound.hooks = ctx, hooks
+
+	return &bound
+}
+
+// Open opens the file, retrying the failed attempts.
+func (r *retryFileSystem) Open(name string) (File, error) {
+	var file File
+
+	err := r.retry(OpOpen, name, func() (err error) {
+		file, err = r.base.Open(name)
+		return err
+	})
+
+	return file, err
+}
+
+// ReadDir reads the directory, retrying the failed attempts.
+func (r *retryFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+
+	err := r.retry(OpReadDir, name, func() (err error) {
+		entries, err = r.base.ReadDir(name)
+		return err
+	})
+
+	return entries, err
+}
+
+// Join joins the path elements using base.
+func (r *retryFileSystem) Join(elem ...string) string {
+	return r.base.Join(elem...)
+}
+
+// retry calls the operation until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or the context is cancelled, and returns its last error,
+// joined with the error of the context if it is cancelled.
+func (r *retryFileSystem) retry(op Op, name string, operation func() error) error {
+	backoff := r.policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= r.policy.Attempts || !r.policy.Retryable(err) {
+			return err
+		}
+
+		delay := time.Duration(float64(backoff) * (1 - r.policy.Jitter*rand.Float64()))
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (retries interrupted: %w)", err, r.ctx.Err())
+		case <-timer.C:
+		}
+
+		if r.hooks.OnRetry != nil {
+			r.hooks.OnRetry(op, name, err)
+		}
+
+		backoff = min(time.Duration(float64(backoff)*r.policy.Multiplier), r.policy.MaxBackoff)
+	}
+}
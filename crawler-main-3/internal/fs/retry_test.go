@@ -0,0 +1,229 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyFileSystem fails the first calls of every path with the error.
+type flakyFileSystem struct {
+	FileSystem
+	err      error
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyFileSystem) fail(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls[name]++
+	if f.calls[name] <= f.failures {
+		return &os.PathError{Op: "flaky", Path: name, Err: f.err}
+	}
+
+	return nil
+}
+
+func (f *flakyFileSystem) Open(name string) (File, error) {
+	if err := f.fail(name); err != nil {
+		return nil, err
+	}
+
+	return f.FileSystem.Open(name)
+}
+
+func (f *flakyFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	if err := f.fail(name); err != nil {
+		return nil, err
+	}
+
+	return f.FileSystem.ReadDir(name)
+}
+
+func TestRetryFileSystem(t *testing.T) {
+	t.Parallel()
+
+	memory := NewMemoryFileSystem()
+	require.NoError(t, memory.WriteFile("dir/a.json", []byte("a")))
+
+	flaky := func(err error) *flakyFileSystem {
+		return &flakyFileSystem{FileSystem: memory, err: err, failures: 2, calls: make(map[string]int)}
+	}
+
+	policy := RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5}
+
+	t.Run("transient", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu      sync.Mutex
+			retries []Op
+		)
+
+		fileSystem := NewRetryFileSystem(flaky(syscall.EAGAIN), policy).WithContext(context.Background(), Hooks{
+			OnRetry: func(op Op, _ string, err error) {
+				require.ErrorIs(t, err, syscall.EAGAIN)
+
+				mu.Lock()
+				retries = append(retries, op)
+				mu.Unlock()
+			},
+		})
+
+		_, err := fileSystem.ReadDir("dir")
+		require.NoError(t, err)
+		require.Equal(t, "a", readFile(t, fileSystem, "dir/a.json"))
+		require.Equal(t, []Op{OpReadDir, OpReadDir, OpOpen, OpOpen}, retries)
+	})
+
+	t.Run("out of attempts", func(t *testing.T) {
+		t.Parallel()
+
+		policy := policy
+		policy.Attempts = 2
+
+		_, err := NewRetryFileSystem(flaky(syscall.EINTR), policy).ReadDir("dir")
+		require.ErrorIs(t, err, syscall.EINTR)
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		t.Parallel()
+
+		base := flaky(os.ErrPermission)
+
+		_, err := NewRetryFileSystem(base, policy).Open("dir/a.json")
+		require.ErrorIs(t, err, os.ErrPermission)
+		require.Equal(t, 1, base.calls["dir/a.json"])
+	})
+
+	t.Run("classifier", func(t *testing.T) {
+		t.Parallel()
+
+		policy := policy
+		policy.Retryable = func(err error) bool {
+			return errors.Is(err, os.ErrPermission)
+		}
+
+		_, err := NewRetryFileSystem(flaky(os.ErrPermission), policy).Open("dir/a.json")
+		require.NoError(t, err)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		t.Parallel()
+
+		policy := policy
+		policy.InitialBackoff = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := NewRetryFileSystem(flaky(os.ErrDeadlineExceeded), policy).
+			WithContext(ctx, Hooks{}).
+			ReadDir("dir")
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []error{syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.EMFILE, os.ErrDeadlineExceeded} {
+		require.True(t, IsTransient(&os.PathError{Op: "open", Path: "a.json", Err: err}), err)
+	}
+
+	for _, err := range []error{syscall.ENOENT, os.ErrPermission, errors.New("broken")} {
+		require.False(t, IsTransient(&os.PathError{Op: "open", Path: "a.json", Err: err}), err)
+	}
+}
+
+func TestContextForwarding(t *testing.T) {
+	t.Parallel()
+
+	memory := NewMemoryFileSystem()
+	require.NoError(t, memory.WriteFile("dir/a.json", []byte("a")))
+
+	decorators := map[string]struct {
+		decorate func(base FileSystem) ContextFileSystem
+		// retries of the open, and of the listing of the parent describing the file
+		retries int
+	}{
+		"archive": {
+			decorate: func(base FileSystem) ContextFileSystem {
+				return NewArchiveFileSystem(base)
+			},
+			retries: 2,
+		},
+		"io/fs": {
+			decorate: func(base FileSystem) ContextFileSystem {
+				return FromFS(ToFS(base, "."))
+			},
+			retries: 4,
+		},
+	}
+
+	for name, decorator := range decorators {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			policy := RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond}
+			flaky := &flakyFileSystem{FileSystem: memory, err: syscall.EAGAIN, failures: 2, calls: make(map[string]int)}
+
+			var (
+				mu      sync.Mutex
+				retries int
+			)
+
+			fileSystem := decorator.decorate(NewRetryFileSystem(flaky, policy)).WithContext(context.Background(), Hooks{
+				OnRetry: func(Op, string, error) {
+					mu.Lock()
+					retries++
+					mu.Unlock()
+				},
+			})
+
+			require.Equal(t, "a", readFile(t, fileSystem, "dir/a.json"))
+			require.Equal(t, decorator.retries, retries)
+
+			policy.InitialBackoff = time.Hour
+			flaky = &flakyFileSystem{FileSystem: memory, err: syscall.EAGAIN, failures: 2, calls: make(map[string]int)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := decorator.decorate(NewRetryFileSystem(flaky, policy)).WithContext(ctx, Hooks{}).Open("dir/a.json")
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+	}
+
+	t.Run("memory latency", func(t *testing.T) {
+		t.Parallel()
+
+		latent := NewMemoryFileSystem()
+		require.NoError(t, latent.WriteFile("a.json", []byte("a")))
+		latent.InjectFault(OpOpen, "a.json", Fault{Latency: time.Hour})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := latent.WithContext(ctx, Hooks{}).Open("a.json")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("unbound base", func(t *testing.T) {
+		t.Parallel()
+
+		archives := NewArchiveFileSystem(NewOsFileSystem())
+		require.Same(t, archives, archives.WithContext(context.Background(), Hooks{}))
+	})
+}
@@ -0,0 +1,32 @@
+package fs
+
+import "os"
+
+var _ SymlinkFileSystem = (*osFileSystem)(nil)
+
+// SymlinkFileSystem is a FileSystem able to resolve symbolic links, which is required
+// to follow them. ReadDir of such a file system reports links with os.ModeSymlink
+// in the type of the entries and does not follow them.
+// Like FileSystem, its methods are thread-safe and may panic.
+type SymlinkFileSystem interface {
+	FileSystem
+
+	// Stat returns the file info of the named file, following symbolic links.
+	// The name of the returned info is the last element of name.
+	Stat(name string) (os.FileInfo, error)
+
+	// Readlink returns the destination of the named symbolic link.
+	// It fails if the named file is not a symbolic link.
+	Readlink(name string) (string, error)
+}
+
+// Stat returns the file info of the named file using os.Stat from the standard library.
+func (o *osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Readlink returns the destination of the named symbolic link using os.Readlink
+// from the standard library.
+func (o *osFileSystem) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
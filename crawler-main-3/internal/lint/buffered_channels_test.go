@@ -5,18 +5,23 @@ import (
 	"go/parser"
 	"go/token"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestNoBufferedChannels(t *testing.T) {
-	filesToCheck := []string{
-		"../filecrawler/crawler.go",
-		"../workerpool/pool.go",
-	}
+	// every non-test file of the internal packages
+	filesToCheck, err := filepath.Glob("../*/*.go")
+	require.NoError(t, err)
+	require.Contains(t, filesToCheck, filepath.Join("..", "filecrawler", "crawler.go"))
 
 	for _, relPath := range filesToCheck {
+		if strings.HasSuffix(relPath, "_test.go") {
+			continue
+		}
+
 		absPath, err := filepath.Abs(relPath)
 		require.NoError(t, err)
 
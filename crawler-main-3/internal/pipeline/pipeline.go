@@ -0,0 +1,495 @@
+// Package pipeline composes the workerpool stages into typed pipelines:
+//
+//	decoded := pipeline.Then(pipeline.From("list", list), "decode", 8, decode)
+//	sum, metrics, err := pipeline.Reduce(ctx, pipeline.Accumulate(decoded, "sum", 2, 0, add), combine)
+//
+// A pipeline only describes the stages, which are started by Reduce or Run, so one pipeline
+// may be run many times. The stages are connected by unbuffered channels closed by the stage
+// writing to them, the context of the run is shared by all stages, and every run reports
+// the metrics of its stages. The stages changing the type of the values are added by functions
+// instead of methods, for the reason given at workerpool.AccumulateByKey.
+package pipeline
+
+import (
+	"context"
+	"crawler/internal/workerpool"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source produces the values of a pipeline, passing them to emit until it returns false,
+// which means the run is cancelled. The returned error fails the run, unless it is
+// the error of the cancelled context.
+type Source[T any] func(ctx context.Context, emit func(T) bool) error
+
+// StageMetrics describes the work of a stage during a run.
+type StageMetrics struct {
+	Name string
+
+	// Workers is the number of workers of the stage, the maximum one for a scaled stage.
+	Workers int
+
+	// Items is the number of values the stage processed, or emitted for a source.
+	Items int64
+
+	// Errors is the number of values the stage failed to process.
+	Errors int64
+
+	// Busy is the total time the workers of the stage spent in its function,
+	// or the running time of a source.
+	Busy time.Duration
+}
+
+// Pipeline is a chain of stages producing values of type T.
+type Pipeline[T any] struct {
+	start func(r *run) <-chan T
+}
+
+// From returns the pipeline of a single source stage.
+func From[T any](name string, source Source[T]) *Pipeline[T] {
+	return &Pipeline[T]{start: func(r *run) <-chan T {
+		stage := r.stage(name, 1)
+		output := make(chan T)
+
+		go func() {
+			defer close(output)
+
+			start := time.Now()
+			err := source(r.ctx, func(value T) bool {
+				select {
+				case <-r.ctx.Done():
+					return false
+				case output <- value:
+					stage.observe(0, nil)
+					return true
+				}
+			})
+
+			stage.ran(time.Since(start), err)
+			r.fail(name, err)
+		}()
+
+		return drained(r, output)
+	}}
+}
+
+// FromSlice returns the pipeline of a source stage emitting the values in order.
+func FromSlice[T any](name string, values []T) *Pipeline[T] {
+	return From(name, func(_ context.Context, emit func(T) bool) error {
+		for _, value := range values {
+			if !emit(value) {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+// Then adds the stage transforming the values with the given number of workers,
+// see workerpool.Pool.Transform.
+func Then[T, R any](
+	p *Pipeline[T],
+	name string,
+	workers int,
+	transformer workerpool.Transformer[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, workers)
+
+		return drained(r, workerpool.New[T, R]().Transform(r.ctx, workers, input, measure(stage, transformer)))
+	}}
+}
+
+// ThenScaled adds the stage transforming the values with the number of workers adjusted
+// to the workload, see workerpool.Pool.TransformScaled.
+func ThenScaled[T, R any](
+	p *Pipeline[T],
+	name string,
+	scaling workerpool.Scaling,
+	transformer workerpool.Transformer[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, scaling.Max)
+
+		return drained(r, workerpool.New[T, R]().TransformScaled(r.ctx, scaling, input, measure(stage, transformer)))
+	}}
+}
+
+// Emitter passes the values produced from the current one to emit as they are produced,
+// and stops once emit returns false, which means the run is cancelled. Like a transformer,
+// it is invoked concurrently by the workers of its stage and must be thread-safe.
+type Emitter[T, R any] func(current T, emit func(R) bool)
+
+// ThenEmit adds the stage passing any number of values produced from every value to the next
+// stage with the given number of workers. The emitter is returned for the context of the run,
+// which is cancelled once the run fails or its output is abandoned, so that it stops waiting
+// with the other stages.
+// A worker waits in emit until the next stage takes the value, so the stage holds at most one
+// produced value of every worker. The time spent waiting is not counted as busy.
+func ThenEmit[T, R any](
+	p *Pipeline[T],
+	name string,
+	workers int,
+	emitter func(ctx context.Context) Emitter[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, workers)
+		output := make(chan R)
+
+		return emitted(r, output, workerpool.New[T, struct{}]().Transform(r.ctx, workers, input,
+			emitting(r, stage, output, emitter(r.ctx))))
+	}}
+}
+
+// ThenEmitScaled adds the stage passing the produced values like ThenEmit, with the number
+// of workers adjusted to the workload, see workerpool.Pool.TransformScaled.
+func ThenEmitScaled[T, R any](
+	p *Pipeline[T],
+	name string,
+	scaling workerpool.Scaling,
+	emitter func(ctx context.Context) Emitter[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, scaling.Max)
+		output := make(chan R)
+
+		return emitted(r, output, workerpool.New[T, struct{}]().TransformScaled(r.ctx, scaling, input,
+			emitting(r, stage, output, emitter(r.ctx))))
+	}}
+}
+
+// ThenErr adds the stage transforming the values with a transformer that may fail.
+// The failed values are dropped, and the first error of the stage fails the run.
+// A panic of the transformer is recovered into a *workerpool.PanicError,
+// see workerpool.Pool.TransformErr.
+func ThenErr[T, R any](
+	p *Pipeline[T],
+	name string,
+	workers int,
+	transformer workerpool.TransformerErr[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, workers)
+
+		output, wait := workerpool.New[T, R]().TransformErr(r.ctx, workers, input, func(current T) (R, error) {
+			start := time.Now()
+			result, err := transformer(current)
+			stage.observe(time.Since(start), err)
+
+			if err != nil {
+				// fail the whole run at once, the stage reports the error once it stops
+				r.cancel()
+			}
+
+			return result, err
+		})
+
+		r.add(func() {
+			for range output {
+			}
+
+			r.fail(name, wait())
+		})
+
+		return output
+	}}
+}
+
+// Accumulate adds the stage accumulating the values, sending the accumulated result of
+// every worker after each `every` values, see workerpool.Pool.AccumulateEvery.
+func Accumulate[T, R any](
+	p *Pipeline[T],
+	name string,
+	workers int,
+	every int,
+	accumulator workerpool.Accumulator[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, workers)
+
+		return drained(r, workerpool.New[T, R]().AccumulateEvery(r.ctx, workers, every, input,
+			func(current T, accum R) R {
+				start := time.Now()
+				defer func() {
+					stage.observe(time.Since(start), nil)
+				}()
+
+				return accumulator(current, accum)
+			},
+		))
+	}}
+}
+
+// AccumulateScaled adds the stage accumulating the values like Accumulate, with the number
+// of workers adjusted to the workload, see workerpool.Pool.AccumulateScaled.
+func AccumulateScaled[T, R any](
+	p *Pipeline[T],
+	name string,
+	scaling workerpool.Scaling,
+	every int,
+	accumulator workerpool.Accumulator[T, R],
+) *Pipeline[R] {
+	return &Pipeline[R]{start: func(r *run) <-chan R {
+		input := p.start(r)
+		stage := r.stage(name, scaling.Max)
+
+		return drained(r, workerpool.New[T, R]().AccumulateScaled(r.ctx, scaling, every, input,
+			func(current T, accum R) R {
+				start := time.Now()
+				defer func() {
+					stage.observe(time.Since(start), nil)
+				}()
+
+				return accumulator(current, accum)
+			},
+		))
+	}}
+}
+
+// AccumulateByKey adds the stage accumulating the batches of values by the keys of the values,
+// every key by exactly one worker, sending the map of every worker once the input is closed,
+// see workerpool.AccumulateByKey.
+func AccumulateByKey[T any, K comparable, R any](
+	p *Pipeline[[]T],
+	name string,
+	workers int,
+	key func(T) K,
+	accumulator workerpool.Accumulator[T, R],
+) *Pipeline[map[K]R] {
+	return &Pipeline[map[K]R]{start: func(r *run) <-chan map[K]R {
+		input := p.start(r)
+		stage := r.stage(name, workers)
+
+		return drained(r, workerpool.AccumulateByKey(r.ctx, workers, input, key,
+			func(current T, accum R) R {
+				start := time.Now()
+				defer func() {
+					stage.observe(time.Since(start), nil)
+				}()
+
+				return accumulator(current, accum)
+			},
+		))
+	}}
+}
+
+// Run starts the pipeline. The output must be read until it is closed, or the run abandoned,
+// and then wait must be called: it cancels the run, waits for all stages to stop, and returns
+// their metrics, in the order of the stages, and the errors of the run joined. If no stage
+// failed, the error is the one of the context.
+func Run[T any](ctx context.Context, p *Pipeline[T]) (<-chan T, func() ([]StageMetrics, error)) {
+	r := newRun(ctx)
+	output := p.start(r)
+
+	return output, func() ([]StageMetrics, error) {
+		return r.wait()
+	}
+}
+
+// Reduce runs the pipeline combining all its values in the calling goroutine, so the
+// combiner need not be thread-safe, and returns the result, the metrics and the error
+// of the run like Run. The result is the zero R if the run fails.
+func Reduce[T, R any](
+	ctx context.Context,
+	p *Pipeline[T],
+	combiner func(current T, accum R) R,
+) (R, []StageMetrics, error) {
+	var result R
+
+	output, wait := Run(ctx, p)
+
+	for current := range output {
+		result = combiner(current, result)
+	}
+
+	metrics, err := wait()
+	if err != nil {
+		var zero R
+		return zero, metrics, err
+	}
+
+	return result, metrics, nil
+}
+
+// run is a running pipeline.
+type run struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	stages []*stage
+	drains []func()
+	errs   []error
+}
+
+func newRun(parent context.Context) *run {
+	ctx, cancel := context.WithCancel(parent)
+	return &run{parent: parent, ctx: ctx, cancel: cancel}
+}
+
+// stage registers a stage, the stages are started from the source on.
+func (r *run) stage(name string, workers int) *stage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &stage{metrics: StageMetrics{Name: name, Workers: workers}}
+	r.stages = append(r.stages, s)
+
+	return s
+}
+
+// add registers the function waiting for a stage to stop.
+func (r *run) add(drain func()) {
+	r.drains = append(r.drains, drain)
+}
+
+// fail records the error of the stage and cancels the run. The errors caused by
+// the cancellation of the run are not recorded.
+func (r *run) fail(name string, err error) {
+	if err == nil || r.ctx.Err() != nil && errors.Is(err, r.ctx.Err()) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errs = append(r.errs, fmt.Errorf("stage %q: %w", name, err))
+	r.cancel()
+}
+
+func (r *run) wait() ([]StageMetrics, error) {
+	r.cancel()
+
+	// the stages stop on cancellation independently, wait for all of them
+	for i := len(r.drains) - 1; i >= 0; i-- {
+		r.drains[i]()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := make([]StageMetrics, 0, len(r.stages))
+	for _, s := range r.stages {
+		metrics = append(metrics, s.snapshot())
+	}
+
+	if len(r.errs) > 0 {
+		return metrics, errors.Join(r.errs...)
+	}
+
+	return metrics, r.parent.Err()
+}
+
+// drained registers the output of a stage to be drained by wait.
+func drained[T any](r *run, output <-chan T) <-chan T {
+	r.add(func() {
+		for range output {
+		}
+	})
+
+	return output
+}
+
+// emitted closes the output of an emitter stage once its workers have stopped,
+// and registers it to be drained by wait.
+func emitted[T any](r *run, output chan T, done <-chan struct{}) <-chan T {
+	go func() {
+		defer close(output)
+
+		for range done {
+		}
+	}()
+
+	return drained(r, output)
+}
+
+// stage collects the metrics of a stage.
+type stage struct {
+	mu      sync.Mutex
+	metrics StageMetrics
+}
+
+// observe records a processed value.
+func (s *stage) observe(busy time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metrics.Busy += busy
+
+	if err != nil {
+		s.metrics.Errors++
+	} else {
+		s.metrics.Items++
+	}
+}
+
+// ran records the running time and the error of a source.
+func (s *stage) ran(busy time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metrics.Busy += busy
+
+	if err != nil {
+		s.metrics.Errors++
+	}
+}
+
+func (s *stage) snapshot() StageMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.metrics
+}
+
+// measure wraps the transformer recording the metrics of the stage.
+func measure[T, R any](s *stage, transformer workerpool.Transformer[T, R]) workerpool.Transformer[T, R] {
+	return func(current T) R {
+		start := time.Now()
+		defer func() {
+			s.observe(time.Since(start), nil)
+		}()
+
+		return transformer(current)
+	}
+}
+
+// emitting wraps the emitter sending the produced values to the output of the stage,
+// and recording the metrics of the stage without the time spent waiting on the output.
+func emitting[T, R any](r *run, s *stage, output chan<- R, emitter Emitter[T, R]) workerpool.Transformer[T, struct{}] {
+	return func(current T) struct{} {
+		start := time.Now()
+
+		var waiting time.Duration
+
+		defer func() {
+			s.observe(time.Since(start)-waiting, nil)
+		}()
+
+		emitter(current, func(value R) bool {
+			sent := time.Now()
+			defer func() {
+				waiting += time.Since(sent)
+			}()
+
+			select {
+			case <-r.ctx.Done():
+				return false
+			case output <- value:
+				return true
+			}
+		})
+
+		return struct{}{}
+	}
+}
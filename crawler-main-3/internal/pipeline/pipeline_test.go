@@ -0,0 +1,256 @@
+package pipeline
+
+import (
+	"context"
+	"crawler/internal/workerpool"
+	"errors"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireNoLeaks waits for the number of goroutines to drop to the baseline,
+// as the goroutines of the stages may still be exiting after closing their outputs.
+func requireNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
+
+func numbers(n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i + 1
+	}
+
+	return values
+}
+
+func add(current, accum int) int {
+	return current + accum
+}
+
+func TestReduce(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+
+	squares := Then(FromSlice("numbers", numbers(100)), "square", 4, func(current int) int {
+		return current * current
+	})
+	sum := Accumulate(squares, "sum", 3, 0, add)
+
+	result, metrics, err := Reduce(ctx, sum, add)
+	require.NoError(t, err)
+	require.Equal(t, 338350, result)
+
+	require.Len(t, metrics, 3)
+	require.Equal(t, StageMetrics{Name: "numbers", Workers: 1, Items: 100, Busy: metrics[0].Busy}, metrics[0])
+	require.Equal(t, StageMetrics{Name: "square", Workers: 4, Items: 100, Busy: metrics[1].Busy}, metrics[1])
+	require.Equal(t, StageMetrics{Name: "sum", Workers: 3, Items: 100, Busy: metrics[2].Busy}, metrics[2])
+
+	// a pipeline may be run again
+	result, _, err = Reduce(ctx, sum, add)
+	require.NoError(t, err)
+	require.Equal(t, 338350, result)
+
+	scaled := AccumulateScaled(squares, "sum", workerpool.Scaling{Min: 1, Max: 3}, 0, add)
+
+	result, metrics, err = Reduce(ctx, scaled, add)
+	require.NoError(t, err)
+	require.Equal(t, 338350, result)
+	require.Equal(t, StageMetrics{Name: "sum", Workers: 3, Items: 100, Busy: metrics[2].Busy}, metrics[2])
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestAccumulateByKey(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+
+	digits := Then(FromSlice("numbers", numbers(100)), "digits", 2, func(current int) []int {
+		return []int{current / 10, current % 10}
+	})
+	counts := AccumulateByKey(digits, "count", 3, func(current int) int {
+		return current
+	}, func(_ int, accum int) int {
+		return accum + 1
+	})
+
+	result := make(map[int]int)
+
+	output, wait := Run(ctx, counts)
+	for partial := range output {
+		for digit, count := range partial {
+			result[digit] += count
+		}
+	}
+
+	metrics, err := wait()
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 19, 1: 20, 2: 20, 3: 20, 4: 20, 5: 20, 6: 20, 7: 20, 8: 20, 9: 20, 10: 1}, result)
+
+	require.Equal(t, []string{"numbers", "digits", "count"}, []string{metrics[0].Name, metrics[1].Name, metrics[2].Name})
+	require.EqualValues(t, 100, metrics[1].Items)
+	require.EqualValues(t, 200, metrics[2].Items)
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestRun(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+
+	formatted := ThenScaled(FromSlice("numbers", numbers(50)), "format", workerpool.Scaling{Min: 1, Max: 4},
+		strconv.Itoa)
+
+	output, wait := Run(ctx, formatted)
+
+	count := 0
+	for range output {
+		count++
+	}
+
+	metrics, err := wait()
+	require.NoError(t, err)
+	require.Equal(t, 50, count)
+	require.Equal(t, []string{"numbers", "format"}, []string{metrics[0].Name, metrics[1].Name})
+	require.Equal(t, 4, metrics[1].Workers)
+	require.EqualValues(t, 50, metrics[1].Items)
+
+	// abandoning the output cancels the run
+	output, wait = Run(ctx, formatted)
+	<-output
+
+	_, err = wait()
+	require.NoError(t, err)
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestThenEmit(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+
+	emitter := func(context.Context) Emitter[int, int] {
+		return func(current int, emit func(int) bool) {
+			for i := range current {
+				if !emit(i) {
+					return
+				}
+			}
+		}
+	}
+
+	for name, counted := range map[string]*Pipeline[int]{
+		"fixed":  ThenEmit(FromSlice("numbers", numbers(100)), "count", 3, emitter),
+		"scaled": ThenEmitScaled(FromSlice("numbers", numbers(100)), "count", workerpool.Scaling{Min: 1, Max: 3}, emitter),
+	} {
+		t.Run(name, func(t *testing.T) {
+			result, metrics, err := Reduce(ctx, Accumulate(counted, "sum", 2, 0, add), add)
+			require.NoError(t, err)
+			require.Equal(t, 166650, result)
+
+			require.Equal(t, []string{"numbers", "count", "sum"}, []string{metrics[0].Name, metrics[1].Name, metrics[2].Name})
+			require.Equal(t, 3, metrics[1].Workers)
+			require.EqualValues(t, 100, metrics[1].Items)
+			require.EqualValues(t, 5050, metrics[2].Items)
+
+			// abandoning the output stops the emitters
+			output, wait := Run(ctx, counted)
+			<-output
+
+			_, err = wait()
+			require.NoError(t, err)
+		})
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	errOdd := errors.New("odd")
+	errSource := errors.New("source")
+
+	t.Run("stage", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+
+		halves := ThenErr(FromSlice("numbers", numbers(100)), "half", 4, func(current int) (int, error) {
+			if current == 51 {
+				return 0, errOdd
+			}
+
+			return current / 2, nil
+		})
+
+		result, metrics, err := Reduce(ctx, halves, add)
+		require.ErrorIs(t, err, errOdd)
+		require.ErrorContains(t, err, `stage "half"`)
+		require.Zero(t, result)
+		require.EqualValues(t, 1, metrics[1].Errors)
+
+		requireNoLeaks(t, goroutines)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		halves := ThenErr(FromSlice("numbers", numbers(10)), "half", 2, func(current int) (int, error) {
+			if current == 5 {
+				panic("five")
+			}
+
+			return current / 2, nil
+		})
+
+		_, _, err := Reduce(ctx, halves, add)
+
+		var panicErr *workerpool.PanicError
+
+		require.ErrorAs(t, err, &panicErr)
+		require.Equal(t, "five", panicErr.Value)
+	})
+
+	t.Run("source", func(t *testing.T) {
+		source := From("numbers", func(ctx context.Context, emit func(int) bool) error {
+			emit(1)
+			return errSource
+		})
+
+		_, metrics, err := Reduce(ctx, Then(source, "double", 2, func(current int) int {
+			return current * 2
+		}), add)
+		require.ErrorIs(t, err, errSource)
+		require.EqualValues(t, 1, metrics[0].Items)
+		require.EqualValues(t, 1, metrics[0].Errors)
+	})
+
+	t.Run("cancelled source", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+
+		// an infinite source returning the error of its context
+		source := From("numbers", func(ctx context.Context, emit func(int) bool) error {
+			for i := 0; emit(i); i++ {
+			}
+
+			return ctx.Err()
+		})
+
+		output, wait := Run(ctx, source)
+		<-output
+		cancel()
+
+		_, err := wait()
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotContains(t, err.Error(), "stage")
+	})
+}
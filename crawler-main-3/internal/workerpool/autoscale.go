@@ -0,0 +1,465 @@
+package workerpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultScalingInterval is the default interval of the scaling decisions.
+	DefaultScalingInterval = 100 * time.Millisecond
+
+	// DefaultTargetWait is the default queue wait above which workers are added.
+	DefaultTargetWait = time.Millisecond
+
+	// DefaultMinUtilization is the default utilization below which workers are removed.
+	DefaultMinUtilization = 0.5
+)
+
+// Scaling configures a stage whose number of workers is adjusted to the workload.
+// Every Interval the stage looks at how long the items waited for a free worker
+// and how long the workers were busy processing them:
+//   - if the items waited longer than TargetWait on average while the workers were
+//     utilized at least MinUtilization, workers are added, about as many as the mean
+//     wait is longer than the mean latency of an item, but at most doubling them;
+//   - if the workers were utilized less than MinUtilization, a worker is removed.
+//
+// The time a worker is blocked sending its result counts as busy, so a slow consumer
+// is not mistaken for a lack of workers. The zero value of a field except Min and Max
+// means its default.
+type Scaling struct {
+	// Min and Max bound the number of workers, the stage starts with Min workers.
+	Min int
+	Max int
+
+	Interval       time.Duration
+	TargetWait     time.Duration
+	MinUtilization float64
+
+	// OnDecision, if set, receives every decision changing the number of workers.
+	// It is called from a single goroutine and blocks the scaling of the stage.
+	OnDecision func(Decision)
+}
+
+// Decision is a change of the number of workers of a scaled stage,
+// with the observations of the interval it is based on.
+type Decision struct {
+	Time time.Time
+	From int
+	To   int
+
+	// Wait is the mean time the items waited for a free worker.
+	Wait time.Duration
+
+	// Latency is the mean time a worker spent on an item, zero if no item was finished.
+	Latency time.Duration
+
+	// Utilization is the fraction of the interval the workers were busy.
+	Utilization float64
+}
+
+// window holds the observations of a scaled stage over one interval.
+type window struct {
+	elapsed time.Duration
+	workers int // the number of running workers at the end of the interval
+
+	waited time.Duration // the total wait of the items during the interval
+	items  int           // the number of items that waited, each counted in a single interval
+
+	busy     time.Duration // the total busy time of the workers
+	latency  time.Duration // the total latency of the finished items
+	finished int
+}
+
+// withDefaults validates the bounds and fills in the defaults.
+func (s Scaling) withDefaults() Scaling {
+	if s.Min <= 0 || s.Max < s.Min {
+		panic("scaling bounds must satisfy 0 < Min <= Max")
+	}
+
+	if s.Interval <= 0 {
+		s.Interval = DefaultScalingInterval
+	}
+
+	if s.TargetWait <= 0 {
+		s.TargetWait = DefaultTargetWait
+	}
+
+	if s.MinUtilization <= 0 {
+		s.MinUtilization = DefaultMinUtilization
+	}
+
+	return s
+}
+
+// decide returns the decision for the observations, To equals From if nothing changes.
+func (s Scaling) decide(w window) Decision {
+	decision := Decision{From: w.workers, To: w.workers}
+
+	if w.items > 0 {
+		decision.Wait = w.waited / time.Duration(w.items)
+	}
+
+	if w.finished > 0 {
+		decision.Latency = w.latency / time.Duration(w.finished)
+	}
+
+	if w.elapsed > 0 && w.workers > 0 {
+		decision.Utilization = min(float64(w.busy)/float64(w.elapsed)/float64(w.workers), 1)
+	}
+
+	switch {
+	case decision.Utilization < s.MinUtilization:
+		decision.To = max(w.workers-1, s.Min)
+	case decision.Wait > s.TargetWait:
+		add := 1
+		if decision.Latency > 0 {
+			add = max(int(decision.Wait/decision.Latency), 1)
+		}
+
+		decision.To = min(w.workers+min(add, w.workers), s.Max)
+	}
+
+	return decision
+}
+
+// heldItem is the item the dispatcher holds until a worker is free.
+type heldItem[T any] struct {
+	value   T
+	holding bool
+	since   time.Time // since when the wait is not counted yet
+	counted bool      // whether the item is counted in a window already
+}
+
+func (h *heldItem[T]) hold(value T, now time.Time) {
+	*h = heldItem[T]{value: value, holding: true, since: now}
+}
+
+// tick counts the wait of the held item up to now in the window ending now,
+// an item still waiting is counted in the first window only.
+func (h *heldItem[T]) tick(w *window, now time.Time) {
+	if !h.holding {
+		return
+	}
+
+	w.waited += now.Sub(h.since)
+	h.since = now
+
+	if !h.counted {
+		w.items++
+		h.counted = true
+	}
+}
+
+// handOff counts the rest of the wait of the held item, which a worker received now.
+func (h *heldItem[T]) handOff(w *window, now time.Time) {
+	h.tick(w, now)
+	*h = heldItem[T]{}
+}
+
+// scaleStats collects the busy time of the workers of a scaled stage.
+type scaleStats struct {
+	mu       sync.Mutex
+	busy     time.Duration
+	latency  time.Duration
+	finished int
+	working  map[*workerClock]struct{}
+}
+
+// workerClock measures the item a worker is busy with.
+type workerClock struct {
+	start time.Time // when the item was received
+	since time.Time // since when the busy time is not counted yet
+}
+
+func (s *scaleStats) begin(clock *workerClock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clock.start = time.Now()
+	clock.since = clock.start
+	s.working[clock] = struct{}{}
+}
+
+func (s *scaleStats) end(clock *workerClock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.busy += now.Sub(clock.since)
+	s.latency += now.Sub(clock.start)
+	s.finished++
+	delete(s.working, clock)
+}
+
+// take returns the observations since the previous call, counting the busy time
+// of the items still being processed up to now.
+func (s *scaleStats) take(w *window, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for clock := range s.working {
+		s.busy += now.Sub(clock.since)
+		clock.since = now
+	}
+
+	w.busy, w.latency, w.finished = s.busy, s.latency, s.finished
+	s.busy, s.latency, s.finished = 0, 0, 0
+}
+
+// scaledWorker is a worker of a scaled stage: it processes the items received from jobs,
+// measuring each with its own workerClock through stats, until jobs is closed, the worker
+// is retired or the context is cancelled.
+type scaledWorker[T any] func(jobs <-chan T, retire <-chan struct{}, stats *scaleStats)
+
+// runScaled starts the dispatcher of a scaled stage, which hands the items of input over
+// to the workers, measuring how long they wait, and starts and retires the workers.
+// The dispatcher and the workers are added to wg. It panics if the bounds are invalid.
+func runScaled[T any](ctx context.Context, scaling Scaling, input <-chan T, wg *sync.WaitGroup, worker scaledWorker[T]) {
+	scaling = scaling.withDefaults()
+
+	jobs := make(chan T)
+	retire := make(chan struct{})
+	stats := &scaleStats{working: make(map[*workerClock]struct{})}
+
+	start := func() {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			worker(jobs, retire, stats)
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		ticker := time.NewTicker(scaling.Interval)
+		defer ticker.Stop()
+
+		workers, target := 0, scaling.Min
+		for ; workers < target; workers++ {
+			start()
+		}
+
+		var (
+			held     heldItem[T]
+			observed window
+			lastTick = time.Now()
+		)
+
+		for {
+			var (
+				in      <-chan T
+				out     chan<- T
+				retired chan<- struct{}
+			)
+
+			if held.holding {
+				out = jobs
+			} else {
+				in = input
+			}
+
+			if workers > target {
+				retired = retire
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case current, ok := <-in:
+				if !ok {
+					return
+				}
+
+				held.hold(current, time.Now())
+			case out <- held.value:
+				held.handOff(&observed, time.Now())
+			case retired <- struct{}{}:
+				workers--
+			case now := <-ticker.C:
+				held.tick(&observed, now)
+
+				// the workers to retire stop once they are free, so the running ones may
+				// outnumber the target, and the decision starts from the running ones
+				observed.workers = workers
+				observed.elapsed = now.Sub(lastTick)
+				stats.take(&observed, now)
+
+				decision := scaling.decide(observed)
+				decision.Time = now
+
+				if decision.To != decision.From && scaling.OnDecision != nil {
+					scaling.OnDecision(decision)
+				}
+
+				target = decision.To
+
+				for ; workers < target; workers++ {
+					start()
+				}
+
+				observed = window{}
+				lastTick = now
+			}
+		}
+	}()
+}
+
+func (p *poolImpl[T, R]) TransformScaled(
+	ctx context.Context,
+	scaling Scaling,
+	input <-chan T,
+	transformer Transformer[T, R],
+) <-chan R {
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	runScaled(ctx, scaling, input, wg, func(jobs <-chan T, retire <-chan struct{}, stats *scaleStats) {
+		clock := new(workerClock)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-retire:
+				return
+			case current, ok := <-jobs:
+				if !ok {
+					return
+				}
+
+				stats.begin(clock)
+
+				select {
+				case <-ctx.Done():
+					return
+				case result <- transformer(current):
+				}
+
+				stats.end(clock)
+			}
+		}
+	})
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) AccumulateScaled(
+	ctx context.Context,
+	scaling Scaling,
+	every int,
+	input <-chan T,
+	accumulator Accumulator[T, R],
+) <-chan R {
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	runScaled(ctx, scaling, input, wg, func(jobs <-chan T, retire <-chan struct{}, stats *scaleStats) {
+		clock := new(workerClock)
+
+		var (
+			accum R
+			count int
+		)
+
+		send := func() bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case result <- accum:
+				var zero R
+				accum, count = zero, 0
+
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-retire:
+				// the accumulated result is not lost with the retired worker
+				if count > 0 {
+					send()
+				}
+
+				return
+			case current, ok := <-jobs:
+				if !ok {
+					send()
+					return
+				}
+
+				stats.begin(clock)
+
+				accum = accumulator(current, accum)
+				count++
+
+				if every > 0 && count >= every && !send() {
+					return
+				}
+
+				stats.end(clock)
+			}
+		}
+	})
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) WalkScaled(
+	ctx context.Context,
+	scaling Scaling,
+	start T,
+	order Order,
+	searcher Searcher[T],
+) <-chan Node[T] {
+	return walk(ctx, start, order, searcher, func(wg *sync.WaitGroup, jobs <-chan *Node[T], visit func(*Node[T]) bool) {
+		runScaled(ctx, scaling, jobs, wg, func(jobs <-chan *Node[T], retire <-chan struct{}, stats *scaleStats) {
+			clock := new(workerClock)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-retire:
+					return
+				case node, ok := <-jobs:
+					if !ok {
+						return
+					}
+
+					stats.begin(clock)
+
+					if !visit(node) {
+						return
+					}
+
+					stats.end(clock)
+				}
+			}
+		})
+	})
+}
+
+func (p *poolImpl[T, R]) ListScaled(ctx context.Context, scaling Scaling, start T, searcher Searcher[T]) {
+	for range p.WalkScaled(ctx, scaling, start, BreadthFirst, searcher) {
+	}
+}
@@ -0,0 +1,65 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is a panic recovered from a function run by the pool.
+type PanicError struct {
+	// Value is the value the function panicked with.
+	Value any
+
+	// Stack is the stack trace of the goroutine that panicked, see runtime/debug.Stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the value the function panicked with if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// protect calls f, converting its panic to a PanicError.
+func protect[R any](f func() (R, error)) (result R, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero R
+			result, err = zero, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return f()
+}
+
+// firstError keeps the first error of a stage and cancels the stage on it.
+type firstError struct {
+	cancel context.CancelFunc
+
+	once sync.Once
+	err  error
+}
+
+func newFirstError(ctx context.Context) (context.Context, *firstError) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &firstError{cancel: cancel}
+}
+
+func (e *firstError) fail(err error) {
+	e.once.Do(func() {
+		e.err = err
+		e.cancel()
+	})
+}
+
+// get returns the first error, it must be called after the stage is done.
+func (e *firstError) get() error {
+	e.once.Do(func() {})
+	return e.err
+}
@@ -0,0 +1,239 @@
+package workerpool
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// keyBatchSize is the number of items AccumulateByKey sends to a worker at once.
+const keyBatchSize = 64
+
+// AccumulateByKey accumulates the items received from the input channel by their keys with
+// the specified number of workers. The items arrive in batches, e.g. all values of a file.
+// Every key belongs to exactly one worker, chosen by the hash of the key, so the values of
+// a key are accumulated by a single worker in a map of its own, without locks. The items are
+// routed to the workers in batches by as many routers reading the input, which call key,
+// so key must be thread-safe. Once the input is closed, every worker sends
+// its map; the maps hold disjoint keys. Like Accumulate, nothing is sent once the context
+// is done. At least one worker is used.
+//
+// As methods cannot have type parameters in Go, this is a function rather than a Pool method.
+func AccumulateByKey[T any, K comparable, R any](
+	ctx context.Context,
+	workers int,
+	input <-chan []T,
+	key func(T) K,
+	accumulator Accumulator[T, R],
+) <-chan map[K]R {
+	workers = max(workers, 1)
+
+	type keyed struct {
+		key   K
+		value T
+	}
+
+	var (
+		result     = make(chan map[K]R)
+		partitions = make([]chan []keyed, workers)
+		routers    sync.WaitGroup
+		owners     sync.WaitGroup
+	)
+
+	for i := range partitions {
+		partitions[i] = make(chan []keyed)
+		owners.Add(1)
+
+		go func() {
+			defer owners.Done()
+
+			accum := make(map[K]R)
+
+			for batch := range partitions[i] {
+				for _, current := range batch {
+					accum[current.key] = accumulator(current.value, accum[current.key])
+				}
+			}
+
+			// the routers have stopped early, the map is incomplete
+			if ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+			case result <- accum:
+			}
+		}()
+	}
+
+	for range workers {
+		routers.Add(1)
+
+		go func() {
+			defer routers.Done()
+
+			// the items are sent to the workers in batches, saving a channel operation per item
+			batches := make([][]keyed, workers)
+
+			send := func(partition int) bool {
+				select {
+				case <-ctx.Done():
+					return false
+				case partitions[partition] <- batches[partition]:
+					batches[partition] = nil
+					return true
+				}
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case items, ok := <-input:
+					if !ok {
+						for partition, batch := range batches {
+							if len(batch) > 0 && !send(partition) {
+								return
+							}
+						}
+
+						return
+					}
+
+					for _, current := range items {
+						k := key(current)
+						partition := int(hashKey(k) % uint64(workers))
+						batches[partition] = append(batches[partition], keyed{key: k, value: current})
+
+						if len(batches[partition]) == keyBatchSize && !send(partition) {
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+
+		routers.Wait()
+
+		for _, partition := range partitions {
+			close(partition)
+		}
+
+		owners.Wait()
+	}()
+
+	return result
+}
+
+// hashKey hashes the key so that equal keys have equal hashes. The common key types are
+// hashed directly, the other ones by walking their values.
+func hashKey[K comparable](key K) uint64 {
+	h := newFNV()
+
+	switch key := any(key).(type) {
+	case string:
+		h.writeString(key)
+	case int:
+		h.writeUint(uint64(key))
+	case int64:
+		h.writeUint(uint64(key))
+	case int32:
+		h.writeUint(uint64(key))
+	case uint:
+		h.writeUint(uint64(key))
+	case uint64:
+		h.writeUint(key)
+	case uint32:
+		h.writeUint(uint64(key))
+	default:
+		hashValue(&h, reflect.ValueOf(&key).Elem())
+	}
+
+	// the low bits of FNV-1a depend only on the low bits of the bytes, and the workers are
+	// chosen by the low bits, so the high bits are folded in
+	return uint64(h ^ h>>32)
+}
+
+// fnv is the 64-bit FNV-1a hash. The keys only pick the workers, so a fast hash spreading
+// them evenly is enough, without the seed against collision attacks of hash/maphash.
+type fnv uint64
+
+const (
+	fnvOffset fnv = 14695981039346656037
+	fnvPrime  fnv = 1099511628211
+)
+
+func newFNV() fnv {
+	return fnvOffset
+}
+
+func (h *fnv) writeString(s string) {
+	for i := 0; i < len(s); i++ {
+		*h = (*h ^ fnv(s[i])) * fnvPrime
+	}
+}
+
+// writeUint writes the little-endian bytes of the value.
+func (h *fnv) writeUint(value uint64) {
+	for range 8 {
+		*h = (*h ^ fnv(value&0xff)) * fnvPrime
+		value >>= 8
+	}
+}
+
+func (h *fnv) writeFloat(value float64) {
+	// +0 and -0 are equal
+	if value == 0 {
+		value = 0
+	}
+
+	h.writeUint(math.Float64bits(value))
+}
+
+// hashValue writes the value of a comparable type to the hash, so that equal values
+// write the same bytes.
+func hashValue(h *fnv, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.String:
+		h.writeString(value.String())
+	case reflect.Bool:
+		if value.Bool() {
+			h.writeUint(1)
+		} else {
+			h.writeUint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		h.writeUint(uint64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		h.writeUint(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		h.writeFloat(value.Float())
+	case reflect.Complex64, reflect.Complex128:
+		h.writeFloat(real(value.Complex()))
+		h.writeFloat(imag(value.Complex()))
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Chan:
+		h.writeUint(uint64(value.Pointer()))
+	case reflect.Array:
+		for i := range value.Len() {
+			hashValue(h, value.Index(i))
+		}
+	case reflect.Struct:
+		for i := range value.NumField() {
+			hashValue(h, value.Field(i))
+		}
+	case reflect.Interface:
+		if value.IsNil() {
+			h.writeUint(0)
+			return
+		}
+
+		// values of distinct types may collide, which only costs balance
+		hashValue(h, value.Elem())
+	}
+}
@@ -2,6 +2,8 @@ package workerpool
 
 import (
 	"context"
+	"sync"
+	"time"
 )
 
 // Accumulator is a function type used to aggregate values of type T into a result of type R.
@@ -30,9 +32,56 @@ type Transformer[T, R any] func(current T) R
 //     captured variables remain consistent throughout recursive or hierarchical search paths.
 type Searcher[T any] func(parent T) []T
 
+// Predicate is a function type used to select elements of type T.
+// Like Transformer, it is invoked concurrently by multiple workers and must be thread-safe.
+type Predicate[T any] func(current T) bool
+
+// FlatMapper is a function type used to transform an element of type T to any number
+// of elements of type R. Like Transformer, it is invoked concurrently by multiple workers
+// and must be thread-safe.
+type FlatMapper[T, R any] func(current T) []R
+
+// Node is an element discovered by Walk.
+type Node[T any] struct {
+	Value T
+
+	// Depth is the number of searches between the start element and this one,
+	// the start element has depth 0.
+	Depth int
+
+	// Parent is the node whose search returned this one, nil for the start element.
+	Parent *Node[T]
+}
+
+// Order is the order Walk discovers the elements in.
+type Order int
+
+const (
+	// BreadthFirst searches the elements in the order they are discovered.
+	BreadthFirst Order = iota
+
+	// DepthFirst searches the most recently discovered elements first.
+	DepthFirst
+)
+
+// TransformerErr is a Transformer that may fail.
+type TransformerErr[T, R any] func(current T) (R, error)
+
+// AccumulatorErr is an Accumulator that may fail.
+type AccumulatorErr[T, R any] func(current T, accum R) (R, error)
+
+// SearcherErr is a Searcher that may fail.
+type SearcherErr[T any] func(parent T) ([]T, error)
+
 // Pool is the primary interface for managing worker pools, with support for three main
 // operations: Transform, Accumulate, and List. Each operation takes an input channel, applies
 // a transformation, accumulation, or list expansion, and returns the respective output.
+// The auxiliary operations Filter, FlatMap, Batch and OrderedTransform cover the rest
+// of the usual channel plumbing.
+//
+// All operations stop when the context is cancelled, and their output channels are closed
+// only after all their goroutines exit, so reading the output until it is closed guarantees
+// that nothing leaks.
 type Pool[T, R any] interface {
 	// Transform applies a transformer function to each item received from the input channel,
 	// with results sent to the output channel. Transform operates concurrently, utilizing the
@@ -44,18 +93,112 @@ type Pool[T, R any] interface {
 	// data subset.
 	Transform(ctx context.Context, workers int, input <-chan T, transformer Transformer[T, R]) <-chan R
 
+	// TransformScaled works like Transform, but adjusts the number of workers to the workload
+	// within the bounds of the scaling, see Scaling. It panics if the bounds are invalid.
+	TransformScaled(ctx context.Context, scaling Scaling, input <-chan T, transformer Transformer[T, R]) <-chan R
+
 	// Accumulate applies an accumulator function to the items received from the input channel,
 	// with results accumulated and sent to the output channel. The accumulator function must
 	// be thread-safe, as multiple workers concurrently update the accumulated result.
 	// The output channel will contain intermediate accumulated results as R
 	Accumulate(ctx context.Context, workers int, input <-chan T, accumulator Accumulator[T, R]) <-chan R
 
+	// AccumulateEvery works like Accumulate, but every worker also sends its accumulated
+	// result after each `every` items and then starts over from the zero value of R,
+	// so the output channel contains disjoint partial results that can be combined
+	// as they arrive. If every is not positive, it behaves exactly like Accumulate.
+	AccumulateEvery(
+		ctx context.Context,
+		workers int,
+		every int,
+		input <-chan T,
+		accumulator Accumulator[T, R],
+	) <-chan R
+
+	// AccumulateScaled works like AccumulateEvery, but adjusts the number of workers to
+	// the workload within the bounds of the scaling, see Scaling. A retired worker sends
+	// its accumulated result first, so even if every is not positive, the output channel
+	// may contain more results than Max. It panics if the bounds are invalid.
+	AccumulateScaled(
+		ctx context.Context,
+		scaling Scaling,
+		every int,
+		input <-chan T,
+		accumulator Accumulator[T, R],
+	) <-chan R
+
 	// List expands elements based on a searcher function, starting
 	// from the given element. The searcher function finds child elements for each parent,
 	// allowing exploration in a tree-like structure.
 	// The number of workers should be configured based on the workload, ensuring each worker
-	// independently processes assigned elements.
+	// independently processes assigned elements. The elements are scheduled like the ones
+	// of Walk, so a slow element only delays its own descendants. List returns once all
+	// elements have been searched or the context is cancelled.
 	List(ctx context.Context, workers int, start T, searcher Searcher[T])
+
+	// ListScaled works like List, but adjusts the number of workers to the workload within
+	// the bounds of the scaling, see Scaling. It panics if the bounds are invalid.
+	ListScaled(ctx context.Context, scaling Scaling, start T, searcher Searcher[T])
+
+	// Filter sends the items received from the input channel for which the predicate holds
+	// to the output channel, using the specified number of workers. The order of the items
+	// is not preserved.
+	Filter(ctx context.Context, workers int, input <-chan T, predicate Predicate[T]) <-chan T
+
+	// FlatMap applies the mapper to each item received from the input channel using
+	// the specified number of workers, and sends all the returned elements to the output
+	// channel. The elements of one item are sent in order, but may interleave with
+	// the elements of other items.
+	FlatMap(ctx context.Context, workers int, input <-chan T, mapper FlatMapper[T, R]) <-chan R
+
+	// Batch groups the items received from the input channel into batches of the given size.
+	// If maxDelay is positive, a batch is also sent once maxDelay has passed since its first
+	// item was received, even if it is not full. The last batch may be shorter.
+	// Batch panics if size is not positive.
+	Batch(ctx context.Context, size int, maxDelay time.Duration, input <-chan T) <-chan []T
+
+	// OrderedTransform works like Transform, but sends the results in the order of the items
+	// received from the input channel. A slow item delays the results of the following ones,
+	// and at most `workers` items are processed or waiting for their turn at a time.
+	OrderedTransform(ctx context.Context, workers int, input <-chan T, transformer Transformer[T, R]) <-chan R
+
+	// TransformErr works like Transform with a transformer that may fail. The first error
+	// cancels the whole stage, and the result of the failed item is not sent.
+	// A panic of the transformer is recovered into a *PanicError holding the stack trace.
+	// The returned function returns the first error, and must be called after
+	// the output channel is closed.
+	TransformErr(
+		ctx context.Context,
+		workers int,
+		input <-chan T,
+		transformer TransformerErr[T, R],
+	) (<-chan R, func() error)
+
+	// AccumulateErr works like Accumulate with an accumulator that may fail, handling the errors
+	// and panics like TransformErr. The workers send no results once the stage is cancelled.
+	AccumulateErr(
+		ctx context.Context,
+		workers int,
+		input <-chan T,
+		accumulator AccumulatorErr[T, R],
+	) (<-chan R, func() error)
+
+	// Walk expands elements like List, and sends every element to the output channel before
+	// it is searched, starting from the given element. The elements waiting to be searched
+	// are kept in a single queue, and every worker takes the next one as soon as it is free,
+	// so a slow element only delays its own descendants. With a single worker the elements
+	// are searched strictly in the given order, with more workers the order is approximate.
+	// At least one worker is used. The output channel is closed once all elements have been
+	// searched or the context is cancelled.
+	Walk(ctx context.Context, workers int, start T, order Order, searcher Searcher[T]) <-chan Node[T]
+
+	// WalkScaled works like Walk, but adjusts the number of workers to the workload within
+	// the bounds of the scaling, see Scaling. It panics if the bounds are invalid.
+	WalkScaled(ctx context.Context, scaling Scaling, start T, order Order, searcher Searcher[T]) <-chan Node[T]
+
+	// ListErr works like List with a searcher that may fail, handling the errors and panics
+	// like TransformErr, and returns the first error.
+	ListErr(ctx context.Context, workers int, start T, searcher SearcherErr[T]) error
 }
 
 type poolImpl[T, R any] struct{}
@@ -70,11 +213,77 @@ func (p *poolImpl[T, R]) Accumulate(
 	input <-chan T,
 	accumulator Accumulator[T, R],
 ) <-chan R {
-	panic("not implemented")
+	return p.AccumulateEvery(ctx, workers, 0, input, accumulator)
+}
+
+func (p *poolImpl[T, R]) AccumulateEvery(
+	ctx context.Context,
+	workers int,
+	every int,
+	input <-chan T,
+	accumulator Accumulator[T, R],
+) <-chan R {
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var (
+				accum R
+				count int
+			)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case current, ok := <-input:
+					if !ok {
+						select {
+						case <-ctx.Done():
+						case result <- accum:
+						}
+
+						return
+					}
+
+					accum = accumulator(current, accum)
+					count++
+
+					if every <= 0 || count < every {
+						continue
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case result <- accum:
+					}
+
+					var zero R
+					accum, count = zero, 0
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
 }
 
 func (p *poolImpl[T, R]) List(ctx context.Context, workers int, start T, searcher Searcher[T]) {
-	panic("not implemented")
+	// the elements are taken from the queue of Walk, so the workers never wait
+	// for the slowest element of a layer before searching the next one
+	for range p.Walk(ctx, workers, start, BreadthFirst, searcher) {
+	}
 }
 
 func (p *poolImpl[T, R]) Transform(
@@ -83,5 +292,531 @@ func (p *poolImpl[T, R]) Transform(
 	input <-chan T,
 	transformer Transformer[T, R],
 ) <-chan R {
-	panic("not implemented")
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case current, ok := <-input:
+					if !ok {
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case result <- transformer(current):
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) Filter(
+	ctx context.Context,
+	workers int,
+	input <-chan T,
+	predicate Predicate[T],
+) <-chan T {
+	return New[T, T]().FlatMap(ctx, workers, input, func(current T) []T {
+		if predicate(current) {
+			return []T{current}
+		}
+
+		return nil
+	})
+}
+
+func (p *poolImpl[T, R]) FlatMap(
+	ctx context.Context,
+	workers int,
+	input <-chan T,
+	mapper FlatMapper[T, R],
+) <-chan R {
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case current, ok := <-input:
+					if !ok {
+						return
+					}
+
+					for _, e := range mapper(current) {
+						select {
+						case <-ctx.Done():
+							return
+						case result <- e:
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) Batch(ctx context.Context, size int, maxDelay time.Duration, input <-chan T) <-chan []T {
+	if size <= 0 {
+		panic("workerpool: batch size must be positive")
+	}
+
+	result := make(chan []T)
+
+	go func() {
+		defer close(result)
+
+		var (
+			batch []T
+			timer *time.Timer
+			// expired is nil while there is no timer running
+			expired <-chan time.Time
+		)
+
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, expired = nil, nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case result <- batch:
+				batch = nil
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-expired:
+				if !flush() {
+					return
+				}
+			case current, ok := <-input:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+
+					return
+				}
+
+				batch = append(batch, current)
+
+				if len(batch) == 1 && maxDelay > 0 {
+					timer = time.NewTimer(maxDelay)
+					expired = timer.C
+				}
+
+				if len(batch) == size && !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) OrderedTransform(
+	ctx context.Context,
+	workers int,
+	input <-chan T,
+	transformer Transformer[T, R],
+) <-chan R {
+	type job struct {
+		current T
+		done    chan R
+	}
+
+	result := make(chan R)
+	jobs := make(chan job)
+	// pending receives the result channels of the jobs in the input order
+	pending := make(chan chan R)
+	wg := new(sync.WaitGroup)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(pending)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case current, ok := <-input:
+				if !ok {
+					return
+				}
+
+				j := job{current: current, done: make(chan R)}
+
+				select {
+				case <-ctx.Done():
+					return
+				case jobs <- j:
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case pending <- j.done:
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				select {
+				case <-ctx.Done():
+					return
+				case j.done <- transformer(j.current):
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		defer wg.Wait()
+
+		var queue []chan R
+
+		for in := pending; in != nil || len(queue) > 0; {
+			// receiving from the nil channel blocks until the queue has a head
+			var head chan R
+			if len(queue) > 0 {
+				head = queue[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case done, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+
+				queue = append(queue, done)
+			case r := <-head:
+				queue = queue[1:]
+
+				select {
+				case <-ctx.Done():
+					return
+				case result <- r:
+				}
+			}
+		}
+	}()
+
+	return result
+}
+
+func (p *poolImpl[T, R]) TransformErr(
+	ctx context.Context,
+	workers int,
+	input <-chan T,
+	transformer TransformerErr[T, R],
+) (<-chan R, func() error) {
+	ctx, first := newFirstError(ctx)
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case current, ok := <-input:
+					if !ok {
+						return
+					}
+
+					r, err := protect(func() (R, error) {
+						return transformer(current)
+					})
+					if err != nil {
+						first.fail(err)
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case result <- r:
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		defer first.cancel()
+
+		wg.Wait()
+	}()
+
+	return result, first.get
+}
+
+func (p *poolImpl[T, R]) AccumulateErr(
+	ctx context.Context,
+	workers int,
+	input <-chan T,
+	accumulator AccumulatorErr[T, R],
+) (<-chan R, func() error) {
+	ctx, first := newFirstError(ctx)
+	result := make(chan R)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var accum R
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case current, ok := <-input:
+					if !ok {
+						select {
+						case <-ctx.Done():
+						case result <- accum:
+						}
+
+						return
+					}
+
+					var err error
+
+					accum, err = protect(func() (R, error) {
+						return accumulator(current, accum)
+					})
+					if err != nil {
+						first.fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(result)
+		defer first.cancel()
+
+		wg.Wait()
+	}()
+
+	return result, first.get
+}
+
+func (p *poolImpl[T, R]) ListErr(ctx context.Context, workers int, start T, searcher SearcherErr[T]) error {
+	ctx, first := newFirstError(ctx)
+	defer first.cancel()
+
+	p.List(ctx, workers, start, func(parent T) []T {
+		children, err := protect(func() ([]T, error) {
+			return searcher(parent)
+		})
+		if err != nil {
+			first.fail(err)
+			return nil
+		}
+
+		return children
+	})
+
+	return first.get()
+}
+
+func (p *poolImpl[T, R]) Walk(
+	ctx context.Context,
+	workers int,
+	start T,
+	order Order,
+	searcher Searcher[T],
+) <-chan Node[T] {
+	return walk(ctx, start, order, searcher, func(wg *sync.WaitGroup, jobs <-chan *Node[T], visit func(*Node[T]) bool) {
+		for i := 0; i < max(workers, 1); i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for node := range jobs {
+					if !visit(node) {
+						return
+					}
+				}
+			}()
+		}
+	})
+}
+
+// walk runs the scheduler of Walk, whose jobs are searched by the workers started with
+// spawn and added to wg: every worker passes the nodes it receives to visit, and stops
+// once visit returns false, which means the walk is cancelled.
+func walk[T any](
+	ctx context.Context,
+	start T,
+	order Order,
+	searcher Searcher[T],
+	spawn func(wg *sync.WaitGroup, jobs <-chan *Node[T], visit func(node *Node[T]) bool),
+) <-chan Node[T] {
+	result := make(chan Node[T])
+	jobs := make(chan *Node[T])
+	found := make(chan []*Node[T])
+	wg := new(sync.WaitGroup)
+
+	// visit sends the node and the children found by its search to the scheduler
+	spawn(wg, jobs, func(node *Node[T]) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case result <- *node:
+		}
+
+		// the node may be received and sent while the context is already cancelled,
+		// as select picks any of the ready cases
+		if ctx.Err() != nil {
+			return false
+		}
+
+		values := searcher(node.Value)
+		children := make([]*Node[T], 0, len(values))
+
+		for _, value := range values {
+			children = append(children, &Node[T]{Value: value, Depth: node.Depth + 1, Parent: node})
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case found <- children:
+			return true
+		}
+	})
+
+	// the scheduler owns the queue, and the walk is over when the queue is empty
+	// and no worker is searching, as only the searching workers can add elements
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		queue := []*Node[T]{{Value: start}}
+		searching := 0
+
+		for len(queue) > 0 || searching > 0 {
+			var (
+				next *Node[T]
+				out  chan<- *Node[T]
+			)
+
+			if len(queue) > 0 {
+				out = jobs
+
+				if order == DepthFirst {
+					next = queue[len(queue)-1]
+				} else {
+					next = queue[0]
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- next:
+				searching++
+
+				if order == DepthFirst {
+					queue = queue[:len(queue)-1]
+				} else {
+					queue[0] = nil
+					queue = queue[1:]
+				}
+			case children := <-found:
+				searching--
+
+				if order == DepthFirst {
+					// the first child is searched first
+					for i := len(children) - 1; i >= 0; i-- {
+						queue = append(queue, children[i])
+					}
+				} else {
+					queue = append(queue, children...)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer close(result)
+		wg.Wait()
+	}()
+
+	return result
 }
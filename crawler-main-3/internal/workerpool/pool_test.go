@@ -1,9 +1,15 @@
 package workerpool
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"runtime"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -86,6 +92,22 @@ func transform(current TestType) TestType {
 	return current
 }
 
+// requireNoLeaks waits for the number of goroutines to drop to the baseline,
+// as the goroutines of the pool may still be exiting after closing the output.
+func requireNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
+
 func TestInternalState(t *testing.T) {
 	require.Zero(t, unsafe.Sizeof(poolImpl[int, int]{}))
 }
@@ -114,6 +136,36 @@ func TestList(t *testing.T) {
 	require.LessOrEqual(t, runtime.NumGoroutine(), 3)
 }
 
+func TestListWithoutLayerBarrier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp := New[int, int]()
+	released := make(chan struct{})
+
+	// 1 is slow until the grandchild 3 of the root is searched through 2,
+	// which a search waiting for the whole layer of 1 and 2 would never reach
+	wp.List(ctx, 2, 0, func(parent int) []int {
+		switch parent {
+		case 0:
+			return []int{1, 2}
+		case 1:
+			select {
+			case <-released:
+			case <-ctx.Done():
+			}
+		case 2:
+			return []int{3}
+		case 3:
+			close(released)
+		}
+
+		return nil
+	})
+
+	require.NoError(t, ctx.Err())
+}
+
 func TestListContextDone(t *testing.T) {
 	t.Run("end", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
@@ -297,6 +349,99 @@ func TestAccumulateContextDone(t *testing.T) {
 	})
 }
 
+func TestAccumulateEvery(t *testing.T) {
+	ctx := context.Background()
+	wp := New[TestType, TestType]()
+
+	s := make([]TestType, 0, 10)
+	for i := 0; i < 10; i++ {
+		s = append(s, TestType{Data: 1})
+	}
+
+	add := func(current TestType, accum TestType) TestType {
+		accum.Data += current.Data
+		return accum
+	}
+
+	result := collect(wp.AccumulateEvery(ctx, 1, 3, generate(s), add))
+	require.Equal(t, []TestType{{Data: 3}, {Data: 3}, {Data: 3}, {Data: 1}}, result)
+
+	result = collect(wp.AccumulateEvery(ctx, 1, 0, generate(s), add))
+	require.Equal(t, []TestType{{Data: 10}}, result)
+}
+
+func TestAccumulateByKey(t *testing.T) {
+	ctx := context.Background()
+
+	s := make([][]TestType, 0, 100)
+	for i := 0; i < 100; i++ {
+		batch := make([]TestType, 0, 10)
+		for j := 0; j < 10; j++ {
+			batch = append(batch, TestType{Data: int64(i*10 + j)})
+		}
+
+		s = append(s, batch)
+	}
+
+	key := func(current TestType) int64 {
+		return current.Data % 10
+	}
+
+	add := func(current TestType, accum TestAccumulator) TestAccumulator {
+		accum.Sum += current.Data
+		return accum
+	}
+
+	partitions := collect(AccumulateByKey(ctx, 4, generate(s), key, add))
+	require.Len(t, partitions, 4)
+
+	result := make(map[int64]TestAccumulator)
+
+	for _, partition := range partitions {
+		for k, v := range partition {
+			_, ok := result[k]
+			require.False(t, ok, "key %d reduced by two workers", k)
+
+			result[k] = v
+		}
+	}
+
+	require.Len(t, result, 10)
+
+	for k := int64(0); k < 10; k++ {
+		// k + (k+10) + ... + (k+990)
+		require.Equal(t, TestAccumulator{Sum: 100*k + 49500}, result[k])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	require.Empty(t, collect(AccumulateByKey(ctx, 4, generate(s), key, add)))
+}
+
+func TestHashKey(t *testing.T) {
+	type point struct {
+		X, Y  float64
+		Label any
+	}
+
+	require.Equal(t, hashKey(point{X: 0, Label: "a"}), hashKey(point{X: math.Copysign(0, -1), Label: "a"}))
+	require.NotEqual(t, hashKey(point{X: 1, Label: "a"}), hashKey(point{X: 2, Label: "a"}))
+	require.Equal(t, hashKey([2]string{"a", "b"}), hashKey([2]string{"a", "b"}))
+	require.Equal(t, hashKey("key"), hashKey("key"))
+	require.NotEqual(t, hashKey(1), hashKey(2))
+
+	// keys whose bytes differ in their high bits only are spread as well
+	counts := make([]int, 8)
+	for i := range 800 {
+		counts[hashKey(i%32*8|i/32*8<<8)%8]++
+	}
+
+	for _, count := range counts {
+		require.Greater(t, count, 50)
+	}
+}
+
 func TestTransform(t *testing.T) {
 	ctx := context.Background()
 	wp := New[TestType, TestType]()
@@ -375,3 +520,811 @@ func TestTransformContextDone(t *testing.T) {
 		cancel()
 	})
 }
+
+func TestFilter(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	ctx := context.Background()
+	wp := New[TestType, TestType]()
+
+	s := make([]TestType, 0, 100)
+	for i := 0; i < 100; i++ {
+		s = append(s, TestType{Data: int64(i)})
+	}
+
+	result := collect(wp.Filter(ctx, 10, generate(s), func(current TestType) bool {
+		return current.Data%3 == 0
+	}))
+
+	slices.SortFunc(result, func(a, b TestType) int {
+		return cmp.Compare(a.Data, b.Data)
+	})
+
+	require.Len(t, result, 34)
+
+	for i, e := range result {
+		require.EqualValues(t, 3*i, e.Data)
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestFlatMap(t *testing.T) {
+	ctx := context.Background()
+	wp := New[TestType, int64]()
+
+	s := make([]TestType, 0, 10)
+	for i := 0; i < 10; i++ {
+		s = append(s, TestType{Data: int64(i)})
+	}
+
+	result := collect(wp.FlatMap(ctx, 4, generate(s), func(current TestType) []int64 {
+		values := make([]int64, 0, current.Data)
+		for i := int64(0); i < current.Data; i++ {
+			values = append(values, current.Data)
+		}
+
+		return values
+	}))
+
+	require.Len(t, result, 45)
+
+	counts := make(map[int64]int64)
+	for _, e := range result {
+		counts[e]++
+	}
+
+	for value, count := range counts {
+		require.Equal(t, value, count)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	t.Run("size", func(t *testing.T) {
+		result := collect(wp.Batch(ctx, 3, 0, generate([]int{1, 2, 3, 4, 5, 6, 7})))
+		require.Equal(t, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}, result)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		in := make(chan int)
+		out := wp.Batch(ctx, 10, 50*time.Millisecond, in)
+
+		go func() {
+			defer close(in)
+
+			in <- 1
+			in <- 2
+			time.Sleep(200 * time.Millisecond)
+			in <- 3
+		}()
+
+		start := time.Now()
+
+		require.Equal(t, []int{1, 2}, <-out)
+		require.Less(t, time.Since(start), 150*time.Millisecond)
+		require.Equal(t, []int{3}, <-out)
+
+		_, ok := <-out
+		require.False(t, ok)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		out := wp.Batch(ctx, 10, time.Hour, make(chan int))
+
+		cancel()
+
+		_, ok := <-out
+		require.False(t, ok)
+	})
+
+	require.Panics(t, func() {
+		wp.Batch(ctx, 0, 0, generate([]int{}))
+	})
+}
+
+func TestOrderedTransform(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	ctx := context.Background()
+	wp := New[TestType, TestType]()
+
+	s := make([]TestType, 0, 50)
+	for i := 0; i < 50; i++ {
+		s = append(s, TestType{Data: int64(i)})
+	}
+
+	start := time.Now()
+
+	result := collect(wp.OrderedTransform(ctx, 10, generate(s), func(current TestType) TestType {
+		time.Sleep(time.Duration(rand.IntN(20)) * time.Millisecond)
+
+		current.Data++
+		return current
+	}))
+
+	// 50 items of 10ms on average, processed by 10 workers
+	require.Less(t, time.Since(start), 400*time.Millisecond)
+	require.Len(t, result, 50)
+
+	for i, e := range result {
+		require.EqualValues(t, i+1, e.Data)
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestOrderedTransformContextDone(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+	})
+
+	wp := New[TestType, TestType]()
+
+	in := make(chan TestType)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case in <- TestType{Data: int64(i)}:
+			}
+		}
+	}()
+
+	out := wp.OrderedTransform(ctx, 5, in, func(current TestType) TestType {
+		time.Sleep(time.Millisecond)
+		return current
+	})
+
+	for i := 0; i < 10; i++ {
+		require.EqualValues(t, i, (<-out).Data)
+	}
+
+	cancel()
+
+	for range out {
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestTransformErr(t *testing.T) {
+	ctx := context.Background()
+	wp := New[TestType, TestType]()
+	errFailed := errors.New("failed")
+
+	s := make([]TestType, 0, 10)
+	for i := 0; i < 10; i++ {
+		s = append(s, TestType{Data: int64(i)})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		out, wait := wp.TransformErr(ctx, 4, generate(s), func(current TestType) (TestType, error) {
+			current.Data++
+			return current, nil
+		})
+
+		require.Len(t, collect(out), 10)
+		require.NoError(t, wait())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		in := make(chan TestType)
+
+		go func() {
+			defer close(in)
+
+			for i := 0; ; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case in <- TestType{Data: int64(i)}:
+				}
+			}
+		}()
+
+		out, wait := wp.TransformErr(ctx, 4, in, func(current TestType) (TestType, error) {
+			if current.Data == 100 {
+				return current, errFailed
+			}
+
+			return current, nil
+		})
+
+		for range out {
+		}
+
+		require.ErrorIs(t, wait(), errFailed)
+
+		cancel()
+		requireNoLeaks(t, goroutines)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		out, wait := wp.TransformErr(ctx, 4, generate(s), func(current TestType) (TestType, error) {
+			if current.Data == 5 {
+				panic(errFailed)
+			}
+
+			return current, nil
+		})
+
+		for range out {
+		}
+
+		var panicErr *PanicError
+
+		err := wait()
+		require.ErrorAs(t, err, &panicErr)
+		require.ErrorIs(t, err, errFailed)
+		require.Contains(t, string(panicErr.Stack), "TestTransformErr")
+	})
+}
+
+func TestAccumulateErr(t *testing.T) {
+	ctx := context.Background()
+	wp := New[TestType, TestType]()
+
+	s := make([]TestType, 0, 10)
+	for i := 0; i < 10; i++ {
+		s = append(s, TestType{Data: int64(i)})
+	}
+
+	out, wait := wp.AccumulateErr(ctx, 3, generate(s), func(current, accum TestType) (TestType, error) {
+		accum.Data += current.Data
+		return accum, nil
+	})
+
+	var sum int64
+	for e := range out {
+		sum += e.Data
+	}
+
+	require.NoError(t, wait())
+	require.EqualValues(t, 45, sum)
+
+	out, wait = wp.AccumulateErr(ctx, 3, generate(s), func(current, accum TestType) (TestType, error) {
+		if current.Data == 7 {
+			panic("seven")
+		}
+
+		return accum, nil
+	})
+
+	for range out {
+	}
+
+	var panicErr *PanicError
+
+	require.ErrorAs(t, wait(), &panicErr)
+	require.Equal(t, "seven", panicErr.Value)
+}
+
+func TestListErr(t *testing.T) {
+	ctx := context.Background()
+	wp := New[int, int]()
+	errDeep := errors.New("too deep")
+
+	var calls atomic.Int64
+
+	err := wp.ListErr(ctx, 4, 0, func(parent int) ([]int, error) {
+		calls.Add(1)
+
+		if parent == 3 {
+			return nil, errDeep
+		}
+
+		return []int{parent + 1, parent + 1}, nil
+	})
+
+	require.ErrorIs(t, err, errDeep)
+	require.LessOrEqual(t, calls.Load(), int64(1+2+4+8))
+
+	require.NoError(t, wp.ListErr(ctx, 4, 0, func(parent int) ([]int, error) {
+		if parent == 3 {
+			return nil, nil
+		}
+
+		return []int{parent + 1}, nil
+	}))
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	// a complete ternary tree of depth 3, numbered level by level
+	searcher := func(parent int) []int {
+		if parent >= 13 {
+			return nil
+		}
+
+		return []int{3*parent + 1, 3*parent + 2, 3*parent + 3}
+	}
+
+	depth := func(value int) int {
+		d := 0
+		for ; value > 0; value = (value - 1) / 3 {
+			d++
+		}
+
+		return d
+	}
+
+	values := func(nodes []Node[int]) []int {
+		result := make([]int, 0, len(nodes))
+		for _, node := range nodes {
+			result = append(result, node.Value)
+		}
+
+		return result
+	}
+
+	t.Run("nodes", func(t *testing.T) {
+		goroutines := runtime.NumGoroutine()
+		nodes := collect(wp.Walk(ctx, 4, 0, BreadthFirst, searcher))
+
+		require.Len(t, nodes, 40)
+		require.ElementsMatch(t, values(nodes), slices.Collect(func(yield func(int) bool) {
+			for i := 0; i < 40 && yield(i); i++ {
+			}
+		}))
+
+		for _, node := range nodes {
+			require.Equal(t, depth(node.Value), node.Depth)
+
+			if node.Value == 0 {
+				require.Nil(t, node.Parent)
+				continue
+			}
+
+			require.NotNil(t, node.Parent)
+			require.Equal(t, (node.Value-1)/3, node.Parent.Value)
+			require.Equal(t, node.Depth-1, node.Parent.Depth)
+		}
+
+		requireNoLeaks(t, goroutines)
+	})
+
+	t.Run("breadth first", func(t *testing.T) {
+		nodes := values(collect(wp.Walk(ctx, 1, 0, BreadthFirst, searcher)))
+
+		require.True(t, slices.IsSorted(nodes))
+	})
+
+	t.Run("depth first", func(t *testing.T) {
+		var preorder []int
+
+		var visit func(value int)
+		visit = func(value int) {
+			preorder = append(preorder, value)
+			for _, child := range searcher(value) {
+				visit(child)
+			}
+		}
+		visit(0)
+
+		require.Equal(t, preorder, values(collect(wp.Walk(ctx, 1, 0, DepthFirst, searcher))))
+	})
+}
+
+func TestWalkNoBarrier(t *testing.T) {
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	// the first child is slow, the second one starts a chain of fast ones
+	searcher := func(parent int) []int {
+		switch {
+		case parent == 0:
+			return []int{-1, 1}
+		case parent == -1:
+			time.Sleep(sleepTime)
+			return nil
+		case parent < 10:
+			return []int{parent + 1}
+		default:
+			return nil
+		}
+	}
+
+	start := time.Now()
+
+	for node := range wp.Walk(ctx, 2, 0, BreadthFirst, searcher) {
+		if node.Value == 10 {
+			require.Less(t, time.Since(start), sleepTime/2)
+			require.Equal(t, 10, node.Depth)
+		}
+	}
+
+	require.GreaterOrEqual(t, time.Since(start), sleepTime)
+}
+
+func TestWalkContextDone(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := New[int, int]()
+
+	// an infinite tree
+	out := wp.Walk(ctx, 4, 0, DepthFirst, func(parent int) []int {
+		return []int{parent + 1, parent + 1}
+	})
+
+	for range 100 {
+		<-out
+	}
+
+	cancel()
+
+	for range out {
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestScalingDecide(t *testing.T) {
+	scaling := Scaling{Min: 2, Max: 16}.withDefaults()
+
+	tests := []struct {
+		name     string
+		observed window
+		workers  int
+	}{
+		{
+			name:     "idle",
+			observed: window{elapsed: 100, workers: 4},
+			workers:  3,
+		},
+		{
+			name:     "idle at min",
+			observed: window{elapsed: 100, workers: 2},
+			workers:  2,
+		},
+		{
+			name:     "busy without wait",
+			observed: window{elapsed: 100, workers: 4, busy: 400, items: 10, latency: 400, finished: 10},
+			workers:  4,
+		},
+		{
+			name: "backlog without finished items",
+			observed: window{
+				elapsed: time.Second, workers: 4, busy: 4 * time.Second, waited: time.Second, items: 1,
+			},
+			workers: 5,
+		},
+		{
+			name: "backlog",
+			observed: window{
+				elapsed: time.Second, workers: 4, busy: 4 * time.Second,
+				waited: 30 * time.Millisecond, items: 10, latency: 15 * time.Millisecond, finished: 10,
+			},
+			workers: 6,
+		},
+		{
+			name: "backlog at most doubles",
+			observed: window{
+				elapsed: time.Second, workers: 4, busy: 4 * time.Second,
+				waited: time.Second, items: 10, latency: 10 * time.Millisecond, finished: 10,
+			},
+			workers: 8,
+		},
+		{
+			name: "backlog at max",
+			observed: window{
+				elapsed: time.Second, workers: 12, busy: 12 * time.Second,
+				waited: time.Second, items: 10, latency: 10 * time.Millisecond, finished: 10,
+			},
+			workers: 16,
+		},
+		{
+			name: "slow consumer",
+			observed: window{
+				elapsed: time.Second, workers: 4, busy: time.Second,
+				waited: time.Second, items: 10, latency: 10 * time.Millisecond, finished: 10,
+			},
+			workers: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := scaling.decide(test.observed)
+
+			require.Equal(t, test.observed.workers, decision.From)
+			require.Equal(t, test.workers, decision.To)
+		})
+	}
+
+	require.Panics(t, func() {
+		Scaling{Min: 0, Max: 1}.withDefaults()
+	})
+	require.Panics(t, func() {
+		Scaling{Min: 2, Max: 1}.withDefaults()
+	})
+}
+
+func TestHeldItemWait(t *testing.T) {
+	start := time.Now()
+
+	var (
+		held          heldItem[int]
+		first, second window
+	)
+
+	held.tick(&first, start)
+	require.Zero(t, first)
+
+	// the item waits across the tick, its wait is split but it is counted once
+	held.hold(1, start)
+	held.tick(&first, start.Add(30*time.Millisecond))
+	held.handOff(&second, start.Add(40*time.Millisecond))
+
+	require.Equal(t, window{waited: 30 * time.Millisecond, items: 1}, first)
+	require.Equal(t, window{waited: 10 * time.Millisecond}, second)
+	require.False(t, held.holding)
+
+	held.hold(2, start.Add(50*time.Millisecond))
+	held.handOff(&second, start.Add(60*time.Millisecond))
+
+	require.Equal(t, window{waited: 20 * time.Millisecond, items: 1}, second)
+}
+
+func TestTransformScaled(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	var (
+		mu        sync.Mutex
+		decisions []Decision
+	)
+
+	workers := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(decisions) == 0 {
+			return 1
+		}
+
+		return decisions[len(decisions)-1].To
+	}
+
+	scaling := Scaling{
+		Min:      1,
+		Max:      4,
+		Interval: 10 * time.Millisecond,
+		OnDecision: func(decision Decision) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			decisions = append(decisions, decision)
+		},
+	}
+
+	// the items are blocked until released, so a backlog builds up
+	release := make(chan struct{})
+	input := make(chan int)
+
+	var running, maxRunning atomic.Int64
+
+	out := wp.TransformScaled(ctx, scaling, input, func(current int) int {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		<-release
+
+		return current * 2
+	})
+
+	results := make(chan []int)
+
+	go func() {
+		results <- collect(out)
+	}()
+
+	go func() {
+		for i := range 100 {
+			input <- i
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		return workers() == 4
+	}, 5*time.Second, time.Millisecond)
+
+	close(release)
+
+	// the input stays open but idle, so the workers are removed
+	require.Eventually(t, func() bool {
+		return workers() == 1
+	}, 5*time.Second, time.Millisecond)
+
+	close(input)
+
+	got := <-results
+	slices.Sort(got)
+
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i * 2
+	}
+
+	require.Equal(t, want, got)
+	require.EqualValues(t, 4, maxRunning.Load())
+
+	mu.Lock()
+	for _, decision := range decisions {
+		require.NotEqual(t, decision.From, decision.To)
+		require.GreaterOrEqual(t, decision.To, scaling.Min)
+		require.LessOrEqual(t, decision.To, scaling.Max)
+	}
+	mu.Unlock()
+
+	requireNoLeaks(t, goroutines)
+}
+
+func TestTransformScaledContextDone(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := New[int, int]()
+
+	input := make(chan int)
+	out := wp.TransformScaled(ctx, Scaling{Min: 2, Max: 8}, input, func(current int) int {
+		return current
+	})
+
+	input <- 1
+	<-out
+	cancel()
+
+	for range out {
+	}
+
+	requireNoLeaks(t, goroutines)
+}
+
+// observedScaling records the decisions of the scaling, and returns the function
+// reporting the current number of workers.
+func observedScaling(scaling Scaling) (Scaling, func() int) {
+	var (
+		mu      sync.Mutex
+		workers = scaling.Min
+	)
+
+	scaling.OnDecision = func(decision Decision) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		workers = decision.To
+	}
+
+	return scaling, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return workers
+	}
+}
+
+func TestAccumulateScaled(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	scaling, workers := observedScaling(Scaling{Min: 1, Max: 4, Interval: 10 * time.Millisecond})
+
+	// the items are blocked until released, so a backlog builds up
+	release := make(chan struct{})
+	input := make(chan int)
+
+	out := wp.AccumulateScaled(ctx, scaling, 0, input, func(current, accum int) int {
+		<-release
+		return accum + current
+	})
+
+	results := make(chan []int)
+
+	go func() {
+		results <- collect(out)
+	}()
+
+	go func() {
+		for range 100 {
+			input <- 1
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		return workers() == 4
+	}, 5*time.Second, time.Millisecond)
+
+	close(release)
+
+	// the retired workers send their results while the input stays open but idle
+	require.Eventually(t, func() bool {
+		return workers() == 1
+	}, 5*time.Second, time.Millisecond)
+
+	close(input)
+
+	total := 0
+	for _, partial := range <-results {
+		total += partial
+	}
+
+	require.Equal(t, 100, total)
+	requireNoLeaks(t, goroutines)
+
+	require.Panics(t, func() {
+		wp.AccumulateScaled(ctx, Scaling{Min: 2, Max: 1}, 0, input, func(current, accum int) int {
+			return accum
+		})
+	})
+}
+
+func TestWalkScaled(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	ctx := context.Background()
+	wp := New[int, int]()
+
+	scaling, workers := observedScaling(Scaling{Min: 1, Max: 4, Interval: 10 * time.Millisecond})
+
+	// the children of the root are blocked until released, so a backlog builds up
+	release := make(chan struct{})
+	children := make([]int, 100)
+
+	for i := range children {
+		children[i] = i + 1
+	}
+
+	out := wp.WalkScaled(ctx, scaling, 0, BreadthFirst, func(parent int) []int {
+		if parent == 0 {
+			return children
+		}
+
+		<-release
+
+		return nil
+	})
+
+	results := make(chan []Node[int])
+
+	go func() {
+		results <- collect(out)
+	}()
+
+	require.Eventually(t, func() bool {
+		return workers() == 4
+	}, 5*time.Second, time.Millisecond)
+
+	close(release)
+
+	require.Len(t, <-results, 101)
+	requireNoLeaks(t, goroutines)
+
+	require.Panics(t, func() {
+		wp.ListScaled(ctx, Scaling{Min: 2, Max: 1}, 0, func(int) []int {
+			return nil
+		})
+	})
+}
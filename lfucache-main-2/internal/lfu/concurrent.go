@@ -0,0 +1,265 @@
+package lfu
+
+import (
+	"iter"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// readBufferSize is the number of hits a single stripe can hold before it is drained.
+	// It must be a power of two.
+	readBufferSize = 64
+
+	// readBuffersPerProc is the number of stripes allocated per GOMAXPROCS.
+	readBuffersPerProc = 4
+
+	// cacheLineSize is used to pad stripes so that they do not share cache lines.
+	cacheLineSize = 64
+)
+
+// concurrentEntry is a record shared between the lock-free index and the LFU policy.
+// The value is replaced atomically, so readers never need the policy lock.
+type concurrentEntry[K comparable, V any] struct {
+	key   K
+	value atomic.Pointer[V]
+}
+
+// readBuffer is a lossy bounded ring buffer of recorded hits.
+// Many goroutines may offer hits concurrently, but only the goroutine holding
+// the policy lock drains it.
+type readBuffer[K comparable, V any] struct {
+	// head is the next slot to drain, it is advanced only by the drainer.
+	head atomic.Uint64
+	_    [cacheLineSize - 8]byte
+
+	// tail is the next slot to claim by a writer.
+	tail atomic.Uint64
+	_    [cacheLineSize - 8]byte
+
+	slots [readBufferSize]atomic.Pointer[concurrentEntry[K, V]]
+}
+
+// offer records the hit. It returns false if the buffer is full or if another
+// writer claimed the same slot concurrently; the hit is not recorded in this case.
+func (b *readBuffer[K, V]) offer(e *concurrentEntry[K, V]) bool {
+	tail := b.tail.Load()
+	if tail-b.head.Load() >= readBufferSize {
+		return false
+	}
+
+	if !b.tail.CompareAndSwap(tail, tail+1) {
+		return false
+	}
+
+	b.slots[tail&(readBufferSize-1)].Store(e)
+
+	return true
+}
+
+// drain applies all claimed hits in the order they were claimed.
+// A writer publishes its hit right after claiming the slot, so the drainer waits
+// for the claimed slots that are not published yet, and no hit recorded before
+// the drain is left to the next one.
+// Must be called with the policy lock held.
+func (b *readBuffer[K, V]) drain(apply func(*concurrentEntry[K, V])) {
+	head := b.head.Load()
+	tail := b.tail.Load()
+
+	for ; head != tail; head++ {
+		slot := &b.slots[head&(readBufferSize-1)]
+
+		e := slot.Load()
+		for e == nil {
+			runtime.Gosched()
+			e = slot.Load()
+		}
+
+		slot.Store(nil)
+		apply(e)
+	}
+
+	b.head.Store(head)
+}
+
+// concurrentCacheImpl is a thread-safe LFU cache with a mostly lock-free read path.
+//
+// Values are looked up in a concurrent index without locking, and hits are recorded
+// into striped lossy ring buffers instead of updating frequencies in place.
+// The buffers are drained in batches under the policy lock: by writers, by the methods
+// that inspect the LFU order and by a reader that finds its stripe full.
+//
+// Consistency guarantees:
+//   - Get and Put are linearizable with respect to values: Get observes the value of
+//     the latest Put of the key that completed before it, unless the key was evicted.
+//   - Frequencies and the LFU order are eventually consistent. A hit becomes visible
+//     to GetKeyFrequency, All and eviction no later than the next drain.
+//   - GetKeyFrequency, All and Put drain every stripe first, so they account for all
+//     hits recorded by Gets that completed before the call.
+//   - Hits may be dropped under contention: when a stripe is full while another goroutine
+//     holds the policy lock, or when two readers race for the same slot. Therefore under
+//     concurrent reads the reported frequency is a lower bound of the number of accesses.
+//     If operations never overlap in time (e.g. a single goroutine), no hits are dropped
+//     and the reported frequencies are exact.
+//   - Hits from different stripes are applied stripe by stripe, so the recency order
+//     among entries with equal frequency may differ from the order of the Gets.
+type concurrentCacheImpl[K comparable, V any] struct {
+	// index maps keys to *concurrentEntry[K, V], it is modified only under mu.
+	index sync.Map
+
+	// mu guards the policy and draining of the buffers.
+	mu     sync.Mutex
+	policy *cacheImpl[K, *concurrentEntry[K, V]]
+
+	// buffers are the read buffer stripes, len(buffers) is a power of two.
+	buffers []readBuffer[K, V]
+}
+
+// NewConcurrent initializes the thread-safe cache with the given capacity.
+// If no capacity is provided, the cache will use DefaultCapacity.
+func NewConcurrent[K comparable, V any](capacity ...int) *concurrentCacheImpl[K, V] {
+	stripes := 1
+	for stripes < readBuffersPerProc*runtime.GOMAXPROCS(0) {
+		stripes <<= 1
+	}
+
+	return &concurrentCacheImpl[K, V]{
+		policy:  New[K, *concurrentEntry[K, V]](capacity...),
+		buffers: make([]readBuffer[K, V], stripes),
+	}
+}
+
+// Get returns the value of the key if the key exists in the cache,
+// otherwise, returns ErrKeyNotFound.
+//
+// It does not acquire the policy lock unless the selected stripe is full.
+func (l *concurrentCacheImpl[K, V]) Get(key K) (V, error) {
+	v, ok := l.index.Load(key)
+	if !ok {
+		var zero V
+		return zero, ErrKeyNotFound
+	}
+
+	e := v.(*concurrentEntry[K, V])
+	value := *e.value.Load()
+
+	l.recordHit(e)
+
+	return value, nil
+}
+
+func (l *concurrentCacheImpl[K, V]) Put(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.drain()
+
+	if node, ok := l.policy.nodes[key]; ok {
+		node.Value.value.value.Store(&value)
+		l.policy.increment(node)
+
+		return
+	}
+
+	e := &concurrentEntry[K, V]{key: key}
+	e.value.Store(&value)
+
+	evicted, ok := l.policy.insert(key, e)
+	if ok {
+		l.index.Delete(evicted.key)
+	}
+
+	if _, ok = l.policy.nodes[key]; ok {
+		l.index.Store(key, e)
+	}
+}
+
+// All returns the iterator in descending order of frequency.
+// If two or more keys have the same frequency, the most recently used key will be listed first.
+//
+// The iterator yields a snapshot taken at the time of the call,
+// so it is safe to use the cache while iterating.
+func (l *concurrentCacheImpl[K, V]) All() iter.Seq2[K, V] {
+	l.mu.Lock()
+
+	l.drain()
+
+	keys := make([]K, 0, l.policy.Size())
+	values := make([]V, 0, l.policy.Size())
+
+	for key, e := range l.policy.All() {
+		keys = append(keys, key)
+		values = append(values, *e.value.Load())
+	}
+
+	l.mu.Unlock()
+
+	return func(yield func(K, V) bool) {
+		for i := range keys {
+			if !yield(keys[i], values[i]) {
+				return
+			}
+		}
+	}
+}
+
+func (l *concurrentCacheImpl[K, V]) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.policy.Size()
+}
+
+func (l *concurrentCacheImpl[K, V]) Capacity() int {
+	return l.policy.Capacity()
+}
+
+// GetKeyFrequency returns the element's frequency if the key exists in the cache,
+// otherwise, returns ErrKeyNotFound.
+//
+// The pending hits are drained first, see concurrentCacheImpl for the guarantees.
+func (l *concurrentCacheImpl[K, V]) GetKeyFrequency(key K) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.drain()
+
+	return l.policy.GetKeyFrequency(key)
+}
+
+// recordHit puts the hit into a random stripe. If the stripe is full, the reader tries
+// to drain all stripes itself and drops the hit if the policy lock is busy.
+func (l *concurrentCacheImpl[K, V]) recordHit(e *concurrentEntry[K, V]) {
+	b := &l.buffers[rand.Uint32()&uint32(len(l.buffers)-1)]
+	if b.offer(e) {
+		return
+	}
+
+	if !l.mu.TryLock() {
+		return
+	}
+
+	l.drain()
+	l.applyHit(e)
+
+	l.mu.Unlock()
+}
+
+// drain applies the pending hits of all stripes to the policy.
+// Must be called with mu held.
+func (l *concurrentCacheImpl[K, V]) drain() {
+	for i := range l.buffers {
+		l.buffers[i].drain(l.applyHit)
+	}
+}
+
+// applyHit increments the frequency of the entry if it is still in the cache.
+// Hits of evicted or replaced entries are ignored.
+// Must be called with mu held.
+func (l *concurrentCacheImpl[K, V]) applyHit(e *concurrentEntry[K, V]) {
+	if node, ok := l.policy.nodes[e.key]; ok && node.Value.value == e {
+		l.policy.increment(node)
+	}
+}
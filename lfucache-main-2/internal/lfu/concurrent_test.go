@@ -0,0 +1,251 @@
+package lfu
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// must compile
+func testConcurrentImplements[K comparable, V any]() Cache[K, V] {
+	return NewConcurrent[K, V](1)
+}
+
+func TestConcurrentSequentialFrequency(t *testing.T) {
+	t.Parallel()
+
+	cache := NewConcurrent[int, int](3)
+
+	cache.Put(1, 1)
+	cache.Put(2, 4)
+	cache.Put(3, 9)
+
+	for range 1000 {
+		value, err := cache.Get(1)
+		require.NoError(t, err)
+		require.Equal(t, 1, value)
+	}
+
+	_, _ = cache.Get(2)
+
+	frequency, err := cache.GetKeyFrequency(1)
+	require.NoError(t, err)
+	require.Equal(t, 1001, frequency)
+
+	frequency, err = cache.GetKeyFrequency(2)
+	require.NoError(t, err)
+	require.Equal(t, 2, frequency)
+
+	keys, values := collect(cache.All())
+	require.Equal(t, []int{1, 2, 3}, keys)
+	require.Equal(t, []int{1, 4, 9}, values)
+}
+
+func TestConcurrentEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := NewConcurrent[int, int](2)
+	cache.Put(1, 10)
+	cache.Put(2, 20)
+
+	_, _ = cache.Get(1)
+	cache.Put(3, 30)
+
+	_, err := cache.Get(2)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	value, err := cache.Get(1)
+	require.NoError(t, err)
+	require.Equal(t, 10, value)
+
+	value, err = cache.Get(3)
+	require.NoError(t, err)
+	require.Equal(t, 30, value)
+
+	require.Equal(t, 2, cache.Size())
+	require.Equal(t, 2, cache.Capacity())
+}
+
+func TestConcurrentZeroCapacity(t *testing.T) {
+	t.Parallel()
+
+	cache := NewConcurrent[int, int](0)
+	cache.Put(1, 1)
+
+	_, err := cache.Get(1)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.Zero(t, cache.Size())
+}
+
+func TestConcurrentDefaultCapacity(t *testing.T) {
+	t.Parallel()
+
+	cache := NewConcurrent[int, int]()
+	require.Equal(t, DefaultCapacity, cache.Capacity())
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	t.Parallel()
+
+	const (
+		capacity   = 16
+		goroutines = 32
+		operations = 10_000
+	)
+
+	cache := NewConcurrent[int, int](capacity)
+	wg := new(sync.WaitGroup)
+
+	for g := range goroutines {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range operations {
+				key := rand.N(capacity * 2)
+
+				switch {
+				case i%10 == 0:
+					cache.Put(key, key*key)
+				case g%8 == 0 && i%100 == 0:
+					_, _ = collect(cache.All())
+				default:
+					if value, err := cache.Get(key); err == nil {
+						assert.Equal(t, key*key, value)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.LessOrEqual(t, cache.Size(), capacity)
+
+	keys, values := collect(cache.All())
+	frequencies := make([]int, 0, len(keys))
+
+	for i, k := range keys {
+		require.Equal(t, k*k, values[i])
+
+		frequency, err := cache.GetKeyFrequency(k)
+		require.NoError(t, err)
+		require.LessOrEqual(t, frequency, goroutines*operations)
+
+		frequencies = append(frequencies, frequency)
+	}
+
+	require.True(t, slices.IsSortedFunc(frequencies, func(a, b int) int {
+		return b - a
+	}))
+}
+
+func TestConcurrentDrainWaitsForClaimedSlots(t *testing.T) {
+	t.Parallel()
+
+	b := new(readBuffer[int, int])
+	first := &concurrentEntry[int, int]{key: 1}
+	second := &concurrentEntry[int, int]{key: 2}
+
+	// a writer claimed the first slot but has not published its hit yet,
+	// while the hit of a later writer is already published
+	b.tail.Store(1)
+	require.True(t, b.offer(second))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.slots[0].Store(first)
+	}()
+
+	var applied []int
+
+	b.drain(func(e *concurrentEntry[int, int]) {
+		applied = append(applied, e.key)
+	})
+
+	require.Equal(t, []int{1, 2}, applied)
+	require.EqualValues(t, 2, b.head.Load())
+}
+
+// benchmarkCache is the subset of Cache used by the benchmarks.
+type benchmarkCache interface {
+	Get(key int) (int, error)
+	Put(key int, value int)
+}
+
+// mutexCache is the baseline for the benchmarks: every operation takes the write lock.
+type mutexCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	cache *cacheImpl[K, V]
+}
+
+func (m *mutexCache[K, V]) Get(key K) (V, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cache.Get(key)
+}
+
+func (m *mutexCache[K, V]) Put(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cache.Put(key, value)
+}
+
+func BenchmarkConcurrentGet(b *testing.B) {
+	const capacity = 1024
+
+	caches := []struct {
+		name string
+		new  func() benchmarkCache
+	}{
+		{
+			name: "buffered",
+			new: func() benchmarkCache {
+				return NewConcurrent[int, int](capacity)
+			},
+		},
+		{
+			name: "mutex",
+			new: func() benchmarkCache {
+				return &mutexCache[int, int]{cache: New[int, int](capacity)}
+			},
+		},
+	}
+
+	for _, c := range caches {
+		for goroutines := 1; goroutines <= 64; goroutines *= 2 {
+			b.Run(fmt.Sprintf("%s/goroutines-%d", c.name, goroutines), func(b *testing.B) {
+				cache := c.new()
+				for i := range capacity {
+					cache.Put(i, i)
+				}
+
+				wg := new(sync.WaitGroup)
+				b.ResetTimer()
+
+				for g := range goroutines {
+					wg.Add(1)
+
+					go func() {
+						defer wg.Done()
+
+						for i := g; i < b.N; i += goroutines {
+							_, _ = cache.Get(i % capacity)
+						}
+					}()
+				}
+
+				wg.Wait()
+			})
+		}
+	}
+}
@@ -3,6 +3,8 @@ package lfu
 import (
 	"errors"
 	"iter"
+
+	"lfucache/internal/linkedlist"
 )
 
 var ErrKeyNotFound = errors.New("key not found")
@@ -50,35 +52,195 @@ type Cache[K comparable, V any] interface {
 	GetKeyFrequency(key K) (int, error)
 }
 
+// entry is a single cache record.
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+
+	// bucket is the node of the frequency bucket the entry belongs to.
+	bucket *linkedlist.Node[bucket[K, V]]
+}
+
+// bucket groups entries with the same frequency.
+// Entries are ordered from the most recently used to the least recently used one.
+type bucket[K comparable, V any] struct {
+	frequency int
+	entries   linkedlist.List[entry[K, V]]
+}
+
 // cacheImpl represents LFU cache implementation
-type cacheImpl[K comparable, V any] struct{}
+type cacheImpl[K comparable, V any] struct {
+	capacity int
+
+	// nodes maps keys to their entries inside the frequency buckets.
+	nodes map[K]*linkedlist.Node[entry[K, V]]
+
+	// buckets are ordered by ascending frequency, empty buckets are removed,
+	// so the least frequently used entry is always at the back of the front bucket.
+	buckets linkedlist.List[bucket[K, V]]
+}
 
 // New initializes the cache with the given capacity.
 // If no capacity is provided, the cache will use DefaultCapacity.
 func New[K comparable, V any](capacity ...int) *cacheImpl[K, V] {
-	return new(cacheImpl[K, V])
+	c := DefaultCapacity
+	if len(capacity) > 0 {
+		c = capacity[0]
+	}
+
+	if c < 0 {
+		panic("lfu: negative capacity")
+	}
+
+	return &cacheImpl[K, V]{
+		capacity: c,
+		nodes:    make(map[K]*linkedlist.Node[entry[K, V]]),
+	}
 }
 
 func (l *cacheImpl[K, V]) Get(key K) (V, error) {
-	panic("not implemented")
+	node, ok := l.nodes[key]
+	if !ok {
+		var zero V
+		return zero, ErrKeyNotFound
+	}
+
+	l.increment(node)
+
+	return node.Value.value, nil
 }
 
 func (l *cacheImpl[K, V]) Put(key K, value V) {
-	panic("not implemented")
+	if node, ok := l.nodes[key]; ok {
+		node.Value.value = value
+		l.increment(node)
+
+		return
+	}
+
+	l.insert(key, value)
 }
 
 func (l *cacheImpl[K, V]) All() iter.Seq2[K, V] {
-	panic("not implemented")
+	return func(yield func(K, V) bool) {
+		for b := l.buckets.Back(); b != nil; b = b.Prev() {
+			for e := range b.Value.entries.All() {
+				if !yield(e.key, e.value) {
+					return
+				}
+			}
+		}
+	}
 }
 
 func (l *cacheImpl[K, V]) Size() int {
-	panic("not implemented")
+	return len(l.nodes)
 }
 
 func (l *cacheImpl[K, V]) Capacity() int {
-	panic("not implemented")
+	return l.capacity
 }
 
 func (l *cacheImpl[K, V]) GetKeyFrequency(key K) (int, error) {
-	panic("not implemented")
+	node, ok := l.nodes[key]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+
+	return node.Value.bucket.Value.frequency, nil
+}
+
+// insert adds a new key with frequency 1, evicting the least frequently used entry
+// if the cache is full. It reports the evicted entry, if any.
+// The key must not be present in the cache.
+func (l *cacheImpl[K, V]) insert(key K, value V) (evicted entry[K, V], ok bool) {
+	if l.capacity == 0 {
+		return evicted, false
+	}
+
+	if len(l.nodes) == l.capacity {
+		evicted, ok = l.evict(), true
+	}
+
+	l.place(key, value, 1)
+
+	return evicted, ok
+}
+
+// place adds a new key with the given frequency as the most recently used entry
+// of its bucket, regardless of the capacity. The key must not be present in the cache.
+//
+// O(1) for frequency 1, otherwise O(number of buckets with a lower frequency)
+func (l *cacheImpl[K, V]) place(key K, value V, frequency int) {
+	var (
+		prev *linkedlist.Node[bucket[K, V]]
+		next = l.buckets.Front()
+	)
+
+	for next != nil && next.Value.frequency < frequency {
+		prev, next = next, next.Next()
+	}
+
+	if next == nil || next.Value.frequency != frequency {
+		if prev == nil {
+			next = l.buckets.PushFront(bucket[K, V]{frequency: frequency})
+		} else {
+			next = l.buckets.InsertAfter(bucket[K, V]{frequency: frequency}, prev)
+		}
+	}
+
+	l.nodes[key] = next.Value.entries.PushFront(entry[K, V]{
+		key:    key,
+		value:  value,
+		bucket: next,
+	})
+}
+
+// remove deletes the entry from the cache and returns it with its frequency.
+func (l *cacheImpl[K, V]) remove(node *linkedlist.Node[entry[K, V]]) (entry[K, V], int) {
+	current := node.Value.bucket
+	frequency := current.Value.frequency
+
+	removed := current.Value.entries.Remove(node)
+	if current.Value.entries.Len() == 0 {
+		l.buckets.Remove(current)
+	}
+
+	delete(l.nodes, removed.key)
+
+	return removed, frequency
+}
+
+// evict removes the least recently used entry among the least frequently used ones.
+func (l *cacheImpl[K, V]) evict() entry[K, V] {
+	victim, _ := l.remove(l.buckets.Front().Value.entries.Back())
+
+	return victim
+}
+
+// increment moves the entry to the bucket with the next frequency
+// and marks it as the most recently used one there.
+func (l *cacheImpl[K, V]) increment(node *linkedlist.Node[entry[K, V]]) {
+	current := node.Value.bucket
+	frequency := current.Value.frequency + 1
+	next := current.Next()
+	hasNext := next != nil && next.Value.frequency == frequency
+
+	// the only entry of the bucket, the bucket itself can be reused
+	if !hasNext && current.Value.entries.Len() == 1 {
+		current.Value.frequency = frequency
+		return
+	}
+
+	if !hasNext {
+		next = l.buckets.InsertAfter(bucket[K, V]{frequency: frequency}, current)
+	}
+
+	current.Value.entries.Remove(node)
+	next.Value.entries.PushFrontNode(node)
+	node.Value.bucket = next
+
+	if current.Value.entries.Len() == 0 {
+		l.buckets.Remove(current)
+	}
 }
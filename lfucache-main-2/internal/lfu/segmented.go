@@ -0,0 +1,230 @@
+package lfu
+
+import (
+	"iter"
+
+	"lfucache/internal/linkedlist"
+)
+
+// promotionFrequency is the frequency at which a probation entry is promoted,
+// i.e. the insertion plus two hits.
+const promotionFrequency = 3
+
+// Segment identifies the part of a segmented cache an entry belongs to.
+type Segment int
+
+const (
+	// Probation holds new entries and entries demoted from the protected segment.
+	Probation Segment = iota
+
+	// Protected holds entries that were hit at least twice.
+	Protected
+)
+
+// String returns the segment name.
+func (s Segment) String() string {
+	switch s {
+	case Probation:
+		return "probation"
+	case Protected:
+		return "protected"
+	default:
+		return "unknown"
+	}
+}
+
+// SegmentedEntry is a value of a segmented cache together with its segment.
+type SegmentedEntry[V any] struct {
+	Value   V
+	Segment Segment
+}
+
+// SegmentedCache is an LFU cache split into probation and protected segments,
+// so that scans of keys used only once do not evict keys that are used repeatedly.
+// O(capacity) memory
+type SegmentedCache[K comparable, V any] interface {
+	// Get returns the value of the key if the key exists in the cache,
+	// otherwise, returns ErrKeyNotFound.
+	//
+	// A probation entry is promoted to the protected segment on its second hit.
+	// If the protected segment is full, its least frequently used entry is demoted
+	// to the probation segment, keeping its frequency.
+	//
+	// O(1), except moving an entry between the segments,
+	// which is O(number of distinct frequencies in the target segment)
+	Get(key K) (V, error)
+
+	// Put updates the value of the key if present, counting it as a hit,
+	// or inserts the key into the probation segment if not already present.
+	//
+	// When the cache reaches its capacity, the least frequently used key of the probation
+	// segment is invalidated, the protected segment is used only if probation is empty.
+	// Ties are broken by invalidating the least recently used key.
+	//
+	// O(1), except moving an entry between the segments,
+	// which is O(number of distinct frequencies in the target segment)
+	Put(key K, value V)
+
+	// All returns the iterator in reverse eviction order: protected entries first,
+	// then probation ones, each segment in descending order of frequency.
+	// If two or more keys of a segment have the same frequency,
+	// the most recently used key will be listed first.
+	//
+	// O(capacity)
+	All() iter.Seq2[K, SegmentedEntry[V]]
+
+	// Size returns the cache size.
+	//
+	// O(1), not amortized
+	Size() int
+
+	// Capacity returns the cache capacity.
+	//
+	// O(1), not amortized
+	Capacity() int
+
+	// ProtectedCapacity returns the maximum size of the protected segment.
+	//
+	// O(1), not amortized
+	ProtectedCapacity() int
+
+	// GetKeyFrequency returns the element's frequency if the key exists in the cache,
+	// otherwise, returns ErrKeyNotFound.
+	//
+	// O(1), not amortized
+	GetKeyFrequency(key K) (int, error)
+}
+
+// segmentedCacheImpl represents segmented LFU cache implementation.
+// Both segments are LFU caches whose own capacity is never reached,
+// the total and the protected capacities are enforced here.
+type segmentedCacheImpl[K comparable, V any] struct {
+	capacity          int
+	protectedCapacity int
+
+	probation *cacheImpl[K, V]
+	protected *cacheImpl[K, V]
+}
+
+// NewSegmented initializes the segmented cache with the given total capacity,
+// at most protectedCapacity entries of which may be protected.
+// It panics if a capacity is negative or protectedCapacity exceeds capacity.
+func NewSegmented[K comparable, V any](capacity, protectedCapacity int) *segmentedCacheImpl[K, V] {
+	if capacity < 0 || protectedCapacity < 0 {
+		panic("lfu: negative capacity")
+	}
+
+	if protectedCapacity > capacity {
+		panic("lfu: protected capacity exceeds capacity")
+	}
+
+	return &segmentedCacheImpl[K, V]{
+		capacity:          capacity,
+		protectedCapacity: protectedCapacity,
+		probation:         New[K, V](capacity),
+		protected:         New[K, V](protectedCapacity),
+	}
+}
+
+func (l *segmentedCacheImpl[K, V]) Get(key K) (V, error) {
+	if node, ok := l.protected.nodes[key]; ok {
+		l.protected.increment(node)
+		return node.Value.value, nil
+	}
+
+	node, ok := l.probation.nodes[key]
+	if !ok {
+		var zero V
+		return zero, ErrKeyNotFound
+	}
+
+	value := node.Value.value
+	l.hitProbation(node)
+
+	return value, nil
+}
+
+func (l *segmentedCacheImpl[K, V]) Put(key K, value V) {
+	if node, ok := l.protected.nodes[key]; ok {
+		node.Value.value = value
+		l.protected.increment(node)
+
+		return
+	}
+
+	if node, ok := l.probation.nodes[key]; ok {
+		node.Value.value = value
+		l.hitProbation(node)
+
+		return
+	}
+
+	if l.capacity == 0 {
+		return
+	}
+
+	if l.Size() == l.capacity {
+		if l.probation.Size() > 0 {
+			l.probation.evict()
+		} else {
+			l.protected.evict()
+		}
+	}
+
+	l.probation.place(key, value, 1)
+}
+
+func (l *segmentedCacheImpl[K, V]) All() iter.Seq2[K, SegmentedEntry[V]] {
+	return func(yield func(K, SegmentedEntry[V]) bool) {
+		for key, value := range l.protected.All() {
+			if !yield(key, SegmentedEntry[V]{Value: value, Segment: Protected}) {
+				return
+			}
+		}
+
+		for key, value := range l.probation.All() {
+			if !yield(key, SegmentedEntry[V]{Value: value, Segment: Probation}) {
+				return
+			}
+		}
+	}
+}
+
+func (l *segmentedCacheImpl[K, V]) Size() int {
+	return l.probation.Size() + l.protected.Size()
+}
+
+func (l *segmentedCacheImpl[K, V]) Capacity() int {
+	return l.capacity
+}
+
+func (l *segmentedCacheImpl[K, V]) ProtectedCapacity() int {
+	return l.protectedCapacity
+}
+
+func (l *segmentedCacheImpl[K, V]) GetKeyFrequency(key K) (int, error) {
+	if frequency, err := l.protected.GetKeyFrequency(key); err == nil {
+		return frequency, nil
+	}
+
+	return l.probation.GetKeyFrequency(key)
+}
+
+// hitProbation increments the frequency of the probation entry
+// and promotes it once it reaches promotionFrequency.
+func (l *segmentedCacheImpl[K, V]) hitProbation(node *linkedlist.Node[entry[K, V]]) {
+	l.probation.increment(node)
+
+	if l.protectedCapacity == 0 || node.Value.bucket.Value.frequency < promotionFrequency {
+		return
+	}
+
+	promoted, frequency := l.probation.remove(node)
+
+	if l.protected.Size() == l.protectedCapacity {
+		demoted, demotedFrequency := l.protected.remove(l.protected.buckets.Front().Value.entries.Back())
+		l.probation.place(demoted.key, demoted.value, demotedFrequency)
+	}
+
+	l.protected.place(promoted.key, promoted.value, frequency)
+}
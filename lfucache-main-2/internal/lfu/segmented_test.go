@@ -0,0 +1,166 @@
+package lfu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// must compile
+func testSegmentedImplements[K comparable, V any]() SegmentedCache[K, V] {
+	return NewSegmented[K, V](2, 1)
+}
+
+func TestSegmentedPromotion(t *testing.T) {
+	t.Parallel()
+
+	cache := NewSegmented[int, int](3, 1)
+
+	cache.Put(1, 10)
+	cache.Put(2, 20)
+
+	_, _ = cache.Get(1)
+	require.Equal(t, map[int]SegmentedEntry[int]{
+		1: {Value: 10, Segment: Probation},
+		2: {Value: 20, Segment: Probation},
+	}, collectMap(cache))
+
+	_, _ = cache.Get(1)
+	require.Equal(t, map[int]SegmentedEntry[int]{
+		1: {Value: 10, Segment: Protected},
+		2: {Value: 20, Segment: Probation},
+	}, collectMap(cache))
+
+	frequency, err := cache.GetKeyFrequency(1)
+	require.NoError(t, err)
+	require.Equal(t, 3, frequency)
+}
+
+func TestSegmentedDemotion(t *testing.T) {
+	t.Parallel()
+
+	cache := NewSegmented[int, int](3, 1)
+
+	cache.Put(1, 10)
+	cache.Put(1, 11)
+	cache.Put(1, 12)
+	cache.Put(1, 13)
+
+	cache.Put(2, 20)
+	_, _ = cache.Get(2)
+	_, _ = cache.Get(2)
+
+	keys, values := collect(cache.All())
+	require.Equal(t, []int{2, 1}, keys)
+	require.Equal(t, []SegmentedEntry[int]{
+		{Value: 20, Segment: Protected},
+		{Value: 13, Segment: Probation},
+	}, values)
+
+	frequency, err := cache.GetKeyFrequency(1)
+	require.NoError(t, err)
+	require.Equal(t, 4, frequency)
+
+	// any hit of a demoted entry promotes it again
+	_, _ = cache.Get(1)
+	require.Equal(t, Protected, collectMap(cache)[1].Segment)
+	require.Equal(t, Probation, collectMap(cache)[2].Segment)
+}
+
+func TestSegmentedScanResistance(t *testing.T) {
+	t.Parallel()
+
+	cache := NewSegmented[int, int](4, 2)
+
+	cache.Put(-1, -1)
+	cache.Put(-2, -2)
+
+	for range 2 {
+		_, _ = cache.Get(-1)
+		_, _ = cache.Get(-2)
+	}
+
+	for i := range 100 {
+		cache.Put(i, i)
+	}
+
+	for _, key := range []int{-1, -2} {
+		value, err := cache.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, key, value)
+	}
+
+	require.Equal(t, 4, cache.Size())
+
+	keys, values := collect(cache.All())
+	require.Equal(t, []int{-2, -1, 99, 98}, keys)
+	require.Equal(t, []SegmentedEntry[int]{
+		{Value: -2, Segment: Protected},
+		{Value: -1, Segment: Protected},
+		{Value: 99, Segment: Probation},
+		{Value: 98, Segment: Probation},
+	}, values)
+}
+
+func TestSegmentedEvictsProtectedWhenProbationEmpty(t *testing.T) {
+	t.Parallel()
+
+	cache := NewSegmented[int, int](2, 2)
+
+	for _, key := range []int{1, 2} {
+		for range 3 {
+			cache.Put(key, key)
+		}
+	}
+
+	_, _ = cache.Get(2)
+	cache.Put(3, 3)
+
+	_, err := cache.Get(1)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.Equal(t, map[int]SegmentedEntry[int]{
+		2: {Value: 2, Segment: Protected},
+		3: {Value: 3, Segment: Probation},
+	}, collectMap(cache))
+}
+
+func TestSegmentedWithoutProtected(t *testing.T) {
+	t.Parallel()
+
+	cache := NewSegmented[int, int](2, 0)
+
+	for range 5 {
+		cache.Put(1, 1)
+	}
+
+	cache.Put(2, 2)
+	cache.Put(3, 3)
+
+	require.Equal(t, map[int]SegmentedEntry[int]{
+		1: {Value: 1, Segment: Probation},
+		3: {Value: 3, Segment: Probation},
+	}, collectMap(cache))
+}
+
+func TestSegmentedInvalidCapacityPanics(t *testing.T) {
+	t.Parallel()
+
+	require.Panics(t, func() {
+		NewSegmented[int, int](-1, 0)
+	})
+
+	require.Panics(t, func() {
+		NewSegmented[int, int](1, 2)
+	})
+}
+
+func collectMap[K comparable, V any](cache SegmentedCache[K, V]) map[K]SegmentedEntry[V] {
+	result := make(map[K]SegmentedEntry[V])
+
+	for k, v := range cache.All() {
+		result[k] = v
+	}
+
+	return result
+}
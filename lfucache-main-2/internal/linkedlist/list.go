@@ -0,0 +1,248 @@
+package linkedlist
+
+import "iter"
+
+// LinkedList is a generic doubly linked list.
+// The zero value of a list implementation is an empty list ready to use.
+type LinkedList[T any] interface {
+	// Len returns the number of nodes in the list.
+	//
+	// O(1)
+	Len() int
+
+	// Front returns the first node of the list or nil if the list is empty.
+	//
+	// O(1)
+	Front() *Node[T]
+
+	// Back returns the last node of the list or nil if the list is empty.
+	//
+	// O(1)
+	Back() *Node[T]
+
+	// PushFront inserts a new node with the given value at the front of the list
+	// and returns it.
+	//
+	// O(1)
+	PushFront(value T) *Node[T]
+
+	// PushBack inserts a new node with the given value at the back of the list
+	// and returns it.
+	//
+	// O(1)
+	PushBack(value T) *Node[T]
+
+	// PushFrontNode inserts a detached node at the front of the list and returns it.
+	// It allows moving nodes between lists without allocations: a node becomes
+	// detached after Remove.
+	//
+	// O(1)
+	PushFrontNode(node *Node[T]) *Node[T]
+
+	// InsertBefore inserts a new node with the given value right before mark
+	// and returns it. The mark must be a node of the list.
+	//
+	// O(1)
+	InsertBefore(value T, mark *Node[T]) *Node[T]
+
+	// InsertAfter inserts a new node with the given value right after mark
+	// and returns it. The mark must be a node of the list.
+	//
+	// O(1)
+	InsertAfter(value T, mark *Node[T]) *Node[T]
+
+	// MoveToFront moves the node to the front of the list.
+	// The node must be a node of the list.
+	//
+	// O(1)
+	MoveToFront(node *Node[T])
+
+	// Remove removes the node from the list and returns its value.
+	// The node must be a node of the list.
+	//
+	// O(1)
+	Remove(node *Node[T]) T
+
+	// All returns the iterator over the values from front to back.
+	//
+	// O(len)
+	All() iter.Seq[T]
+
+	// Backward returns the iterator over the values from back to front.
+	//
+	// O(len)
+	Backward() iter.Seq[T]
+}
+
+// Node is an element of the linked list.
+type Node[T any] struct {
+	// Value is the value stored in the node.
+	Value T
+
+	// prev and next are the neighbours of the node, the list sentinel
+	// is used instead of nil inside the list.
+	prev, next *Node[T]
+
+	// list is the list the node belongs to, nil for removed nodes.
+	list *List[T]
+}
+
+// Next returns the next node or nil if the node is the last one.
+func (n *Node[T]) Next() *Node[T] {
+	if next := n.next; n.list != nil && next != &n.list.root {
+		return next
+	}
+
+	return nil
+}
+
+// Prev returns the previous node or nil if the node is the first one.
+func (n *Node[T]) Prev() *Node[T] {
+	if prev := n.prev; n.list != nil && prev != &n.list.root {
+		return prev
+	}
+
+	return nil
+}
+
+var _ LinkedList[int] = (*List[int])(nil)
+
+// List is a LinkedList implementation based on a circular list with a sentinel node.
+// A List must not be copied after first use.
+type List[T any] struct {
+	// root is the sentinel node, root.next is the front and root.prev is the back.
+	root Node[T]
+
+	// size is the number of nodes excluding the sentinel.
+	size int
+}
+
+// New returns an initialized empty list.
+func New[T any]() *List[T] {
+	return new(List[T]).init()
+}
+
+// init links the sentinel to itself.
+func (l *List[T]) init() *List[T] {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.size = 0
+
+	return l
+}
+
+// lazyInit initializes the zero value list on first use.
+func (l *List[T]) lazyInit() {
+	if l.root.next == nil {
+		l.init()
+	}
+}
+
+func (l *List[T]) Len() int {
+	return l.size
+}
+
+func (l *List[T]) Front() *Node[T] {
+	if l.size == 0 {
+		return nil
+	}
+
+	return l.root.next
+}
+
+func (l *List[T]) Back() *Node[T] {
+	if l.size == 0 {
+		return nil
+	}
+
+	return l.root.prev
+}
+
+func (l *List[T]) PushFront(value T) *Node[T] {
+	l.lazyInit()
+
+	return l.insert(&Node[T]{Value: value}, &l.root)
+}
+
+func (l *List[T]) PushBack(value T) *Node[T] {
+	l.lazyInit()
+
+	return l.insert(&Node[T]{Value: value}, l.root.prev)
+}
+
+func (l *List[T]) PushFrontNode(node *Node[T]) *Node[T] {
+	l.lazyInit()
+
+	return l.insert(node, &l.root)
+}
+
+func (l *List[T]) InsertBefore(value T, mark *Node[T]) *Node[T] {
+	return l.insert(&Node[T]{Value: value}, mark.prev)
+}
+
+func (l *List[T]) InsertAfter(value T, mark *Node[T]) *Node[T] {
+	return l.insert(&Node[T]{Value: value}, mark)
+}
+
+func (l *List[T]) MoveToFront(node *Node[T]) {
+	if l.root.next == node {
+		return
+	}
+
+	l.unlink(node)
+	l.link(node, &l.root)
+}
+
+func (l *List[T]) Remove(node *Node[T]) T {
+	l.unlink(node)
+	l.size--
+
+	node.prev = nil
+	node.next = nil
+	node.list = nil
+
+	return node.Value
+}
+
+func (l *List[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for node := l.Front(); node != nil; node = node.Next() {
+			if !yield(node.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (l *List[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for node := l.Back(); node != nil; node = node.Prev() {
+			if !yield(node.Value) {
+				return
+			}
+		}
+	}
+}
+
+// insert links the node after at and increments the list size.
+func (l *List[T]) insert(node, at *Node[T]) *Node[T] {
+	l.link(node, at)
+	l.size++
+
+	return node
+}
+
+// link places the node right after at.
+func (l *List[T]) link(node, at *Node[T]) {
+	node.prev = at
+	node.next = at.next
+	node.prev.next = node
+	node.next.prev = node
+	node.list = l
+}
+
+// unlink detaches the node from its neighbours.
+func (l *List[T]) unlink(node *Node[T]) {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+}